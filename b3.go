@@ -0,0 +1,180 @@
+package tracer
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// The B3 headers used by Zipkin, as described by
+// https://github.com/openzipkin/b3-propagation.
+const (
+	b3TraceIDHeader      = "X-B3-TraceId"
+	b3SpanIDHeader       = "X-B3-SpanId"
+	b3ParentSpanIDHeader = "X-B3-ParentSpanId"
+	b3SampledHeader      = "X-B3-Sampled"
+	b3FlagsHeader        = "X-B3-Flags"
+	b3SingleHeader       = "b3"
+)
+
+// B3Injecter is an Injecter for Zipkin's B3 format that uses multiple
+// headers (X-B3-TraceId, X-B3-SpanId and so on). Baggage is not
+// propagated.
+func B3Injecter(sm SpanContext, carrier interface{}) error {
+	w, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	w.Set(b3TraceIDHeader, FormatTraceID(sm.TraceIDHigh, sm.TraceID))
+	w.Set(b3SpanIDHeader, idToHex(sm.SpanID))
+	if sm.ParentID != 0 {
+		w.Set(b3ParentSpanIDHeader, idToHex(sm.ParentID))
+	}
+	switch {
+	case sm.Flags&FlagDebug != 0:
+		// Debug implies sampled, X-B3-Sampled must not be sent.
+		w.Set(b3FlagsHeader, "1")
+	case sm.Flags&FlagSampled != 0:
+		w.Set(b3SampledHeader, "1")
+	case sm.Flags&FlagSamplingDeferred != 0:
+		// Leave the decision to the receiver.
+	default:
+		w.Set(b3SampledHeader, "0")
+	}
+	return nil
+}
+
+// B3SingleInjecter is an Injecter for Zipkin's B3 format that uses
+// the single b3 header. Baggage is not propagated.
+func B3SingleInjecter(sm SpanContext, carrier interface{}) error {
+	w, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	v := FormatTraceID(sm.TraceIDHigh, sm.TraceID) + "-" + idToHex(sm.SpanID)
+	switch {
+	case sm.Flags&FlagDebug != 0:
+		v += "-d"
+	case sm.Flags&FlagSampled != 0:
+		v += "-1"
+	case sm.Flags&FlagSamplingDeferred != 0:
+		// Leave the decision to the receiver. The parent span ID
+		// can only follow a sampling state, so it is omitted, too.
+		w.Set(b3SingleHeader, v)
+		return nil
+	default:
+		v += "-0"
+	}
+	if sm.ParentID != 0 {
+		v += "-" + idToHex(sm.ParentID)
+	}
+	w.Set(b3SingleHeader, v)
+	return nil
+}
+
+// B3Extracter is an Extracter for Zipkin's B3 format. It accepts both
+// the single b3 header and multiple X-B3-* headers, preferring the
+// former if both are present. Trace IDs may have 64 or 128 bits. The
+// debug flag sets FlagDebug and FlagSampled. If the sampling state is
+// absent, FlagSamplingDeferred is set and the tracer's sampler decides
+// whether to sample the trace.
+//
+// B3 allows propagating only a sampling decision, without any IDs.
+// Because such a decision cannot be represented as a SpanContext,
+// B3Extracter returns opentracing.ErrSpanContextNotFound in that
+// case.
+func B3Extracter(carrier interface{}) (SpanContext, error) {
+	r, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+	var single string
+	var traceID, spanID, parentID, sampled, flags string
+	err := r.ForeachKey(func(key string, val string) error {
+		switch strings.ToLower(key) {
+		case "b3":
+			single = val
+		case "x-b3-traceid":
+			traceID = val
+		case "x-b3-spanid":
+			spanID = val
+		case "x-b3-parentspanid":
+			parentID = val
+		case "x-b3-sampled":
+			sampled = val
+		case "x-b3-flags":
+			flags = val
+		}
+		return nil
+	})
+	if err != nil {
+		return SpanContext{}, err
+	}
+	if single != "" {
+		parts := strings.Split(single, "-")
+		switch len(parts) {
+		case 1:
+			// Only a sampling decision.
+			return SpanContext{}, opentracing.ErrSpanContextNotFound
+		case 2, 3, 4:
+		default:
+			return SpanContext{}, opentracing.ErrSpanContextCorrupted
+		}
+		traceID, spanID, sampled, parentID, flags = parts[0], parts[1], "", "", ""
+		if len(parts) > 2 {
+			sampled = parts[2]
+			if sampled == "d" {
+				sampled, flags = "", "1"
+			}
+		}
+		if len(parts) > 3 {
+			parentID = parts[3]
+		}
+	}
+	if traceID == "" {
+		return SpanContext{}, opentracing.ErrSpanContextNotFound
+	}
+
+	ctx := SpanContext{Baggage: map[string]string{}}
+	ctx.TraceIDHigh, ctx.TraceID, err = ParseTraceID(traceID)
+	if err != nil || (ctx.TraceIDHigh == 0 && ctx.TraceID == 0) {
+		return SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	if ctx.SpanID, ok = parseB3SpanID(spanID); !ok {
+		return SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	if parentID != "" {
+		if ctx.ParentID, ok = parseB3SpanID(parentID); !ok {
+			return SpanContext{}, opentracing.ErrSpanContextCorrupted
+		}
+	}
+	switch sampled {
+	case "1", "true":
+		ctx.Flags |= FlagSampled
+	case "0", "false":
+	case "":
+		ctx.Flags |= FlagSamplingDeferred
+	default:
+		return SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	switch flags {
+	case "1":
+		ctx.Flags = ctx.Flags&^FlagSamplingDeferred | FlagDebug | FlagSampled
+	case "", "0":
+	default:
+		return SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	return ctx, nil
+}
+
+func parseB3SpanID(s string) (uint64, bool) {
+	if len(s) == 0 || len(s) > 16 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(s, 16, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return id, true
+}
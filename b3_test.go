@@ -0,0 +1,249 @@
+package tracer
+
+import (
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+func TestB3RoundTrip(t *testing.T) {
+	contexts := []SpanContext{
+		{TraceID: 3, SpanID: 1, ParentID: 2, Flags: FlagSampled},
+		{TraceID: 3, TraceIDHigh: 4, SpanID: 1},
+		{TraceID: 3, SpanID: 1, Flags: FlagSampled | FlagDebug},
+		{TraceID: 3, SpanID: 1, Flags: FlagSamplingDeferred},
+	}
+	injecters := map[string]Injecter{
+		"multi":  B3Injecter,
+		"single": B3SingleInjecter,
+	}
+	for name, injecter := range injecters {
+		for _, sm := range contexts {
+			carrier := opentracing.TextMapCarrier{}
+			if err := injecter(sm, carrier); err != nil {
+				t.Fatal("unexpected error: ", err)
+			}
+			context, err := B3Extracter(carrier)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", name, err)
+			}
+			if context.TraceID != sm.TraceID ||
+				context.TraceIDHigh != sm.TraceIDHigh ||
+				context.SpanID != sm.SpanID ||
+				context.ParentID != sm.ParentID ||
+				context.Flags != sm.Flags {
+
+				t.Errorf("%s: got %+v, want %+v", name, context, sm)
+			}
+		}
+	}
+
+	carrier := opentracing.TextMapCarrier{}
+	if err := B3SingleInjecter(contexts[0], carrier); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if got, want := carrier["b3"], "0000000000000003-0000000000000001-1-0000000000000002"; got != want {
+		t.Errorf("got b3 header %q, want %q", got, want)
+	}
+}
+
+func TestB3Extracter(t *testing.T) {
+	tests := []struct {
+		name    string
+		carrier opentracing.TextMapCarrier
+		want    SpanContext
+		err     error
+	}{
+		{
+			"multi",
+			opentracing.TextMapCarrier{
+				"X-B3-TraceId":      "80f198ee56343ba864fe8b2a57d3eff7",
+				"X-B3-SpanId":       "e457b5a2e4d86bd1",
+				"X-B3-ParentSpanId": "05e3ac9a4f6e3b90",
+				"X-B3-Sampled":      "1",
+			},
+			SpanContext{TraceIDHigh: 0x80f198ee56343ba8, TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1, ParentID: 0x05e3ac9a4f6e3b90, Flags: FlagSampled},
+			nil,
+		},
+		{
+			"lowercase keys",
+			opentracing.TextMapCarrier{
+				"x-b3-traceid": "64fe8b2a57d3eff7",
+				"x-b3-spanid":  "e457b5a2e4d86bd1",
+				"x-b3-sampled": "true",
+			},
+			SpanContext{TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1, Flags: FlagSampled},
+			nil,
+		},
+		{
+			"debug",
+			opentracing.TextMapCarrier{
+				"X-B3-TraceId": "64fe8b2a57d3eff7",
+				"X-B3-SpanId":  "e457b5a2e4d86bd1",
+				"X-B3-Flags":   "1",
+			},
+			SpanContext{TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1, Flags: FlagSampled | FlagDebug},
+			nil,
+		},
+		{
+			"not sampled",
+			opentracing.TextMapCarrier{
+				"X-B3-TraceId": "64fe8b2a57d3eff7",
+				"X-B3-SpanId":  "e457b5a2e4d86bd1",
+				"X-B3-Sampled": "0",
+			},
+			SpanContext{TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1},
+			nil,
+		},
+		{
+			"no sampled header",
+			opentracing.TextMapCarrier{
+				"X-B3-TraceId": "64fe8b2a57d3eff7",
+				"X-B3-SpanId":  "e457b5a2e4d86bd1",
+			},
+			SpanContext{TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1, Flags: FlagSamplingDeferred},
+			nil,
+		},
+		{
+			"single",
+			opentracing.TextMapCarrier{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90"},
+			SpanContext{TraceIDHigh: 0x80f198ee56343ba8, TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1, ParentID: 0x05e3ac9a4f6e3b90, Flags: FlagSampled},
+			nil,
+		},
+		{
+			"single without sampling state",
+			opentracing.TextMapCarrier{"b3": "64fe8b2a57d3eff7-e457b5a2e4d86bd1"},
+			SpanContext{TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1, Flags: FlagSamplingDeferred},
+			nil,
+		},
+		{
+			"single debug",
+			opentracing.TextMapCarrier{"b3": "64fe8b2a57d3eff7-e457b5a2e4d86bd1-d"},
+			SpanContext{TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1, Flags: FlagSampled | FlagDebug},
+			nil,
+		},
+		{
+			"single takes precedence",
+			opentracing.TextMapCarrier{
+				"b3":           "64fe8b2a57d3eff7-e457b5a2e4d86bd1-0",
+				"X-B3-TraceId": "1",
+				"X-B3-SpanId":  "2",
+				"X-B3-Sampled": "1",
+			},
+			SpanContext{TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1},
+			nil,
+		},
+		{"empty", opentracing.TextMapCarrier{}, SpanContext{}, opentracing.ErrSpanContextNotFound},
+		{"sampling only", opentracing.TextMapCarrier{"b3": "1"}, SpanContext{}, opentracing.ErrSpanContextNotFound},
+		{"sampled header only", opentracing.TextMapCarrier{"X-B3-Sampled": "0"}, SpanContext{}, opentracing.ErrSpanContextNotFound},
+		{"missing span ID", opentracing.TextMapCarrier{"X-B3-TraceId": "64fe8b2a57d3eff7"}, SpanContext{}, opentracing.ErrSpanContextCorrupted},
+		{"bad trace ID", opentracing.TextMapCarrier{"X-B3-TraceId": "xyz", "X-B3-SpanId": "1"}, SpanContext{}, opentracing.ErrSpanContextCorrupted},
+		{"long trace ID", opentracing.TextMapCarrier{"X-B3-TraceId": "180f198ee56343ba864fe8b2a57d3eff7", "X-B3-SpanId": "1"}, SpanContext{}, opentracing.ErrSpanContextCorrupted},
+		{"zero trace ID", opentracing.TextMapCarrier{"X-B3-TraceId": "0000000000000000", "X-B3-SpanId": "1"}, SpanContext{}, opentracing.ErrSpanContextCorrupted},
+		{"bad sampled", opentracing.TextMapCarrier{"X-B3-TraceId": "1", "X-B3-SpanId": "1", "X-B3-Sampled": "yes"}, SpanContext{}, opentracing.ErrSpanContextCorrupted},
+		{"bad single", opentracing.TextMapCarrier{"b3": "1-2-3-4-5"}, SpanContext{}, opentracing.ErrSpanContextCorrupted},
+	}
+	for _, tt := range tests {
+		context, err := B3Extracter(tt.carrier)
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if context.TraceID != tt.want.TraceID ||
+			context.TraceIDHigh != tt.want.TraceIDHigh ||
+			context.SpanID != tt.want.SpanID ||
+			context.ParentID != tt.want.ParentID ||
+			context.Flags != tt.want.Flags {
+
+			t.Errorf("%s: got %+v, want %+v", tt.name, context, tt.want)
+		}
+	}
+}
+
+func TestAnyExtracter(t *testing.T) {
+	tests := []struct {
+		name    string
+		carrier opentracing.TextMapCarrier
+		traceID uint64
+		err     error
+	}{
+		{"tracer", opentracing.TextMapCarrier{"tracer-traceid": "0000000000000001", "tracer-spanid": "0000000000000002"}, 1, nil},
+		{"w3c", opentracing.TextMapCarrier{"traceparent": "00-00000000000000000000000000000002-0000000000000003-01"}, 2, nil},
+		{"b3", opentracing.TextMapCarrier{"b3": "0000000000000003-0000000000000004"}, 3, nil},
+		{
+			"tracer preferred",
+			opentracing.TextMapCarrier{
+				"tracer-traceid": "0000000000000001",
+				"traceparent":    "00-00000000000000000000000000000002-0000000000000003-01",
+				"b3":             "0000000000000003-0000000000000004",
+			},
+			1, nil,
+		},
+		{
+			"corrupted w3c, valid b3",
+			opentracing.TextMapCarrier{
+				"traceparent": "00-xyz",
+				"b3":          "0000000000000003-0000000000000004",
+			},
+			3, nil,
+		},
+		{"corrupted", opentracing.TextMapCarrier{"traceparent": "00-xyz"}, 0, opentracing.ErrSpanContextCorrupted},
+		{"none", opentracing.TextMapCarrier{}, 0, opentracing.ErrSpanContextNotFound},
+	}
+	tr := NewTracer("", &recordingStorer{}, RandomID{})
+	for _, tt := range tests {
+		context, err := tr.Extract(FormatAny, tt.carrier)
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if id := context.(SpanContext).TraceID; id != tt.traceID {
+			t.Errorf("%s: got trace ID %d, want %d", tt.name, id, tt.traceID)
+		}
+	}
+}
+
+func TestB3DeferredSampling(t *testing.T) {
+	carrier := opentracing.TextMapCarrier{
+		"X-B3-TraceId": "64fe8b2a57d3eff7",
+		"X-B3-SpanId":  "e457b5a2e4d86bd1",
+	}
+	for _, decision := range []bool{true, false} {
+		tr := NewTracer("", &recordingStorer{}, RandomID{})
+		tr.Sampler = NewConstSampler(decision)
+		context, err := tr.Extract(FormatB3, carrier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sp := tr.StartSpan("child", opentracing.ChildOf(context)).(*Span)
+		if sp.Sampled() != decision {
+			t.Errorf("got sampled = %t, expected the local sampler's decision %t", sp.Sampled(), decision)
+		}
+		raw := sp.RawSpan()
+		if raw.Flags&FlagSamplingDeferred != 0 {
+			t.Error("span inherited FlagSamplingDeferred")
+		}
+		if raw.TraceID != 0x64fe8b2a57d3eff7 || raw.ParentID != 0xe457b5a2e4d86bd1 {
+			t.Errorf("span isn't a child of the extracted context: %+v", raw.SpanContext)
+		}
+
+		// The decision is propagated to downstream services.
+		out := opentracing.TextMapCarrier{}
+		if err := tr.Inject(sp.Context(), FormatB3, out); err != nil {
+			t.Fatal(err)
+		}
+		want := "0"
+		if decision {
+			want = "1"
+		}
+		if out["X-B3-Sampled"] != want {
+			t.Errorf("got X-B3-Sampled %q, expected %q", out["X-B3-Sampled"], want)
+		}
+	}
+}
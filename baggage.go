@@ -0,0 +1,76 @@
+package tracer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BaggageLimits restrict the baggage items that can be set on spans.
+// Because baggage is propagated to every downstream service, it
+// should be kept small. Items that violate the limits are rejected,
+// logged on the span and counted in the
+// tracer_rejected_baggage_items_total metric. The zero value imposes
+// no limits.
+type BaggageLimits struct {
+	// The maximum size in bytes of a single item, key and value
+	// combined. Zero means no limit.
+	MaxItemSize int
+	// The maximum size in bytes of all items of a span combined.
+	// Zero means no limit.
+	MaxSize int
+	// If not nil, only items with these keys may be set.
+	AllowedKeys []string
+}
+
+var rejectedBaggage = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "tracer_rejected_baggage_items_total",
+	Help: "Number of baggage items rejected because of baggage limits",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(rejectedBaggage)
+}
+
+// check returns the reason why an item may not be added to baggage,
+// or the empty string if it may.
+func (l BaggageLimits) check(baggage map[string]string, key, value string) string {
+	if l.AllowedKeys != nil {
+		allowed := false
+		for _, k := range l.AllowedKeys {
+			if k == key {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return "key_not_allowed"
+		}
+	}
+	size := len(key) + len(value)
+	if l.MaxItemSize > 0 && size > l.MaxItemSize {
+		return "item_too_large"
+	}
+	if l.MaxSize > 0 {
+		for k, v := range baggage {
+			if k != key {
+				size += len(k) + len(v)
+			}
+		}
+		if size > l.MaxSize {
+			return "baggage_too_large"
+		}
+	}
+	return ""
+}
+
+// withBaggageItem returns a copy of baggage with the item added.
+// Baggage maps are shared between a span, its children and the span
+// contexts handed out by it, so they must never be modified in
+// place.
+func withBaggageItem(baggage map[string]string, key, value string) map[string]string {
+	m := make(map[string]string, len(baggage)+1)
+	for k, v := range baggage {
+		m[k] = v
+	}
+	m[key] = value
+	return m
+}
@@ -52,9 +52,10 @@ func (q *QueryClient) SpanByID(id uint64) (tracer.RawSpan, error) {
 	return sr, nil
 }
 
-// TraceByID returns a trace given its ID.
-func (q *QueryClient) TraceByID(id uint64) (tracer.RawTrace, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/trace/?id=%016x", q.host, id), nil)
+// TraceByID returns a trace given its ID. high contains the high 64
+// bits of 128-bit trace IDs and is zero otherwise.
+func (q *QueryClient) TraceByID(high, low uint64) (tracer.RawTrace, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/trace/?id=%s", q.host, tracer.FormatTraceID(high, low)), nil)
 	if err != nil {
 		panic(err)
 	}
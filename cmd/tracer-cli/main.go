@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"strconv"
 	"strings"
 
 	"github.com/tracer/tracer"
@@ -22,11 +21,11 @@ func init() {
 func main() {
 	flag.Parse()
 	q := client.NewQueryClient(fHost)
-	num, err := strconv.ParseUint(os.Args[1], 16, 64)
+	high, low, err := tracer.ParseTraceID(os.Args[1])
 	if err != nil {
 		log.Fatalln("Invalid ID:", err)
 	}
-	trace, err := q.TraceByID(num)
+	trace, err := q.TraceByID(high, low)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -48,12 +47,12 @@ func formatTags(tags map[string]interface{}) string {
 }
 
 func printSpan(sp tracer.RawSpan) {
-	const format = `%s:%s (trace %016x) [%s]
+	const format = `%s:%s (trace %s) [%s]
 %s – %s (%s)
 `
 
 	fmt.Printf(format,
-		sp.ServiceName, sp.OperationName, sp.TraceID, formatTags(sp.Tags),
+		sp.ServiceName, sp.OperationName, tracer.FormatTraceID(sp.TraceIDHigh, sp.TraceID), formatTags(sp.Tags),
 		sp.StartTime.Format("15:04:05"), sp.FinishTime.Format("15:04:05"), sp.FinishTime.Sub(sp.StartTime))
 	if len(sp.Logs) > 0 {
 
@@ -4,6 +4,10 @@ package config
 import (
 	"fmt"
 	"io"
+	"math"
+	"time"
+
+	"github.com/tracer/tracer/server"
 
 	"github.com/BurntSushi/toml"
 )
@@ -35,6 +39,18 @@ func (err WrongValueTypeError) Error() string {
 		err.Key, err.Type)
 }
 
+// InvalidValueError is returned when a configuration key has an
+// invalid value.
+type InvalidValueError struct {
+	Key    string
+	Reason string
+}
+
+func (err InvalidValueError) Error() string {
+	return fmt.Sprintf("invalid value for configuration %s: %s",
+		err.Key, err.Reason)
+}
+
 // Config is the Tracer configuration file.
 type Config struct {
 	cfg map[string]interface{}
@@ -168,3 +184,202 @@ func (cfg Config) QueryTransportConfig(engine string) (map[string]interface{}, e
 	}
 	return conf, nil
 }
+
+// Sampling returns the sampling strategies that are served to
+// clients. It returns nil if there is no sampling section.
+func (cfg Config) Sampling() (*server.SamplingStrategies, error) {
+	gen, ok := cfg.cfg["sampling"]
+	if !ok {
+		return nil, nil
+	}
+	sampling, ok := gen.(map[string]interface{})
+	if !ok {
+		return nil, WrongValueTypeError{"sampling", "table"}
+	}
+	def, err := samplingStrategy("sampling", sampling)
+	if err != nil {
+		return nil, err
+	}
+	out := &server.SamplingStrategies{
+		Default:  def,
+		Services: map[string]server.ServiceSamplingStrategy{},
+	}
+	gen, ok = sampling["services"]
+	if !ok {
+		return out, nil
+	}
+	services, ok := gen.(map[string]interface{})
+	if !ok {
+		return nil, WrongValueTypeError{"sampling.services", "table"}
+	}
+	for name, v := range services {
+		key := "sampling.services." + name
+		service, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, WrongValueTypeError{key, "table"}
+		}
+		def, err := samplingStrategy(key, service)
+		if err != nil {
+			return nil, err
+		}
+		st := server.ServiceSamplingStrategy{
+			Default:    def,
+			Operations: map[string]server.SamplingStrategy{},
+		}
+		if v, ok := service["operations"]; ok {
+			operations, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, WrongValueTypeError{key + ".operations", "table"}
+			}
+			for op, v := range operations {
+				opKey := key + ".operations." + op
+				operation, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, WrongValueTypeError{opKey, "table"}
+				}
+				s, err := samplingStrategy(opKey, operation)
+				if err != nil {
+					return nil, err
+				}
+				if s == nil {
+					return nil, MissingKeyError(opKey + ".type")
+				}
+				st.Operations[op] = *s
+			}
+		}
+		out.Services[name] = st
+	}
+	return out, nil
+}
+
+// samplingStrategy parses the type and param keys of a table. It
+// returns nil if the table has no type key.
+func samplingStrategy(key string, conf map[string]interface{}) (*server.SamplingStrategy, error) {
+	gen, ok := conf["type"]
+	if !ok {
+		return nil, nil
+	}
+	typ, ok := gen.(string)
+	if !ok {
+		return nil, WrongValueTypeError{key + ".type", "string"}
+	}
+	gen, ok = conf["param"]
+	if !ok {
+		return nil, MissingKeyError(key + ".param")
+	}
+	var param float64
+	switch v := gen.(type) {
+	case int64:
+		param = float64(v)
+	case float64:
+		param = v
+	default:
+		return nil, WrongValueTypeError{key + ".param", "number"}
+	}
+	switch typ {
+	case server.SamplingProbabilistic:
+		if param < 0 || param > 1 {
+			return nil, InvalidValueError{key + ".param", "probability must be between 0 and 1"}
+		}
+	case server.SamplingRateLimiting:
+		if param < 0 || param != math.Trunc(param) {
+			return nil, InvalidValueError{key + ".param", "rate must be a non-negative integer"}
+		}
+	default:
+		return nil, InvalidValueError{key + ".type", fmt.Sprintf("unsupported sampling strategy %q", typ)}
+	}
+	return &server.SamplingStrategy{Type: typ, Param: param}, nil
+}
+
+// TailSampling returns the options for tail-based sampling. It
+// returns nil if there is no tail_sampling section.
+func (cfg Config) TailSampling() (*server.TailSamplingOptions, error) {
+	gen, ok := cfg.cfg["tail_sampling"]
+	if !ok {
+		return nil, nil
+	}
+	conf, ok := gen.(map[string]interface{})
+	if !ok {
+		return nil, WrongValueTypeError{"tail_sampling", "table"}
+	}
+	opts := &server.TailSamplingOptions{}
+	var err error
+	opts.Window, err = duration(conf, "tail_sampling", "window")
+	if err != nil {
+		return nil, err
+	}
+	if opts.Window == 0 {
+		return nil, MissingKeyError("tail_sampling.window")
+	}
+	if v, ok := conf["max_spans"]; ok {
+		n, ok := v.(int64)
+		if !ok {
+			return nil, WrongValueTypeError{"tail_sampling.max_spans", "integer"}
+		}
+		opts.MaxSpans = int(n)
+	}
+	if v, ok := conf["errors"]; ok {
+		b, ok := v.(bool)
+		if !ok {
+			return nil, WrongValueTypeError{"tail_sampling.errors", "bool"}
+		}
+		if b {
+			opts.Policies = append(opts.Policies, server.ErrorPolicy())
+		}
+	}
+	min, err := duration(conf, "tail_sampling", "min_duration")
+	if err != nil {
+		return nil, err
+	}
+	if min > 0 {
+		opts.Policies = append(opts.Policies, server.DurationPolicy(min))
+	}
+	if v, ok := conf["operations"]; ok {
+		ops, ok := v.([]map[string]interface{})
+		if !ok {
+			return nil, WrongValueTypeError{"tail_sampling.operations", "array of tables"}
+		}
+		for _, op := range ops {
+			service, ok := op["service"].(string)
+			if !ok {
+				return nil, MissingKeyError("tail_sampling.operations.service")
+			}
+			operation, _ := op["operation"].(string)
+			opts.Policies = append(opts.Policies, server.OperationPolicy(service, operation))
+		}
+	}
+	if v, ok := conf["probability"]; ok {
+		var p float64
+		switch v := v.(type) {
+		case int64:
+			p = float64(v)
+		case float64:
+			p = v
+		default:
+			return nil, WrongValueTypeError{"tail_sampling.probability", "number"}
+		}
+		if p < 0 || p > 1 {
+			return nil, InvalidValueError{"tail_sampling.probability", "probability must be between 0 and 1"}
+		}
+		opts.Policies = append(opts.Policies, server.ProbabilisticPolicy(p))
+	}
+	return opts, nil
+}
+
+// duration parses an optional duration such as "30s". It returns
+// zero if the key is missing.
+func duration(conf map[string]interface{}, section, key string) (time.Duration, error) {
+	v, ok := conf[key]
+	if !ok {
+		return 0, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, WrongValueTypeError{section + "." + key, "duration"}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, InvalidValueError{section + "." + key, err.Error()}
+	}
+	return d, nil
+}
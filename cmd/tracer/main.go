@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/tracer/tracer/cmd/tracer/config"
 	"github.com/tracer/tracer/server"
@@ -98,7 +100,21 @@ func main() {
 		log.Fatal(err)
 	}
 
+	tail, err := conf.TailSampling()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var tailSampler *server.TailSampler
+	if tail != nil {
+		tailSampler = server.NewTailSampler(storage, *tail)
+		storage = tailSampler
+	}
+
 	srv := &server.Server{Storage: storage}
+	srv.Sampling, err = conf.Sampling()
+	if err != nil {
+		log.Fatal(err)
+	}
 	srv.StorageTransport, err = loadStorageTransport(srv, conf)
 	if err != nil {
 		log.Fatal(err)
@@ -107,5 +123,24 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	srv.Start()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Start()
+	}()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-errs:
+	case sig := <-sigs:
+		log.Printf("Received %s, shutting down", sig)
+	}
+	if tailSampler != nil {
+		// Decide about the traces that are still buffered instead
+		// of losing them.
+		tailSampler.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
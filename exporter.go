@@ -0,0 +1,363 @@
+package tracer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+)
+
+// A BatchStorer is a Storer that can store multiple spans at once
+// more efficiently than one by one. StoreBatch must not retain the
+// slice of spans.
+type BatchStorer interface {
+	Storer
+	StoreBatch(spans []RawSpan) error
+}
+
+// BatchExporterOptions are options for a BatchExporter.
+type BatchExporterOptions struct {
+	// How many spans to buffer. If the buffer runs full, new spans
+	// will be dropped.
+	QueueSize int
+	// How many debug spans to buffer in addition to QueueSize, so
+	// that debug spans are only dropped if both buffers run full.
+	// Zero means QueueSize.
+	DebugQueueSize int
+	// How many spans to export at once.
+	BatchSize int
+	// How often to export spans, even if a batch isn't full yet.
+	FlushInterval time.Duration
+	// How many goroutines export spans concurrently.
+	Workers int
+	// How long Flush and Close wait for queued spans to be exported.
+	// Zero means 10 seconds.
+	FlushTimeout time.Duration
+	// Where to log errors. If nil, the default logger will be used.
+	Logger Logger
+	// Where to register the exporter's metrics. If nil,
+	// prometheus.DefaultRegisterer will be used. Exporters that
+	// share a registerer must have distinct names.
+	Registerer prometheus.Registerer
+	// The name of the exporter, used as the exporter label of its
+	// metrics.
+	Name string
+}
+
+// A BatchExporter wraps a Storer and stores spans in the background,
+// so that finishing a span never waits for a slow storer. Spans are
+// stored in batches, either once BatchSize spans have been collected
+// or after FlushInterval.
+//
+// Store never blocks; spans are dropped if the queue is full. Debug
+// spans have a queue of their own that they use if the shared queue
+// is full.
+type BatchExporter struct {
+	storer Storer
+	opts   BatchExporterOptions
+	ch     chan exporterItem
+	debug  chan RawSpan
+
+	// mu guards sending on ch and debug against Close stopping the
+	// workers.
+	mu        sync.RWMutex
+	closed    bool
+	done      chan struct{}
+	closeOnce sync.Once
+	workers   sync.WaitGroup
+
+	dropped uint64
+
+	exportedCounter prometheus.Counter
+	failedCounter   prometheus.Counter
+	droppedCounter  prometheus.Counter
+}
+
+type exporterItem struct {
+	span  RawSpan
+	flush *exporterFlush
+}
+
+// exporterFlush is sent to every worker to make it export its
+// current batch. Workers wait for release afterwards, so that each
+// worker receives exactly one of them.
+type exporterFlush struct {
+	wg      sync.WaitGroup
+	release chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewBatchExporter returns a BatchExporter that stores spans in
+// storer. If opts is nil, default options will be used.
+func NewBatchExporter(storer Storer, opts *BatchExporterOptions) *BatchExporter {
+	if opts == nil {
+		opts = &BatchExporterOptions{}
+	}
+	o := *opts
+	if o.QueueSize == 0 {
+		o.QueueSize = 2048
+	}
+	if o.DebugQueueSize == 0 {
+		o.DebugQueueSize = o.QueueSize
+	}
+	if o.BatchSize == 0 {
+		o.BatchSize = 512
+	}
+	if o.FlushInterval == 0 {
+		o.FlushInterval = 1 * time.Second
+	}
+	if o.Workers == 0 {
+		o.Workers = 1
+	}
+	if o.FlushTimeout == 0 {
+		o.FlushTimeout = 10 * time.Second
+	}
+	if o.Logger == nil {
+		o.Logger = defaultLogger{}
+	}
+	if o.Registerer == nil {
+		o.Registerer = prometheus.DefaultRegisterer
+	}
+	labels := prometheus.Labels{"exporter": o.Name}
+	e := &BatchExporter{
+		storer: storer,
+		opts:   o,
+		ch:     make(chan exporterItem, o.QueueSize),
+		debug:  make(chan RawSpan, o.DebugQueueSize),
+		done:   make(chan struct{}),
+
+		exportedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "tracer_exporter_exported_spans_total",
+			Help:        "Number of spans stored by the batch exporter",
+			ConstLabels: labels,
+		}),
+		failedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "tracer_exporter_failed_spans_total",
+			Help:        "Number of spans the batch exporter failed to store",
+			ConstLabels: labels,
+		}),
+		droppedCounter: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "tracer_exporter_dropped_spans_total",
+			Help:        "Number of spans dropped because the batch exporter's queue was full",
+			ConstLabels: labels,
+		}),
+	}
+	for _, c := range []prometheus.Collector{e.exportedCounter, e.failedCounter, e.droppedCounter} {
+		if err := o.Registerer.Register(c); err != nil {
+			e.opts.Logger.Printf("couldn't register prometheus counter: %s", err)
+		}
+	}
+	e.workers.Add(o.Workers)
+	for i := 0; i < o.Workers; i++ {
+		go e.worker()
+	}
+	return e
+}
+
+// Store implements the tracer.Storer interface. It never blocks and
+// never returns an error; errors of the wrapped storer are logged.
+func (e *BatchExporter) Store(sp RawSpan) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		e.drop()
+		return nil
+	}
+	select {
+	case e.ch <- exporterItem{span: sp}:
+		return nil
+	default:
+	}
+	if sp.Flags&FlagDebug == 0 {
+		e.drop()
+		return nil
+	}
+	select {
+	case e.debug <- sp:
+	default:
+		e.drop()
+	}
+	return nil
+}
+
+func (e *BatchExporter) drop() {
+	atomic.AddUint64(&e.dropped, 1)
+	e.droppedCounter.Inc()
+}
+
+// Dropped returns the number of spans that were dropped because the
+// queue was full or the exporter was closed.
+func (e *BatchExporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Flush implements the tracer.Flusher interface. It waits until all
+// spans queued before the call have been exported, then flushes the
+// wrapped storer if it implements Flusher. It returns the first
+// error of storing the final batches, or an error if that takes
+// longer than FlushTimeout.
+func (e *BatchExporter) Flush() error {
+	ctx, cancel := context.WithTimeout(context.Background(), e.opts.FlushTimeout)
+	defer cancel()
+	return e.FlushContext(ctx)
+}
+
+// FlushContext is like Flush but gives up waiting for the queued
+// spans to be exported once ctx is done. The spans stay queued in
+// that case.
+func (e *BatchExporter) FlushContext(ctx context.Context) error {
+	select {
+	case <-e.done:
+		return nil
+	default:
+	}
+	f := &exporterFlush{release: make(chan struct{})}
+	defer close(f.release)
+	f.wg.Add(e.opts.Workers)
+	for i := 0; i < e.opts.Workers; i++ {
+		select {
+		case e.ch <- exporterItem{flush: f}:
+		case <-e.done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	exported := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(exported)
+	}()
+	select {
+	case <-exported:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if f.err != nil {
+		return f.err
+	}
+	if fl, ok := e.storer.(Flusher); ok {
+		return fl.Flush()
+	}
+	return nil
+}
+
+// Close flushes the exporter and stops its workers. Spans stored
+// once Close has been called are dropped, as are spans that are
+// still queued if flushing times out. Close must not be called
+// concurrently with Flush.
+func (e *BatchExporter) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		e.mu.Lock()
+		e.closed = true
+		e.mu.Unlock()
+		err = e.Flush()
+		close(e.done)
+		e.workers.Wait()
+		e.drain()
+	})
+	return err
+}
+
+// drain counts the spans left in the queues after the workers have
+// stopped as dropped, and releases flushes that are still waiting
+// for them.
+func (e *BatchExporter) drain() {
+	for {
+		select {
+		case item := <-e.ch:
+			if item.flush != nil {
+				item.flush.wg.Done()
+				continue
+			}
+			e.drop()
+		case <-e.debug:
+			e.drop()
+		default:
+			return
+		}
+	}
+}
+
+func (e *BatchExporter) worker() {
+	defer e.workers.Done()
+	batch := make([]RawSpan, 0, e.opts.BatchSize)
+	t := time.NewTicker(e.opts.FlushInterval)
+	defer t.Stop()
+	add := func(sp RawSpan) {
+		batch = append(batch, sp)
+		if len(batch) == e.opts.BatchSize {
+			if err := e.export(batch); err != nil {
+				e.opts.Logger.Printf("couldn't export spans: %s", err)
+			}
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case item := <-e.ch:
+			if item.flush == nil {
+				add(item.span)
+				continue
+			}
+			// Debug spans queued before the flush are part of it.
+			for n := len(e.debug); n > 0; n-- {
+				select {
+				case sp := <-e.debug:
+					add(sp)
+				default:
+				}
+			}
+			err := e.export(batch)
+			batch = batch[:0]
+			item.flush.mu.Lock()
+			if item.flush.err == nil {
+				item.flush.err = err
+			}
+			item.flush.mu.Unlock()
+			item.flush.wg.Done()
+			<-item.flush.release
+		case sp := <-e.debug:
+			add(sp)
+		case <-t.C:
+			if err := e.export(batch); err != nil {
+				e.opts.Logger.Printf("couldn't export spans: %s", err)
+			}
+			batch = batch[:0]
+		case <-e.done:
+			return
+		}
+	}
+}
+
+// export stores a batch of spans. It returns the first error.
+func (e *BatchExporter) export(batch []RawSpan) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if bs, ok := e.storer.(BatchStorer); ok {
+		if err := bs.StoreBatch(batch); err != nil {
+			e.failedCounter.Add(float64(len(batch)))
+			return err
+		}
+		e.exportedCounter.Add(float64(len(batch)))
+		return nil
+	}
+	var first error
+	for _, sp := range batch {
+		if err := e.storer.Store(sp); err != nil {
+			e.failedCounter.Inc()
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		e.exportedCounter.Inc()
+	}
+	return first
+}
@@ -0,0 +1,340 @@
+package tracer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tracer/tracer/internal/promtest"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+)
+
+type batchStorer struct {
+	mu      sync.Mutex
+	batches [][]RawSpan
+	block   chan struct{}
+	err     error
+}
+
+func (s *batchStorer) Store(sp RawSpan) error {
+	panic("Store called on a BatchStorer")
+}
+
+func (s *batchStorer) StoreBatch(spans []RawSpan) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, append([]RawSpan(nil), spans...))
+	return s.err
+}
+
+func (s *batchStorer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, b := range s.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func TestBatchExporterBatchSize(t *testing.T) {
+	storer := &batchStorer{}
+	e := NewBatchExporter(storer, &BatchExporterOptions{
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		Logger:        &recordingLogger{},
+		Registerer:    prometheus.NewRegistry(),
+	})
+	for i := 0; i < 7; i++ {
+		e.Store(RawSpan{SpanContext: SpanContext{SpanID: uint64(i + 1)}})
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int
+	for _, b := range storer.batches {
+		sizes = append(sizes, len(b))
+	}
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 {
+		t.Errorf("got batches of sizes %v, expected [3 3 1]", sizes)
+	}
+	if storer.batches[2][0].SpanID != 7 {
+		t.Errorf("got span %d in last batch, expected 7", storer.batches[2][0].SpanID)
+	}
+}
+
+func TestBatchExporterFlushInterval(t *testing.T) {
+	storer := &batchStorer{}
+	e := NewBatchExporter(storer, &BatchExporterOptions{
+		FlushInterval: 10 * time.Millisecond,
+		Logger:        &recordingLogger{},
+		Registerer:    prometheus.NewRegistry(),
+	})
+	e.Store(RawSpan{})
+	deadline := time.Now().Add(5 * time.Second)
+	for storer.count() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("span wasn't exported after the flush interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatchExporterDrops(t *testing.T) {
+	storer := &batchStorer{block: make(chan struct{})}
+	e := NewBatchExporter(storer, &BatchExporterOptions{
+		QueueSize:      2,
+		DebugQueueSize: 1,
+		BatchSize:      1,
+		FlushInterval:  time.Hour,
+		Logger:         &recordingLogger{},
+		Registerer:     prometheus.NewRegistry(),
+	})
+
+	// The first span blocks the worker, the next two fill the queue
+	// and the rest are dropped.
+	e.Store(RawSpan{})
+	for len(e.ch) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		e.Store(RawSpan{})
+	}
+	if got := e.Dropped(); got != 8 {
+		t.Errorf("got %d dropped spans, expected 8", got)
+	}
+
+	// Debug spans use their own queue once the shared one is full,
+	// and are dropped instead of blocking if that is full, too.
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			e.Store(RawSpan{SpanContext: SpanContext{Flags: FlagDebug}})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Store blocked")
+	}
+	if got := e.Dropped(); got != 10 {
+		t.Errorf("got %d dropped spans, expected 10", got)
+	}
+	if got := promtest.CounterValue(t, e.droppedCounter); got != 10 {
+		t.Errorf("got %v in the dropped counter, expected 10", got)
+	}
+
+	close(storer.block)
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := storer.count(); got != 4 {
+		t.Errorf("got %d stored spans, expected 4", got)
+	}
+}
+
+func TestBatchExporterWorkers(t *testing.T) {
+	storer := &recordingStorer{}
+	e := NewBatchExporter(storer, &BatchExporterOptions{
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+		Workers:       4,
+		Logger:        &recordingLogger{},
+		Registerer:    prometheus.NewRegistry(),
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				e.Store(RawSpan{})
+			}
+		}()
+	}
+	wg.Wait()
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	storer.mu.Lock()
+	n := len(storer.spans)
+	storer.mu.Unlock()
+	if n != 100 {
+		t.Errorf("got %d stored spans, expected 100", n)
+	}
+	// Flushing twice must not deadlock.
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchExporterFlushError(t *testing.T) {
+	storer := &batchStorer{err: errors.New("storage unavailable")}
+	e := NewBatchExporter(storer, &BatchExporterOptions{
+		FlushInterval: time.Hour,
+		Logger:        &recordingLogger{},
+		Registerer:    prometheus.NewRegistry(),
+	})
+	e.Store(RawSpan{})
+	e.Store(RawSpan{})
+	if err := e.Flush(); err != storer.err {
+		t.Errorf("got error %v, expected %v", err, storer.err)
+	}
+	if got := promtest.CounterValue(t, e.exportedCounter); got != 0 {
+		t.Errorf("got %v exported spans, expected 0", got)
+	}
+	if got := promtest.CounterValue(t, e.failedCounter); got != 2 {
+		t.Errorf("got %v failed spans, expected 2", got)
+	}
+
+	storer.err = nil
+	e.Store(RawSpan{})
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := promtest.CounterValue(t, e.exportedCounter); got != 1 {
+		t.Errorf("got %v exported spans, expected 1", got)
+	}
+}
+
+func TestBatchExporterRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	logger := &recordingLogger{}
+	for _, name := range []string{"postgres", "grpc"} {
+		e := NewBatchExporter(&recordingStorer{}, &BatchExporterOptions{
+			Name:       name,
+			Logger:     logger,
+			Registerer: reg,
+		})
+		defer e.Close()
+	}
+	if len(logger.msgs) != 0 {
+		t.Errorf("got errors registering metrics: %v", logger.msgs)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if len(mf.GetMetric()) != 2 {
+			t.Errorf("got %d metrics for %s, expected 2", len(mf.GetMetric()), mf.GetName())
+		}
+	}
+}
+
+func TestBatchExporterClose(t *testing.T) {
+	storer := &recordingStorer{}
+	e := NewBatchExporter(storer, &BatchExporterOptions{
+		FlushInterval: time.Hour,
+		Workers:       3,
+		Logger:        &recordingLogger{},
+		Registerer:    prometheus.NewRegistry(),
+	})
+	e.Store(RawSpan{})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	storer.mu.Lock()
+	n := len(storer.spans)
+	storer.mu.Unlock()
+	if n != 1 {
+		t.Errorf("got %d stored spans, expected 1", n)
+	}
+
+	// Spans stored after closing are dropped, and closing or flushing
+	// again doesn't block.
+	e.Store(RawSpan{})
+	if got := e.Dropped(); got != 1 {
+		t.Errorf("got %d dropped spans, expected 1", got)
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBatchExporterFlushTimeout(t *testing.T) {
+	storer := &batchStorer{block: make(chan struct{})}
+	e := NewBatchExporter(storer, &BatchExporterOptions{
+		QueueSize:     1,
+		BatchSize:     1,
+		FlushInterval: time.Hour,
+		FlushTimeout:  10 * time.Millisecond,
+		Logger:        &recordingLogger{},
+		Registerer:    prometheus.NewRegistry(),
+	})
+
+	// The first span blocks the worker and the second fills the
+	// queue, so flushing can't even queue its barrier.
+	e.Store(RawSpan{})
+	for len(e.ch) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	e.Store(RawSpan{})
+	if err := e.Flush(); err != context.DeadlineExceeded {
+		t.Errorf("got error %v, expected %v", err, context.DeadlineExceeded)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := e.FlushContext(ctx); err != context.Canceled {
+		t.Errorf("got error %v, expected %v", err, context.Canceled)
+	}
+
+	close(storer.block)
+	if err := e.FlushContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := storer.count(); got != 2 {
+		t.Errorf("got %d stored spans, expected 2", got)
+	}
+}
+
+func TestBatchExporterStoreDuringClose(t *testing.T) {
+	storer := &batchStorer{block: make(chan struct{})}
+	e := NewBatchExporter(storer, &BatchExporterOptions{
+		FlushInterval: time.Hour,
+		Logger:        &recordingLogger{},
+		Registerer:    prometheus.NewRegistry(),
+	})
+	e.Store(RawSpan{})
+	closed := make(chan error)
+	go func() {
+		closed <- e.Close()
+	}()
+	for {
+		e.mu.RLock()
+		c := e.closed
+		e.mu.RUnlock()
+		if c {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Close is waiting for the storer, and spans stored in the
+	// meantime are counted as dropped.
+	e.Store(RawSpan{})
+	close(storer.block)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if got := storer.count(); got != 1 {
+		t.Errorf("got %d stored spans, expected 1", got)
+	}
+	if got := e.Dropped(); got != 1 {
+		t.Errorf("got %d dropped spans, expected 1", got)
+	}
+	if got := promtest.CounterValue(t, e.droppedCounter); got != 1 {
+		t.Errorf("got %v in the dropped counter, expected 1", got)
+	}
+}
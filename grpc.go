@@ -1,15 +1,18 @@
 package tracer
 
 import (
-	"fmt"
+	"math/rand"
+	"reflect"
 	"time"
 
+	"github.com/tracer/tracer/internal/pbutil"
 	"github.com/tracer/tracer/pb"
 
 	"github.com/golang/protobuf/ptypes"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 // GRPC is a gRPC-based transport for sending spans to a server.
@@ -17,12 +20,31 @@ type GRPC struct {
 	client        pb.StorerClient
 	queue         []RawSpan
 	ch            chan RawSpan
+	debugCh       chan RawSpan
 	flushCh       chan chan error
 	flushInterval time.Duration
+	timeout       time.Duration
 	logger        Logger
 
-	stored  prometheus.Counter
-	dropped prometheus.Counter
+	maxRetries      int
+	initialBackoff  time.Duration
+	maxBackoff      time.Duration
+	retryBufferSize int
+	retries         []*grpcRetry
+	retrySpans      int
+	rand            *rand.Rand
+
+	stored       prometheus.Counter
+	dropped      prometheus.Counter
+	droppedDebug prometheus.Counter
+	failed       *prometheus.CounterVec
+}
+
+// grpcRetry is a request that failed and will be sent again.
+type grpcRetry struct {
+	req      *pb.StoreRequest
+	attempts int
+	next     time.Time
 }
 
 // GRPCOptions are options for the GRPC storer.
@@ -32,10 +54,33 @@ type GRPCOptions struct {
 	// process new spans. If this buffer runs full, new spans will be
 	// dropped.
 	QueueSize int
+	// How many debug spans to buffer in addition to the buffer for
+	// all spans, so that debug spans are only dropped if both run
+	// full. Zero means QueueSize.
+	DebugQueueSize int
 	// How often to flush spans, even if the queue isn't full yet.
 	FlushInterval time.Duration
 	// Where to log errors. If nil, the default logger will be used.
 	Logger Logger
+	// How long to wait for the server to store a request. Zero means
+	// 10s.
+	Timeout time.Duration
+	// How often to retry sending spans that the server failed to
+	// store. Only requests that failed because the server was
+	// unavailable or didn't answer in time are retried. Zero means 5,
+	// a negative value disables retries.
+	MaxRetries int
+	// How long to wait before the first retry. The wait doubles with
+	// every retry, up to MaxBackoff, and a random amount of up to half
+	// of it is subtracted to spread out retries of many clients.
+	// Zero means 100ms.
+	InitialBackoff time.Duration
+	// The longest wait between two retries. Zero means 30s.
+	MaxBackoff time.Duration
+	// How many spans to keep for retrying. If this buffer runs full,
+	// spans that failed to be sent will be dropped. Zero means
+	// 2*QueueSize.
+	RetryBufferSize int
 }
 
 // NewGRPC returns a new Storer that sends spans via gRPC to a server.
@@ -49,19 +94,50 @@ func NewGRPC(address string, grpcOpts *GRPCOptions, opts ...grpc.DialOption) (St
 	if grpcOpts.Logger == nil {
 		grpcOpts.Logger = defaultLogger{}
 	}
+	if grpcOpts.DebugQueueSize == 0 {
+		grpcOpts.DebugQueueSize = grpcOpts.QueueSize
+	}
+	if grpcOpts.Timeout == 0 {
+		grpcOpts.Timeout = 10 * time.Second
+	}
+	if grpcOpts.MaxRetries == 0 {
+		grpcOpts.MaxRetries = 5
+	}
+	if grpcOpts.InitialBackoff == 0 {
+		grpcOpts.InitialBackoff = 100 * time.Millisecond
+	}
+	if grpcOpts.MaxBackoff == 0 {
+		grpcOpts.MaxBackoff = 30 * time.Second
+	}
+	if grpcOpts.RetryBufferSize == 0 {
+		grpcOpts.RetryBufferSize = grpcOpts.QueueSize * 2
+	}
 	conn, err := grpc.Dial(address, opts...)
 	if err != nil {
 		return nil, err
 	}
-	client := pb.NewStorerClient(conn)
+	g := newGRPC(pb.NewStorerClient(conn), grpcOpts)
+	go g.loop()
+	return g, nil
+}
+
+func newGRPC(client pb.StorerClient, grpcOpts *GRPCOptions) *GRPC {
 	g := &GRPC{
 		client:        client,
 		queue:         make([]RawSpan, 0, grpcOpts.QueueSize),
 		ch:            make(chan RawSpan, grpcOpts.QueueSize*2),
+		debugCh:       make(chan RawSpan, grpcOpts.DebugQueueSize),
 		flushCh:       make(chan chan error),
 		flushInterval: grpcOpts.FlushInterval,
+		timeout:       grpcOpts.Timeout,
 		logger:        grpcOpts.Logger,
 
+		maxRetries:      grpcOpts.MaxRetries,
+		initialBackoff:  grpcOpts.InitialBackoff,
+		maxBackoff:      grpcOpts.MaxBackoff,
+		retryBufferSize: grpcOpts.RetryBufferSize,
+		rand:            rand.New(rand.NewSource(time.Now().UnixNano())),
+
 		stored: prometheus.NewCounter(prometheus.CounterOpts{
 			Name: "tracer_stored_spans_total",
 			Help: "Number of stored spans",
@@ -70,108 +146,313 @@ func NewGRPC(address string, grpcOpts *GRPCOptions, opts ...grpc.DialOption) (St
 			Name: "tracer_dropped_spans_total",
 			Help: "Number of dropped spans",
 		}),
+		droppedDebug: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "tracer_dropped_debug_spans_total",
+			Help: "Number of dropped debug spans, which are also counted as dropped spans",
+		}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tracer_failed_batches_total",
+			Help: "Number of batches of spans the server failed to store, by cause",
+		}, []string{"cause"}),
 	}
-	err = prometheus.Register(g.dropped)
-	if err != nil {
-		g.logger.Printf("couldn't register prometheus counter: %s", err)
-	}
-	err = prometheus.Register(g.stored)
-	if err != nil {
-		g.logger.Printf("couldn't register prometheus counter: %s", err)
+	for _, c := range []prometheus.Collector{g.dropped, g.droppedDebug, g.stored, g.failed} {
+		if err := prometheus.Register(c); err != nil {
+			g.logger.Printf("couldn't register prometheus counter: %s", err)
+		}
 	}
-	go g.loop()
-	return g, nil
+	return g
 }
 
 func (g *GRPC) loop() {
 	t := time.NewTicker(g.flushInterval)
+	retry := time.NewTimer(g.flushInterval)
+	retry.Stop()
+	var retryC <-chan time.Time
+	// schedule arms the retry timer for the next pending retry.
+	schedule := func() {
+		retry.Stop()
+		retryC = nil
+		if next, ok := g.nextRetry(); ok {
+			retry.Reset(next.Sub(time.Now()))
+			retryC = retry.C
+		}
+	}
 	for {
 		select {
 		case sp := <-g.ch:
-			g.queue = append(g.queue, sp)
-			if len(g.queue) == cap(g.queue) {
-				if err := g.flush(); err != nil {
-					g.logger.Printf("couldn't flush spans: %s", err)
-				}
-			}
+			g.enqueue(sp)
+			schedule()
+		case sp := <-g.debugCh:
+			g.enqueue(sp)
+			schedule()
 		case <-t.C:
 			if err := g.flush(); err != nil {
 				g.logger.Printf("couldn't flush spans: %s", err)
 			}
+			schedule()
+		case <-retryC:
+			if err := g.retry(false); err != nil {
+				g.logger.Printf("couldn't retry sending spans: %s", err)
+			}
+			schedule()
 		case ch := <-g.flushCh:
-			ch <- g.flush()
+			// Pending retries are sent first, without waiting for
+			// their backoff, so that spans are sent in order.
+			err := g.retry(true)
+			if ferr := g.drain(); err == nil {
+				err = ferr
+			}
+			schedule()
+			ch <- err
+		}
+	}
+}
+
+// drain sends the spans in the buffer and the queue to the server.
+// It returns the first error.
+func (g *GRPC) drain() error {
+	var first error
+	// Only spans stored before the call are sent, so that concurrent
+	// calls to Store can't keep drain from returning.
+	for _, ch := range []chan RawSpan{g.ch, g.debugCh} {
+		for n := len(ch); n > 0; n-- {
+			g.queue = append(g.queue, <-ch)
+			if len(g.queue) == cap(g.queue) {
+				if err := g.flush(); err != nil && first == nil {
+					first = err
+				}
+			}
+		}
+	}
+	if err := g.flush(); err != nil && first == nil {
+		first = err
+	}
+	return first
+}
+
+// enqueue adds a span to the queue and flushes the queue if it is
+// full.
+func (g *GRPC) enqueue(sp RawSpan) {
+	g.queue = append(g.queue, sp)
+	if len(g.queue) == cap(g.queue) {
+		if err := g.flush(); err != nil {
+			g.logger.Printf("couldn't flush spans: %s", err)
 		}
 	}
 }
 
+// flush sends all queued spans to the server. It returns the first
+// error; requests that failed are kept for retrying if possible.
 func (g *GRPC) flush() error {
 	if len(g.queue) == 0 {
 		return nil
 	}
-	var pbs []*pb.Span
+	// Spans are sent in one request per resource, which is usually
+	// a single request, because most processes have a single tracer.
+	var reqs []*pb.StoreRequest
+	byResource := map[uintptr]*pb.StoreRequest{}
 	for _, sp := range g.queue {
 		pst, err := ptypes.TimestampProto(sp.StartTime)
 		if err != nil {
 			g.logger.Printf("dropping span because of error: %s", err)
+			g.dropped.Inc()
 			continue
 		}
 		pft, err := ptypes.TimestampProto(sp.FinishTime)
 		if err != nil {
 			g.logger.Printf("dropping span because of error: %s", err)
+			g.dropped.Inc()
 			continue
 		}
 		var tags []*pb.Tag
 		for k, v := range sp.Tags {
-			vs := fmt.Sprintf("%v", v) // XXX
-			tags = append(tags, &pb.Tag{
-				Key:   k,
-				Value: vs,
-			})
+			tag := &pb.Tag{Key: k}
+			pbutil.SetTagValue(tag, v)
+			tags = append(tags, tag)
 		}
+		var logs []*pb.Log
 		for _, l := range sp.Logs {
 			t, err := ptypes.TimestampProto(l.Timestamp)
 			if err != nil {
 				g.logger.Printf("dropping log entry because of error: %s", err)
 				continue
 			}
-			ps := fmt.Sprintf("%v", l.Payload) // XXX
-			tags = append(tags, &pb.Tag{
-				Key:   l.Event,
-				Value: ps,
-				Time:  t,
+			log := &pb.Log{Time: t}
+			for _, f := range l.Fields {
+				field := &pb.Tag{Key: f.Key}
+				pbutil.SetTagValue(field, f.Value)
+				log.Fields = append(log.Fields, field)
+			}
+			logs = append(logs, log)
+		}
+		var refs []*pb.Reference
+		for _, ref := range sp.References {
+			kind := pb.Reference_CHILD_OF
+			if ref.Kind == RelationFollowsFrom {
+				kind = pb.Reference_FOLLOWS_FROM
+			}
+			refs = append(refs, &pb.Reference{
+				Kind:        kind,
+				TraceId:     ref.TraceID,
+				TraceIdHigh: ref.TraceIDHigh,
+				SpanId:      ref.SpanID,
 			})
 		}
 		psp := &pb.Span{
 			SpanId:        sp.SpanID,
 			ParentId:      sp.ParentID,
 			TraceId:       sp.TraceID,
+			TraceIdHigh:   sp.TraceIDHigh,
 			ServiceName:   sp.ServiceName,
 			OperationName: sp.OperationName,
 			StartTime:     pst,
 			FinishTime:    pft,
 			Flags:         sp.Flags,
 			Tags:          tags,
+			Logs:          logs,
+			References:    refs,
 		}
-		pbs = append(pbs, psp)
+		key := reflect.ValueOf(sp.Resource).Pointer()
+		req, ok := byResource[key]
+		if !ok {
+			req = &pb.StoreRequest{Resource: resourceTags(sp.Resource)}
+			byResource[key] = req
+			reqs = append(reqs, req)
+		}
+		req.Spans = append(req.Spans, psp)
 	}
 	g.queue = g.queue[0:0]
-	if _, err := g.client.Store(context.Background(), &pb.StoreRequest{Spans: pbs}); err != nil {
+	var first error
+	for _, req := range reqs {
+		if err := g.send(&grpcRetry{req: req}); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// retry sends the requests whose backoff has expired, or all pending
+// requests if all is true. It returns the first error.
+func (g *GRPC) retry(all bool) error {
+	now := time.Now()
+	pending := g.retries
+	g.retries = nil
+	g.retrySpans = 0
+	var first error
+	for _, r := range pending {
+		if !all && r.next.After(now) {
+			g.retries = append(g.retries, r)
+			g.retrySpans += len(r.req.Spans)
+			continue
+		}
+		if err := g.send(r); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// send sends a request to the server. If it fails, the request is
+// kept for retrying, unless it may not be retried or the retry buffer
+// is full, in which case its spans are dropped.
+func (g *GRPC) send(r *grpcRetry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	_, err := g.client.Store(ctx, r.req)
+	cancel()
+	if err == nil {
+		return nil
+	}
+	cause := failureCause(err)
+	g.failed.WithLabelValues(cause).Inc()
+	n := len(r.req.Spans)
+	if cause != "unavailable" || r.attempts >= g.maxRetries ||
+		g.retrySpans+n > g.retryBufferSize {
+		g.dropped.Add(float64(n))
 		return err
 	}
-	return nil
+	r.attempts++
+	r.next = time.Now().Add(g.backoff(r.attempts))
+	g.retries = append(g.retries, r)
+	g.retrySpans += n
+	return err
 }
 
-// Store implements the tracer.Storer interface.
+// backoff returns how long to wait before the nth retry.
+func (g *GRPC) backoff(n int) time.Duration {
+	d := g.initialBackoff
+	for i := 1; i < n && d < g.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > g.maxBackoff {
+		d = g.maxBackoff
+	}
+	if half := int64(d / 2); half > 0 {
+		d -= time.Duration(g.rand.Int63n(half))
+	}
+	return d
+}
+
+// nextRetry returns the time of the earliest pending retry.
+func (g *GRPC) nextRetry() (time.Time, bool) {
+	var next time.Time
+	for _, r := range g.retries {
+		if next.IsZero() || r.next.Before(next) {
+			next = r.next
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// failureCause returns the value of the cause label of
+// tracer_failed_batches_total for an error returned by the server.
+func failureCause(err error) string {
+	switch grpc.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return "unavailable"
+	case codes.InvalidArgument:
+		return "invalid_argument"
+	default:
+		return "other"
+	}
+}
+
+func resourceTags(res map[string]interface{}) []*pb.Tag {
+	var tags []*pb.Tag
+	for k, v := range res {
+		tag := &pb.Tag{Key: k}
+		pbutil.SetTagValue(tag, v)
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Store implements the tracer.Storer interface. It never blocks:
+// spans are dropped if the buffer is full. Debug spans have a buffer
+// of their own that they use if the shared buffer is full, so that
+// they are only dropped if both buffers are full.
 func (g *GRPC) Store(sp RawSpan) error {
 	select {
 	case g.ch <- sp:
 		g.stored.Inc()
+		return nil
+	default:
+	}
+	if sp.Flags&FlagDebug == 0 {
+		g.dropped.Inc()
+		return nil
+	}
+	select {
+	case g.debugCh <- sp:
+		g.stored.Inc()
 	default:
 		g.dropped.Inc()
+		g.droppedDebug.Inc()
 	}
 	return nil
 }
 
+// Flush implements the tracer.Flusher interface. It sends all queued
+// spans, as well as spans waiting to be retried, to the server.
 func (g *GRPC) Flush() error {
 	ch := make(chan error)
 	g.flushCh <- ch
@@ -0,0 +1,289 @@
+package tracer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tracer/tracer/internal/promtest"
+	"github.com/tracer/tracer/pb"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+type fakeStorerClient struct {
+	mu    sync.Mutex
+	errs  []error
+	calls int
+	spans int
+}
+
+func (c *fakeStorerClient) Store(ctx context.Context, req *pb.StoreRequest, opts ...grpc.CallOption) (*pb.StoreResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nil, err
+	}
+	c.spans += len(req.Spans)
+	return &pb.StoreResponse{}, nil
+}
+
+func (c *fakeStorerClient) stats() (calls, spans int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls, c.spans
+}
+
+// counterValue returns the current value of a prometheus counter.
+func newTestGRPC(client pb.StorerClient, opts GRPCOptions) *GRPC {
+	if opts.QueueSize == 0 {
+		opts.QueueSize = 10
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = time.Hour
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = time.Second
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 5
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = time.Millisecond
+	}
+	if opts.RetryBufferSize == 0 {
+		opts.RetryBufferSize = 10
+	}
+	opts.InitialBackoff = time.Millisecond
+	opts.Logger = &recordingLogger{}
+	return newGRPC(client, &opts)
+}
+
+func testSpans(n int) []RawSpan {
+	spans := make([]RawSpan, n)
+	for i := range spans {
+		spans[i] = RawSpan{
+			SpanContext: SpanContext{SpanID: uint64(i + 1)},
+			StartTime:   time.Unix(0, 0),
+			FinishTime:  time.Unix(1, 0),
+		}
+	}
+	return spans
+}
+
+func TestGRPCStoreDoesntBlock(t *testing.T) {
+	// The loop isn't running, so nothing drains the buffers.
+	g := newTestGRPC(&fakeStorerClient{}, GRPCOptions{QueueSize: 1, DebugQueueSize: 1})
+	spans := testSpans(6)
+	for i := 3; i < 6; i++ {
+		spans[i].Flags |= FlagDebug
+	}
+	done := make(chan struct{})
+	go func() {
+		for _, sp := range spans {
+			g.Store(sp)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Store blocked")
+	}
+	if len(g.ch) != 2 || len(g.debugCh) != 1 {
+		t.Errorf("got %d buffered spans and %d buffered debug spans, expected 2 and 1",
+			len(g.ch), len(g.debugCh))
+	}
+	if got := promtest.CounterValue(t, g.dropped); got != 3 {
+		t.Errorf("got %v dropped spans, expected 3", got)
+	}
+	if got := promtest.CounterValue(t, g.droppedDebug); got != 2 {
+		t.Errorf("got %v dropped debug spans, expected 2", got)
+	}
+}
+
+func TestGRPCRetry(t *testing.T) {
+	unavailable := grpc.Errorf(codes.Unavailable, "connection refused")
+	client := &fakeStorerClient{errs: []error{unavailable, unavailable}}
+	g := newTestGRPC(client, GRPCOptions{})
+	go g.loop()
+
+	for _, sp := range testSpans(3) {
+		g.Store(sp)
+	}
+	if err := g.Flush(); err != unavailable {
+		t.Fatalf("got error %v, expected %v", err, unavailable)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		calls, spans := client.stats()
+		if spans == 3 {
+			if calls != 3 {
+				t.Errorf("got %d calls, expected 3", calls)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("spans weren't stored after retrying, got %d calls", calls)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestGRPCFlushRetries(t *testing.T) {
+	unavailable := grpc.Errorf(codes.Unavailable, "connection refused")
+	client := &fakeStorerClient{errs: []error{unavailable}}
+	g := newTestGRPC(client, GRPCOptions{MaxBackoff: time.Hour})
+	g.queue = append(g.queue, testSpans(2)...)
+	if err := g.flush(); err != unavailable {
+		t.Fatalf("got error %v, expected %v", err, unavailable)
+	}
+	if len(g.retries) != 1 || g.retrySpans != 2 {
+		t.Fatalf("got %d retries with %d spans, expected 1 with 2", len(g.retries), g.retrySpans)
+	}
+	// Retries that aren't due yet are kept.
+	g.retries[0].next = time.Now().Add(time.Hour)
+	if err := g.retry(false); err != nil {
+		t.Fatal(err)
+	}
+	if calls, _ := client.stats(); calls != 1 {
+		t.Errorf("got %d calls, expected 1", calls)
+	}
+	if err := g.retry(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, spans := client.stats(); spans != 2 || len(g.retries) != 0 || g.retrySpans != 0 {
+		t.Errorf("got %d stored spans and %d retries, expected 2 and 0", spans, len(g.retries))
+	}
+}
+
+func TestGRPCNoRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		opts GRPCOptions
+		errs []error
+	}{
+		{
+			name: "invalid argument",
+			errs: []error{grpc.Errorf(codes.InvalidArgument, "invalid timestamp")},
+		},
+		{
+			name: "internal error",
+			errs: []error{grpc.Errorf(codes.Internal, "stored part of the batch")},
+		},
+		{
+			name: "retries disabled",
+			opts: GRPCOptions{MaxRetries: -1},
+			errs: []error{grpc.Errorf(codes.Unavailable, "connection refused")},
+		},
+		{
+			name: "retries exhausted",
+			opts: GRPCOptions{MaxRetries: 1},
+			errs: []error{
+				grpc.Errorf(codes.Unavailable, "connection refused"),
+				grpc.Errorf(codes.Unavailable, "connection refused"),
+			},
+		},
+		{
+			name: "retry buffer full",
+			opts: GRPCOptions{RetryBufferSize: 2},
+			errs: []error{grpc.Errorf(codes.Unavailable, "connection refused")},
+		},
+	}
+	for _, test := range tests {
+		client := &fakeStorerClient{errs: test.errs}
+		g := newTestGRPC(client, test.opts)
+		g.queue = append(g.queue, testSpans(3)...)
+		if err := g.flush(); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+		for len(g.retries) > 0 {
+			if err := g.retry(true); err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+		}
+		calls, spans := client.stats()
+		if calls != len(test.errs) || spans != 0 {
+			t.Errorf("%s: got %d calls and %d stored spans, expected %d and 0",
+				test.name, calls, spans, len(test.errs))
+		}
+		if g.retrySpans != 0 {
+			t.Errorf("%s: got %d spans in the retry buffer, expected 0", test.name, g.retrySpans)
+		}
+	}
+}
+
+func TestGRPCBackoff(t *testing.T) {
+	g := newTestGRPC(&fakeStorerClient{}, GRPCOptions{MaxBackoff: time.Second})
+	g.initialBackoff = 100 * time.Millisecond
+	tests := []struct {
+		n   int
+		max time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			d := g.backoff(test.n)
+			if d <= test.max/2 || d > test.max {
+				t.Errorf("got backoff %s for retry %d, expected between %s and %s",
+					d, test.n, test.max/2, test.max)
+				break
+			}
+		}
+	}
+}
+
+func TestFailureCause(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{grpc.Errorf(codes.Unavailable, ""), "unavailable"},
+		{grpc.Errorf(codes.DeadlineExceeded, ""), "unavailable"},
+		{grpc.Errorf(codes.ResourceExhausted, ""), "unavailable"},
+		{grpc.Errorf(codes.InvalidArgument, ""), "invalid_argument"},
+		{grpc.Errorf(codes.Internal, ""), "other"},
+		{context.Canceled, "other"},
+	}
+	for _, test := range tests {
+		if got := failureCause(test.err); got != test.want {
+			t.Errorf("got cause %q for %v, expected %q", got, test.err, test.want)
+		}
+	}
+}
+
+// hangingStorerClient never answers, until the context is done.
+type hangingStorerClient struct{}
+
+func (hangingStorerClient) Store(ctx context.Context, req *pb.StoreRequest, opts ...grpc.CallOption) (*pb.StoreResponse, error) {
+	<-ctx.Done()
+	return nil, grpc.Errorf(codes.DeadlineExceeded, "%s", ctx.Err())
+}
+
+func TestGRPCTimeout(t *testing.T) {
+	g := newTestGRPC(hangingStorerClient{}, GRPCOptions{Timeout: 10 * time.Millisecond})
+	g.queue = append(g.queue, testSpans(3)...)
+	done := make(chan error)
+	go func() { done <- g.flush() }()
+	select {
+	case err := <-done:
+		if grpc.Code(err) != codes.DeadlineExceeded {
+			t.Errorf("got error %v, expected DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush didn't time out")
+	}
+	if len(g.retries) != 1 {
+		t.Errorf("got %d retries, expected 1", len(g.retries))
+	}
+}
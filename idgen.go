@@ -0,0 +1,136 @@
+package tracer
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mathrand "math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	_ IDGenerator = (*PseudoRandomID)(nil)
+	_ IDGenerator = (*SequentialID)(nil)
+	_ IDGenerator = (*SnowflakeID)(nil)
+)
+
+// PseudoRandomID generates random IDs by using a PRNG that is seeded
+// from crypto/rand. It is much faster than RandomID, but its IDs are
+// predictable.
+type PseudoRandomID struct {
+	mu  sync.Mutex
+	rng *mathrand.Rand
+}
+
+// NewPseudoRandomID returns a new PseudoRandomID.
+func NewPseudoRandomID() *PseudoRandomID {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	}
+	seed := int64(binary.BigEndian.Uint64(b))
+	return &PseudoRandomID{rng: mathrand.New(mathrand.NewSource(seed))}
+}
+
+// GenerateID generates an ID.
+func (g *PseudoRandomID) GenerateID() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for {
+		x := uint64(g.rng.Int63())<<1 ^ uint64(g.rng.Int63())
+		if x != 0 {
+			return x
+		}
+	}
+}
+
+// SequentialID generates sequential IDs, starting at 1. It is meant
+// for tests, where predictable IDs are useful; in production, IDs of
+// different processes would collide. The zero value is ready to use.
+type SequentialID struct {
+	last uint64
+}
+
+// GenerateID generates an ID.
+func (g *SequentialID) GenerateID() uint64 {
+	for {
+		x := atomic.AddUint64(&g.last, 1)
+		if x != 0 {
+			return x
+		}
+	}
+}
+
+// The layout of Snowflake IDs, from the most significant bit: one
+// unused bit, 41 bits of milliseconds since SnowflakeEpoch, 10 bits
+// of worker ID and 12 bits of sequence number.
+const (
+	snowflakeWorkerBits   = 10
+	snowflakeSequenceBits = 12
+
+	// MaxSnowflakeWorker is the largest worker ID supported by
+	// NewSnowflakeID.
+	MaxSnowflakeWorker = 1<<snowflakeWorkerBits - 1
+)
+
+// SnowflakeEpoch is the time that Snowflake timestamps are relative
+// to.
+var SnowflakeEpoch = time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeID generates Snowflake-style IDs, made up of a timestamp,
+// a worker ID and a sequence number. IDs of a generator are strictly
+// increasing, and IDs of different generators are roughly ordered by
+// time, which keeps inserts into storage indexes local.
+//
+// IDs are unique as long as each generator uses a different worker
+// ID. The unused top bit keeps IDs positive when they're stored as
+// signed integers.
+//
+// If more than 4096 IDs are generated within a millisecond, or the
+// clock goes backwards, the generator borrows from the following
+// milliseconds instead of waiting.
+type SnowflakeID struct {
+	mu     sync.Mutex
+	worker uint64
+	last   uint64
+	seq    uint64
+	nowFn  func() time.Time
+}
+
+// NewSnowflakeID returns a SnowflakeID generator for a worker, which
+// must be at most MaxSnowflakeWorker.
+func NewSnowflakeID(worker int) (*SnowflakeID, error) {
+	if worker < 0 || worker > MaxSnowflakeWorker {
+		return nil, fmt.Errorf("invalid Snowflake worker ID %d, must be between 0 and %d", worker, MaxSnowflakeWorker)
+	}
+	return &SnowflakeID{worker: uint64(worker), nowFn: time.Now}, nil
+}
+
+// GenerateID generates an ID.
+func (g *SnowflakeID) GenerateID() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var ms uint64
+	if d := g.nowFn().Sub(SnowflakeEpoch); d > 0 {
+		ms = uint64(d / time.Millisecond)
+	}
+	switch {
+	case ms > g.last:
+		g.last = ms
+		g.seq = 0
+	case g.seq < 1<<snowflakeSequenceBits-1:
+		g.seq++
+	default:
+		g.last++
+		g.seq = 0
+	}
+	if g.last == 0 && g.worker == 0 && g.seq == 0 {
+		// Reserve 0, which means "no parent span".
+		g.seq = 1
+	}
+	return (g.last&(1<<41-1))<<(snowflakeWorkerBits+snowflakeSequenceBits) |
+		g.worker<<snowflakeSequenceBits |
+		g.seq
+}
@@ -0,0 +1,157 @@
+package tracer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// generateConcurrently generates n IDs in each of several goroutines
+// and returns them per goroutine.
+func generateConcurrently(g IDGenerator, n int) [][]uint64 {
+	const goroutines = 8
+	ids := make([][]uint64, goroutines)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				ids[i] = append(ids[i], g.GenerateID())
+			}
+		}(i)
+	}
+	wg.Wait()
+	return ids
+}
+
+func checkUnique(t *testing.T, name string, ids [][]uint64) {
+	seen := map[uint64]bool{}
+	for _, l := range ids {
+		for _, id := range l {
+			if id == 0 {
+				t.Errorf("%s generated ID 0", name)
+			}
+			if seen[id] {
+				t.Errorf("%s generated ID %d more than once", name, id)
+			}
+			seen[id] = true
+		}
+	}
+}
+
+func checkIncreasing(t *testing.T, name string, ids [][]uint64) {
+	for _, l := range ids {
+		for i := 1; i < len(l); i++ {
+			if l[i] <= l[i-1] {
+				t.Errorf("%s generated ID %d after %d", name, l[i], l[i-1])
+				break
+			}
+		}
+	}
+}
+
+func TestIDGeneratorsConcurrent(t *testing.T) {
+	snowflake, err := NewSnowflakeID(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUnique(t, "RandomID", generateConcurrently(RandomID{}, 1000))
+	checkUnique(t, "PseudoRandomID", generateConcurrently(NewPseudoRandomID(), 10000))
+
+	ids := generateConcurrently(&SequentialID{}, 10000)
+	checkUnique(t, "SequentialID", ids)
+	checkIncreasing(t, "SequentialID", ids)
+
+	ids = generateConcurrently(snowflake, 10000)
+	checkUnique(t, "SnowflakeID", ids)
+	checkIncreasing(t, "SnowflakeID", ids)
+}
+
+func TestSequentialID(t *testing.T) {
+	g := &SequentialID{}
+	for want := uint64(1); want <= 3; want++ {
+		if id := g.GenerateID(); id != want {
+			t.Errorf("got ID %d, expected %d", id, want)
+		}
+	}
+	g = &SequentialID{last: 1<<64 - 1}
+	if id := g.GenerateID(); id != 1 {
+		t.Errorf("got ID %d after wrapping around, expected 1", id)
+	}
+}
+
+func TestSnowflakeID(t *testing.T) {
+	if _, err := NewSnowflakeID(MaxSnowflakeWorker + 1); err == nil {
+		t.Error("expected error for worker ID out of range")
+	}
+	g, err := NewSnowflakeID(MaxSnowflakeWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := SnowflakeEpoch.Add(time.Second)
+	g.nowFn = func() time.Time { return now }
+
+	id := g.GenerateID()
+	want := uint64(1000)<<22 | MaxSnowflakeWorker<<12
+	if id != want {
+		t.Errorf("got ID %x, expected %x", id, want)
+	}
+	if id>>63 != 0 {
+		t.Error("top bit is set")
+	}
+
+	// Exhausting the sequence borrows the next millisecond.
+	var last uint64
+	for i := 0; i < 4096; i++ {
+		last = g.GenerateID()
+	}
+	want = uint64(1001)<<22 | MaxSnowflakeWorker<<12
+	if last != want {
+		t.Errorf("got ID %x after exhausting the sequence, expected %x", last, want)
+	}
+
+	// IDs keep increasing when the clock goes backwards.
+	now = now.Add(-time.Minute)
+	if id := g.GenerateID(); id <= last {
+		t.Errorf("got ID %x after clock went backwards, expected more than %x", id, last)
+	}
+
+	g, _ = NewSnowflakeID(0)
+	g.nowFn = func() time.Time { return SnowflakeEpoch.Add(-time.Hour) }
+	if id := g.GenerateID(); id == 0 {
+		t.Error("generated ID 0")
+	}
+}
+
+type traceIDGenerator struct {
+	SequentialID
+}
+
+func (traceIDGenerator) GenerateTraceID() uint64 { return 1 << 40 }
+
+func TestRootTraceID(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("", storer, &SequentialID{})
+	root := tr.StartSpan("root")
+	child := tr.StartSpan("child", opentracing.ChildOf(root.Context()))
+	child.Finish()
+	root.Finish()
+
+	if c := storer.spans[0]; c.SpanID != 3 || c.TraceID != 2 || c.ParentID != 1 {
+		t.Errorf("got child span %d in trace %d with parent %d, expected span 3 in trace 2 with parent 1",
+			c.SpanID, c.TraceID, c.ParentID)
+	}
+	if r := storer.spans[1]; r.SpanID != 1 || r.TraceID != 2 || r.ParentID != 0 {
+		t.Errorf("got root span %d in trace %d with parent %d, expected span 1 in trace 2 without parent",
+			r.SpanID, r.TraceID, r.ParentID)
+	}
+
+	tr = NewTracer("", storer, &traceIDGenerator{})
+	raw := tr.StartSpan("root").(*Span).RawSpan()
+	if raw.SpanID != 1 || raw.TraceID != 1<<40 {
+		t.Errorf("got span %d in trace %d, expected span 1 in trace %d", raw.SpanID, raw.TraceID, uint64(1<<40))
+	}
+}
@@ -1,8 +1,12 @@
 package pbutil
 
 import (
+	"fmt"
+	"reflect"
 	"time"
 
+	"github.com/tracer/tracer/pb"
+
 	"github.com/golang/protobuf/ptypes"
 	tspb "github.com/golang/protobuf/ptypes/timestamp"
 )
@@ -15,3 +19,48 @@ func Timestamp(ts *tspb.Timestamp) (time.Time, error) {
 	}
 	return ptypes.Timestamp(ts)
 }
+
+// TagValue returns the value of a tag as a string, int64, uint64,
+// float64 or bool. Tags without a value have the empty string as
+// their value.
+func TagValue(tag *pb.Tag) interface{} {
+	switch v := tag.Value.(type) {
+	case *pb.Tag_Int64Value:
+		return v.Int64Value
+	case *pb.Tag_Uint64Value:
+		return v.Uint64Value
+	case *pb.Tag_NumberValue:
+		return v.NumberValue
+	case *pb.Tag_BoolValue:
+		return v.BoolValue
+	case *pb.Tag_StringValue:
+		return v.StringValue
+	default:
+		return ""
+	}
+}
+
+// SetTagValue sets the value of a tag. Signed and unsigned integers
+// are stored as 64-bit integers, floats as numbers, bools as bools and
+// all other values as strings.
+func SetTagValue(tag *pb.Tag, v interface{}) {
+	if v == nil {
+		tag.Value = nil
+		return
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		tag.Value = &pb.Tag_BoolValue{BoolValue: rv.Bool()}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		tag.Value = &pb.Tag_Int64Value{Int64Value: rv.Int()}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		tag.Value = &pb.Tag_Uint64Value{Uint64Value: rv.Uint()}
+	case reflect.Float32, reflect.Float64:
+		tag.Value = &pb.Tag_NumberValue{NumberValue: rv.Float()}
+	case reflect.String:
+		tag.Value = &pb.Tag_StringValue{StringValue: rv.String()}
+	default:
+		tag.Value = &pb.Tag_StringValue{StringValue: fmt.Sprintf("%v", v)}
+	}
+}
@@ -0,0 +1,61 @@
+package pbutil
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/tracer/tracer/pb"
+
+	"github.com/golang/protobuf/proto"
+)
+
+type stringer struct{}
+
+func (stringer) String() string { return "stringer" }
+
+func TestTagValueRoundTrip(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want interface{}
+	}{
+		{"foo", "foo"},
+		{true, true},
+		{false, false},
+		{1.5, 1.5},
+		{float32(0.5), 0.5},
+		{42, int64(42)},
+		{int8(-8), int64(-8)},
+		{int64(math.MaxInt64), int64(math.MaxInt64)},
+		{int64(math.MinInt64), int64(math.MinInt64)},
+		{int64(1<<53 + 1), int64(1<<53 + 1)},
+		{uint(7), uint64(7)},
+		{uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{uint64(1<<63 + 1), uint64(1<<63 + 1)},
+		{nil, ""},
+		{stringer{}, "stringer"},
+	}
+	for _, test := range tests {
+		tag := &pb.Tag{Key: "k"}
+		SetTagValue(tag, test.in)
+		b, err := proto.Marshal(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out pb.Tag
+		if err := proto.Unmarshal(b, &out); err != nil {
+			t.Fatal(err)
+		}
+		if got := TagValue(&out); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%#v: got %#v (%T), expected %#v (%T)", test.in, got, got, test.want, test.want)
+		}
+	}
+}
+
+func TestTagValueNumber(t *testing.T) {
+	// Older clients send integers as numbers.
+	tag := &pb.Tag{Key: "k", Value: &pb.Tag_NumberValue{NumberValue: 42}}
+	if got := TagValue(tag); got != float64(42) {
+		t.Errorf("got %#v, expected float64(42)", got)
+	}
+}
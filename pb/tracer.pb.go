@@ -12,8 +12,14 @@ It has these top-level messages:
 	Trace
 	Span
 	Tag
+	Log
+	Reference
 	StoreRequest
 	StoreResponse
+	SamplingStrategy
+	OperationSamplingStrategy
+	SamplingStrategyRequest
+	SamplingStrategyResponse
 */
 package pb
 
@@ -38,6 +44,48 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type Reference_Kind int32
+
+const (
+	Reference_CHILD_OF     Reference_Kind = 0
+	Reference_FOLLOWS_FROM Reference_Kind = 1
+)
+
+var Reference_Kind_name = map[int32]string{
+	0: "CHILD_OF",
+	1: "FOLLOWS_FROM",
+}
+var Reference_Kind_value = map[string]int32{
+	"CHILD_OF":     0,
+	"FOLLOWS_FROM": 1,
+}
+
+func (x Reference_Kind) String() string {
+	return proto.EnumName(Reference_Kind_name, int32(x))
+}
+func (Reference_Kind) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{4, 0} }
+
+type SamplingStrategy_Type int32
+
+const (
+	SamplingStrategy_PROBABILISTIC SamplingStrategy_Type = 0
+	SamplingStrategy_RATE_LIMITING SamplingStrategy_Type = 1
+)
+
+var SamplingStrategy_Type_name = map[int32]string{
+	0: "PROBABILISTIC",
+	1: "RATE_LIMITING",
+}
+var SamplingStrategy_Type_value = map[string]int32{
+	"PROBABILISTIC": 0,
+	"RATE_LIMITING": 1,
+}
+
+func (x SamplingStrategy_Type) String() string {
+	return proto.EnumName(SamplingStrategy_Type_name, int32(x))
+}
+func (SamplingStrategy_Type) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{7, 0} }
+
 type Trace struct {
 }
 
@@ -56,6 +104,9 @@ type Span struct {
 	FinishTime    *google_protobuf.Timestamp `protobuf:"bytes,7,opt,name=finish_time" json:"finish_time,omitempty"`
 	Flags         uint64                     `protobuf:"varint,8,opt,name=flags" json:"flags,omitempty"`
 	Tags          []*Tag                     `protobuf:"bytes,9,rep,name=tags" json:"tags,omitempty"`
+	References    []*Reference               `protobuf:"bytes,10,rep,name=references" json:"references,omitempty"`
+	TraceIdHigh   uint64                     `protobuf:"varint,11,opt,name=trace_id_high" json:"trace_id_high,omitempty"`
+	Logs          []*Log                     `protobuf:"bytes,12,rep,name=logs" json:"logs,omitempty"`
 }
 
 func (m *Span) Reset()                    { *m = Span{} }
@@ -84,11 +135,36 @@ func (m *Span) GetTags() []*Tag {
 	return nil
 }
 
+func (m *Span) GetReferences() []*Reference {
+	if m != nil {
+		return m.References
+	}
+	return nil
+}
+
+func (m *Span) GetLogs() []*Log {
+	if m != nil {
+		return m.Logs
+	}
+	return nil
+}
+
 type Tag struct {
 	Key string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
-	// FIXME support non-string values
-	Value string                     `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
-	Time  *google_protobuf.Timestamp `protobuf:"bytes,3,opt,name=time" json:"time,omitempty"`
+	// Integers are sent as int64_value or uint64_value, floats as
+	// number_value. Older clients send integers as number_value.
+	// string_value keeps the field number of the former string value
+	// for compatibility with older clients.
+	//
+	// Types that are valid to be assigned to Value:
+	//	*Tag_StringValue
+	//	*Tag_NumberValue
+	//	*Tag_BoolValue
+	//	*Tag_Int64Value
+	//	*Tag_Uint64Value
+	Value isTag_Value `protobuf_oneof:"value"`
+	// Only set by older clients, which sent log entries as tags.
+	Time *google_protobuf.Timestamp `protobuf:"bytes,3,opt,name=time" json:"time,omitempty"`
 }
 
 func (m *Tag) Reset()                    { *m = Tag{} }
@@ -96,6 +172,74 @@ func (m *Tag) String() string            { return proto.CompactTextString(m) }
 func (*Tag) ProtoMessage()               {}
 func (*Tag) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
 
+type isTag_Value interface {
+	isTag_Value()
+}
+
+type Tag_StringValue struct {
+	StringValue string `protobuf:"bytes,2,opt,name=string_value,oneof"`
+}
+type Tag_NumberValue struct {
+	NumberValue float64 `protobuf:"fixed64,4,opt,name=number_value,oneof"`
+}
+type Tag_BoolValue struct {
+	BoolValue bool `protobuf:"varint,5,opt,name=bool_value,oneof"`
+}
+type Tag_Int64Value struct {
+	Int64Value int64 `protobuf:"varint,6,opt,name=int64_value,oneof"`
+}
+type Tag_Uint64Value struct {
+	Uint64Value uint64 `protobuf:"varint,7,opt,name=uint64_value,oneof"`
+}
+
+func (*Tag_StringValue) isTag_Value() {}
+func (*Tag_NumberValue) isTag_Value() {}
+func (*Tag_BoolValue) isTag_Value()   {}
+func (*Tag_Int64Value) isTag_Value()  {}
+func (*Tag_Uint64Value) isTag_Value() {}
+
+func (m *Tag) GetValue() isTag_Value {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *Tag) GetStringValue() string {
+	if x, ok := m.GetValue().(*Tag_StringValue); ok {
+		return x.StringValue
+	}
+	return ""
+}
+
+func (m *Tag) GetNumberValue() float64 {
+	if x, ok := m.GetValue().(*Tag_NumberValue); ok {
+		return x.NumberValue
+	}
+	return 0
+}
+
+func (m *Tag) GetBoolValue() bool {
+	if x, ok := m.GetValue().(*Tag_BoolValue); ok {
+		return x.BoolValue
+	}
+	return false
+}
+
+func (m *Tag) GetInt64Value() int64 {
+	if x, ok := m.GetValue().(*Tag_Int64Value); ok {
+		return x.Int64Value
+	}
+	return 0
+}
+
+func (m *Tag) GetUint64Value() uint64 {
+	if x, ok := m.GetValue().(*Tag_Uint64Value); ok {
+		return x.Uint64Value
+	}
+	return 0
+}
+
 func (m *Tag) GetTime() *google_protobuf.Timestamp {
 	if m != nil {
 		return m.Time
@@ -103,14 +247,164 @@ func (m *Tag) GetTime() *google_protobuf.Timestamp {
 	return nil
 }
 
+// XXX_OneofFuncs is for the internal use of the proto package.
+func (*Tag) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
+	return _Tag_OneofMarshaler, _Tag_OneofUnmarshaler, _Tag_OneofSizer, []interface{}{
+		(*Tag_StringValue)(nil),
+		(*Tag_NumberValue)(nil),
+		(*Tag_BoolValue)(nil),
+		(*Tag_Int64Value)(nil),
+		(*Tag_Uint64Value)(nil),
+	}
+}
+
+func _Tag_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
+	m := msg.(*Tag)
+	// value
+	switch x := m.Value.(type) {
+	case *Tag_StringValue:
+		b.EncodeVarint(2<<3 | proto.WireBytes)
+		b.EncodeStringBytes(x.StringValue)
+	case *Tag_NumberValue:
+		b.EncodeVarint(4<<3 | proto.WireFixed64)
+		b.EncodeFixed64(math.Float64bits(x.NumberValue))
+	case *Tag_BoolValue:
+		t := uint64(0)
+		if x.BoolValue {
+			t = 1
+		}
+		b.EncodeVarint(5<<3 | proto.WireVarint)
+		b.EncodeVarint(t)
+	case *Tag_Int64Value:
+		b.EncodeVarint(6<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(x.Int64Value))
+	case *Tag_Uint64Value:
+		b.EncodeVarint(7<<3 | proto.WireVarint)
+		b.EncodeVarint(uint64(x.Uint64Value))
+	case nil:
+	default:
+		return fmt.Errorf("Tag.Value has unexpected type %T", x)
+	}
+	return nil
+}
+
+func _Tag_OneofUnmarshaler(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error) {
+	m := msg.(*Tag)
+	switch tag {
+	case 2: // value.string_value
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeStringBytes()
+		m.Value = &Tag_StringValue{x}
+		return true, err
+	case 4: // value.number_value
+		if wire != proto.WireFixed64 {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeFixed64()
+		m.Value = &Tag_NumberValue{math.Float64frombits(x)}
+		return true, err
+	case 5: // value.bool_value
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Value = &Tag_BoolValue{x != 0}
+		return true, err
+	case 6: // value.int64_value
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Value = &Tag_Int64Value{int64(x)}
+		return true, err
+	case 7: // value.uint64_value
+		if wire != proto.WireVarint {
+			return true, proto.ErrInternalBadWireType
+		}
+		x, err := b.DecodeVarint()
+		m.Value = &Tag_Uint64Value{x}
+		return true, err
+	default:
+		return false, nil
+	}
+}
+
+func _Tag_OneofSizer(msg proto.Message) (n int) {
+	m := msg.(*Tag)
+	// value
+	switch x := m.Value.(type) {
+	case *Tag_StringValue:
+		n += proto.SizeVarint(2<<3 | proto.WireBytes)
+		n += proto.SizeVarint(uint64(len(x.StringValue)))
+		n += len(x.StringValue)
+	case *Tag_NumberValue:
+		n += proto.SizeVarint(4<<3 | proto.WireFixed64)
+		n += 8
+	case *Tag_BoolValue:
+		n += proto.SizeVarint(5<<3 | proto.WireVarint)
+		n += 1
+	case *Tag_Int64Value:
+		n += proto.SizeVarint(6<<3 | proto.WireVarint)
+		n += proto.SizeVarint(uint64(x.Int64Value))
+	case *Tag_Uint64Value:
+		n += proto.SizeVarint(7<<3 | proto.WireVarint)
+		n += proto.SizeVarint(uint64(x.Uint64Value))
+	case nil:
+	default:
+		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
+	}
+	return n
+}
+
+type Log struct {
+	Time   *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=time" json:"time,omitempty"`
+	Fields []*Tag                     `protobuf:"bytes,2,rep,name=fields" json:"fields,omitempty"`
+}
+
+func (m *Log) Reset()                    { *m = Log{} }
+func (m *Log) String() string            { return proto.CompactTextString(m) }
+func (*Log) ProtoMessage()               {}
+func (*Log) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *Log) GetTime() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Time
+	}
+	return nil
+}
+
+func (m *Log) GetFields() []*Tag {
+	if m != nil {
+		return m.Fields
+	}
+	return nil
+}
+
+type Reference struct {
+	Kind        Reference_Kind `protobuf:"varint,1,opt,name=kind,enum=Reference_Kind" json:"kind,omitempty"`
+	TraceId     uint64         `protobuf:"varint,2,opt,name=trace_id" json:"trace_id,omitempty"`
+	SpanId      uint64         `protobuf:"varint,3,opt,name=span_id" json:"span_id,omitempty"`
+	TraceIdHigh uint64         `protobuf:"varint,4,opt,name=trace_id_high" json:"trace_id_high,omitempty"`
+}
+
+func (m *Reference) Reset()                    { *m = Reference{} }
+func (m *Reference) String() string            { return proto.CompactTextString(m) }
+func (*Reference) ProtoMessage()               {}
+func (*Reference) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
 type StoreRequest struct {
 	Spans []*Span `protobuf:"bytes,1,rep,name=spans" json:"spans,omitempty"`
+	// Attributes of the process that created the spans, such as its
+	// hostname. They apply to all spans of the request.
+	Resource []*Tag `protobuf:"bytes,2,rep,name=resource" json:"resource,omitempty"`
 }
 
 func (m *StoreRequest) Reset()                    { *m = StoreRequest{} }
 func (m *StoreRequest) String() string            { return proto.CompactTextString(m) }
 func (*StoreRequest) ProtoMessage()               {}
-func (*StoreRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*StoreRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
 
 func (m *StoreRequest) GetSpans() []*Span {
 	if m != nil {
@@ -119,20 +413,99 @@ func (m *StoreRequest) GetSpans() []*Span {
 	return nil
 }
 
+func (m *StoreRequest) GetResource() []*Tag {
+	if m != nil {
+		return m.Resource
+	}
+	return nil
+}
+
 type StoreResponse struct {
 }
 
 func (m *StoreResponse) Reset()                    { *m = StoreResponse{} }
 func (m *StoreResponse) String() string            { return proto.CompactTextString(m) }
 func (*StoreResponse) ProtoMessage()               {}
-func (*StoreResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+func (*StoreResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+type SamplingStrategy struct {
+	Type SamplingStrategy_Type `protobuf:"varint,1,opt,name=type,enum=SamplingStrategy_Type" json:"type,omitempty"`
+	// The probability for probabilistic strategies, the number of
+	// traces per second for rate limiting strategies.
+	Param float64 `protobuf:"fixed64,2,opt,name=param" json:"param,omitempty"`
+}
+
+func (m *SamplingStrategy) Reset()                    { *m = SamplingStrategy{} }
+func (m *SamplingStrategy) String() string            { return proto.CompactTextString(m) }
+func (*SamplingStrategy) ProtoMessage()               {}
+func (*SamplingStrategy) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+type OperationSamplingStrategy struct {
+	OperationName string            `protobuf:"bytes,1,opt,name=operation_name" json:"operation_name,omitempty"`
+	Strategy      *SamplingStrategy `protobuf:"bytes,2,opt,name=strategy" json:"strategy,omitempty"`
+}
+
+func (m *OperationSamplingStrategy) Reset()                    { *m = OperationSamplingStrategy{} }
+func (m *OperationSamplingStrategy) String() string            { return proto.CompactTextString(m) }
+func (*OperationSamplingStrategy) ProtoMessage()               {}
+func (*OperationSamplingStrategy) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *OperationSamplingStrategy) GetStrategy() *SamplingStrategy {
+	if m != nil {
+		return m.Strategy
+	}
+	return nil
+}
+
+type SamplingStrategyRequest struct {
+	ServiceName string `protobuf:"bytes,1,opt,name=service_name" json:"service_name,omitempty"`
+}
+
+func (m *SamplingStrategyRequest) Reset()                    { *m = SamplingStrategyRequest{} }
+func (m *SamplingStrategyRequest) String() string            { return proto.CompactTextString(m) }
+func (*SamplingStrategyRequest) ProtoMessage()               {}
+func (*SamplingStrategyRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+type SamplingStrategyResponse struct {
+	// The strategy for operations without a strategy of their own. If
+	// unset, clients use their local default.
+	DefaultStrategy *SamplingStrategy            `protobuf:"bytes,1,opt,name=default_strategy" json:"default_strategy,omitempty"`
+	Operations      []*OperationSamplingStrategy `protobuf:"bytes,2,rep,name=operations" json:"operations,omitempty"`
+}
+
+func (m *SamplingStrategyResponse) Reset()                    { *m = SamplingStrategyResponse{} }
+func (m *SamplingStrategyResponse) String() string            { return proto.CompactTextString(m) }
+func (*SamplingStrategyResponse) ProtoMessage()               {}
+func (*SamplingStrategyResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *SamplingStrategyResponse) GetDefaultStrategy() *SamplingStrategy {
+	if m != nil {
+		return m.DefaultStrategy
+	}
+	return nil
+}
+
+func (m *SamplingStrategyResponse) GetOperations() []*OperationSamplingStrategy {
+	if m != nil {
+		return m.Operations
+	}
+	return nil
+}
 
 func init() {
 	proto.RegisterType((*Trace)(nil), "Trace")
 	proto.RegisterType((*Span)(nil), "Span")
 	proto.RegisterType((*Tag)(nil), "Tag")
+	proto.RegisterType((*Log)(nil), "Log")
+	proto.RegisterType((*Reference)(nil), "Reference")
 	proto.RegisterType((*StoreRequest)(nil), "StoreRequest")
 	proto.RegisterType((*StoreResponse)(nil), "StoreResponse")
+	proto.RegisterType((*SamplingStrategy)(nil), "SamplingStrategy")
+	proto.RegisterType((*OperationSamplingStrategy)(nil), "OperationSamplingStrategy")
+	proto.RegisterType((*SamplingStrategyRequest)(nil), "SamplingStrategyRequest")
+	proto.RegisterType((*SamplingStrategyResponse)(nil), "SamplingStrategyResponse")
+	proto.RegisterEnum("Reference_Kind", Reference_Kind_name, Reference_Kind_value)
+	proto.RegisterEnum("SamplingStrategy_Type", SamplingStrategy_Type_name, SamplingStrategy_Type_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -207,29 +580,124 @@ var _Storer_serviceDesc = grpc.ServiceDesc{
 	Metadata: fileDescriptor0,
 }
 
+// Client API for Sampling service
+
+type SamplingClient interface {
+	GetSamplingStrategy(ctx context.Context, in *SamplingStrategyRequest, opts ...grpc.CallOption) (*SamplingStrategyResponse, error)
+}
+
+type samplingClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewSamplingClient(cc *grpc.ClientConn) SamplingClient {
+	return &samplingClient{cc}
+}
+
+func (c *samplingClient) GetSamplingStrategy(ctx context.Context, in *SamplingStrategyRequest, opts ...grpc.CallOption) (*SamplingStrategyResponse, error) {
+	out := new(SamplingStrategyResponse)
+	err := grpc.Invoke(ctx, "/Sampling/GetSamplingStrategy", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Sampling service
+
+type SamplingServer interface {
+	GetSamplingStrategy(context.Context, *SamplingStrategyRequest) (*SamplingStrategyResponse, error)
+}
+
+func RegisterSamplingServer(s *grpc.Server, srv SamplingServer) {
+	s.RegisterService(&_Sampling_serviceDesc, srv)
+}
+
+func _Sampling_GetSamplingStrategy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SamplingStrategyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SamplingServer).GetSamplingStrategy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Sampling/GetSamplingStrategy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SamplingServer).GetSamplingStrategy(ctx, req.(*SamplingStrategyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Sampling_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "Sampling",
+	HandlerType: (*SamplingServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetSamplingStrategy",
+			Handler:    _Sampling_GetSamplingStrategy_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: fileDescriptor0,
+}
+
 func init() { proto.RegisterFile("tracer.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 325 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x84, 0x90, 0xcd, 0x6e, 0xea, 0x30,
-	0x10, 0x85, 0xc5, 0x4d, 0x02, 0x64, 0xf8, 0xbb, 0xb5, 0xaa, 0x2a, 0x62, 0x85, 0xa2, 0xaa, 0x62,
-	0xe5, 0x54, 0x74, 0xd9, 0x47, 0xe8, 0xae, 0xb0, 0x47, 0x86, 0x0e, 0xc6, 0x6a, 0x62, 0xbb, 0xb6,
-	0x83, 0xc4, 0x4b, 0xf7, 0x19, 0x6a, 0x3b, 0x45, 0x6d, 0x57, 0xac, 0x66, 0xf2, 0xc5, 0x67, 0xce,
-	0x99, 0x81, 0xb1, 0x33, 0x6c, 0x8f, 0x86, 0x6a, 0xa3, 0x9c, 0x9a, 0x3f, 0x73, 0xe1, 0x8e, 0xed,
-	0x8e, 0xee, 0x55, 0x53, 0x71, 0x55, 0x33, 0xc9, 0xab, 0xf8, 0x63, 0xd7, 0x1e, 0x2a, 0xed, 0xce,
-	0x1a, 0x6d, 0xe5, 0x44, 0x83, 0xd6, 0xb1, 0x46, 0xff, 0x74, 0x9d, 0xb8, 0x1c, 0x40, 0xb6, 0x09,
-	0xc3, 0xca, 0xcf, 0x1e, 0xa4, 0x6b, 0xcd, 0x24, 0x99, 0xc1, 0xc0, 0xfa, 0xba, 0x15, 0x6f, 0x45,
-	0x6f, 0xd1, 0x5b, 0xa6, 0xe4, 0x06, 0x72, 0xcd, 0x0c, 0x4a, 0x17, 0xd0, 0xbf, 0x88, 0xfe, 0xc3,
-	0x30, 0x46, 0x08, 0x24, 0x89, 0xe4, 0x16, 0xc6, 0x16, 0xcd, 0x49, 0x78, 0x26, 0x59, 0x83, 0x45,
-	0xea, 0x69, 0x4e, 0xee, 0x60, 0xaa, 0x34, 0x1a, 0xe6, 0x84, 0x92, 0x1d, 0xcf, 0x22, 0xa7, 0x00,
-	0x3e, 0x84, 0x71, 0xdb, 0x10, 0xa7, 0xe8, 0x7b, 0x36, 0x5a, 0xcd, 0x29, 0x57, 0x8a, 0xd7, 0x48,
-	0x2f, 0xe1, 0xe9, 0xe6, 0x92, 0x95, 0x54, 0x30, 0x3a, 0x08, 0x29, 0xec, 0xb1, 0x13, 0x0c, 0xae,
-	0x0a, 0x26, 0x90, 0x1d, 0x6a, 0xc6, 0x6d, 0x31, 0x8c, 0xe9, 0x08, 0xa4, 0x2e, 0x7c, 0xe5, 0x8b,
-	0xc4, 0x0b, 0x53, 0xba, 0x61, 0xbc, 0x7c, 0x81, 0xc4, 0x17, 0x32, 0x82, 0xe4, 0x1d, 0xcf, 0x71,
-	0xd5, 0x3c, 0xc8, 0x4e, 0xac, 0x6e, 0x31, 0xae, 0x99, 0x93, 0xa5, 0x97, 0x05, 0xbf, 0xe4, 0x9a,
-	0x5f, 0x79, 0x0f, 0xe3, 0xb5, 0x53, 0x06, 0x5f, 0xf1, 0xa3, 0xf5, 0xc8, 0x9f, 0x23, 0x0b, 0x47,
-	0xb4, 0x7e, 0x6e, 0x70, 0xcc, 0x68, 0x38, 0x6d, 0x39, 0x83, 0xc9, 0xf7, 0x2b, 0xab, 0x95, 0xb4,
-	0xb8, 0x7a, 0x84, 0x7e, 0x04, 0x86, 0x3c, 0x40, 0x16, 0x3b, 0x32, 0xa1, 0xbf, 0x07, 0xcd, 0xa7,
-	0xf4, 0x8f, 0x62, 0xd7, 0x8f, 0xe6, 0x4f, 0x5f, 0x01, 0x00, 0x00, 0xff, 0xff, 0xac, 0xad, 0x33,
-	0xe1, 0x03, 0x02, 0x00, 0x00,
+	// 829 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x8c, 0x54, 0x5d, 0x8f, 0xdb, 0x44,
+	0x14, 0x8d, 0xd7, 0xce, 0xd7, 0x75, 0xb2, 0x9b, 0x0e, 0x88, 0x7a, 0x53, 0x24, 0x52, 0x57, 0x54,
+	0xab, 0x0a, 0x66, 0x51, 0x40, 0x48, 0xd0, 0xbe, 0x74, 0x0b, 0xdb, 0x18, 0xb2, 0x0d, 0x9a, 0x44,
+	0xe5, 0xd1, 0x9a, 0x24, 0x13, 0xc7, 0xaa, 0xe3, 0x71, 0x67, 0xc6, 0x95, 0xf6, 0x15, 0xfe, 0x02,
+	0x3f, 0x04, 0x89, 0x3f, 0x88, 0x66, 0xec, 0xb8, 0x49, 0xcd, 0xaa, 0xbc, 0x79, 0xce, 0x3d, 0x77,
+	0xee, 0xdc, 0x73, 0x7c, 0x2f, 0xf4, 0x94, 0xa0, 0x2b, 0x26, 0x70, 0x26, 0xb8, 0xe2, 0xc3, 0xa7,
+	0x51, 0xac, 0xb6, 0xf9, 0x12, 0xaf, 0xf8, 0xee, 0x32, 0xe2, 0x09, 0x4d, 0xa3, 0x4b, 0x13, 0x58,
+	0xe6, 0x9b, 0xcb, 0x4c, 0xdd, 0x66, 0x4c, 0x5e, 0xaa, 0x78, 0xc7, 0xa4, 0xa2, 0xbb, 0xec, 0xfd,
+	0x57, 0x91, 0xec, 0xb7, 0xa1, 0xb9, 0xd0, 0x97, 0xf9, 0xff, 0xd8, 0xe0, 0xcc, 0x33, 0x9a, 0xa2,
+	0xfb, 0xd0, 0x96, 0x19, 0x4d, 0xc3, 0x78, 0xed, 0x59, 0x23, 0xeb, 0xc2, 0x21, 0x2d, 0x7d, 0x0c,
+	0xd6, 0xe8, 0x01, 0x74, 0x33, 0x2a, 0x58, 0xaa, 0x74, 0xe8, 0xc4, 0x84, 0x3a, 0x05, 0x10, 0xac,
+	0xd1, 0x39, 0x74, 0xcc, 0xa3, 0x74, 0xcc, 0x36, 0xb1, 0xb6, 0x39, 0x07, 0x6b, 0xf4, 0x10, 0x7a,
+	0x92, 0x89, 0x77, 0xf1, 0x8a, 0x85, 0x29, 0xdd, 0x31, 0xcf, 0x19, 0x59, 0x17, 0x5d, 0xe2, 0x96,
+	0xd8, 0x2b, 0xba, 0x63, 0xe8, 0x4b, 0x38, 0xe5, 0x19, 0x13, 0x54, 0xc5, 0x3c, 0x2d, 0x48, 0x4d,
+	0x43, 0xea, 0x57, 0xa8, 0xa1, 0xfd, 0x00, 0x20, 0x15, 0x15, 0x2a, 0xd4, 0x5d, 0x78, 0xad, 0x91,
+	0x75, 0xe1, 0x8e, 0x87, 0x38, 0xe2, 0x3c, 0x4a, 0x18, 0xde, 0xf7, 0x8c, 0x17, 0xfb, 0x16, 0x49,
+	0xd7, 0xb0, 0xf5, 0x19, 0x3d, 0x05, 0x77, 0x13, 0xa7, 0xb1, 0xdc, 0x16, 0xb9, 0xed, 0x8f, 0xe6,
+	0x42, 0x41, 0x37, 0xc9, 0x9f, 0x42, 0x73, 0x93, 0xd0, 0x48, 0x7a, 0x1d, 0xd3, 0x59, 0x71, 0x40,
+	0x1e, 0x38, 0x4a, 0x83, 0xdd, 0x91, 0x7d, 0xe1, 0x8e, 0x1d, 0xbc, 0xa0, 0x11, 0x31, 0x08, 0x7a,
+	0x02, 0x20, 0xd8, 0x86, 0x09, 0x96, 0xae, 0x98, 0xf4, 0xc0, 0xc4, 0x01, 0x93, 0x3d, 0x44, 0x0e,
+	0xa2, 0xc8, 0x87, 0xfe, 0x5e, 0xb8, 0x70, 0x1b, 0x47, 0x5b, 0xcf, 0x35, 0x35, 0xdc, 0x52, 0xbd,
+	0x49, 0x1c, 0x6d, 0x75, 0xa5, 0x84, 0x47, 0xd2, 0xeb, 0x95, 0x95, 0xa6, 0x3c, 0x22, 0x06, 0xf1,
+	0xff, 0x3c, 0x01, 0x7b, 0x41, 0x23, 0x34, 0x00, 0xfb, 0x0d, 0xbb, 0x35, 0x86, 0x75, 0x89, 0xfe,
+	0x44, 0x8f, 0xa0, 0x27, 0x95, 0x88, 0xd3, 0x28, 0x7c, 0x47, 0x93, 0x9c, 0x19, 0xc3, 0xba, 0x93,
+	0x06, 0x71, 0x0b, 0xf4, 0xb5, 0x06, 0x35, 0x29, 0xcd, 0x77, 0x4b, 0x26, 0x4a, 0x92, 0xb6, 0xc6,
+	0xd2, 0xa4, 0x02, 0x2d, 0x48, 0x5f, 0x00, 0x2c, 0x39, 0x4f, 0x4a, 0x8a, 0x36, 0xa6, 0x33, 0x69,
+	0x90, 0xae, 0xc6, 0x0a, 0xc2, 0x43, 0x70, 0xe3, 0x54, 0x7d, 0xff, 0x5d, 0xc9, 0xd0, 0xbe, 0xd8,
+	0x93, 0x06, 0x01, 0x03, 0x56, 0x85, 0xf2, 0x43, 0x8e, 0xd6, 0xdf, 0xd1, 0x85, 0xf2, 0x03, 0x12,
+	0x06, 0xc7, 0x98, 0x63, 0x7f, 0xd4, 0x1c, 0xc3, 0xbb, 0x6a, 0x43, 0xd3, 0xdc, 0xe6, 0xcf, 0xc1,
+	0x9e, 0xf2, 0xa8, 0xca, 0xb7, 0xfe, 0x5f, 0x3e, 0xfa, 0x1c, 0x5a, 0x9b, 0x98, 0x25, 0x6b, 0xe9,
+	0x9d, 0x1c, 0x58, 0x58, 0x62, 0xfe, 0xdf, 0x16, 0x74, 0x2b, 0xcb, 0xd0, 0x23, 0x70, 0xde, 0xc4,
+	0x69, 0x31, 0x12, 0xa7, 0xe3, 0xb3, 0xf7, 0x66, 0xe2, 0x5f, 0xe3, 0x74, 0x4d, 0x4c, 0xf0, 0x68,
+	0x08, 0x4e, 0x8e, 0x87, 0xe0, 0x60, 0xaa, 0xec, 0xa3, 0xa9, 0xaa, 0xf9, 0xef, 0xd4, 0xfc, 0xf7,
+	0x1f, 0x83, 0xa3, 0xab, 0xa0, 0x1e, 0x74, 0x5e, 0x4c, 0x82, 0xe9, 0x4f, 0xe1, 0xec, 0x7a, 0xd0,
+	0x40, 0x03, 0xe8, 0x5d, 0xcf, 0xa6, 0xd3, 0xd9, 0xef, 0xf3, 0xf0, 0x9a, 0xcc, 0x6e, 0x06, 0x96,
+	0x7f, 0x03, 0xbd, 0xb9, 0xe2, 0x82, 0x11, 0xf6, 0x36, 0x67, 0x52, 0xa1, 0x07, 0xd0, 0xd4, 0x55,
+	0xa4, 0x67, 0x99, 0xfe, 0x9a, 0x58, 0x0f, 0x38, 0x29, 0x30, 0x34, 0x82, 0x8e, 0x60, 0x92, 0xe7,
+	0x62, 0xc5, 0x8e, 0xfa, 0xaf, 0x50, 0xff, 0x0c, 0xfa, 0xe5, 0x75, 0x32, 0xe3, 0xa9, 0x64, 0xfe,
+	0x1f, 0x16, 0x0c, 0xe6, 0x74, 0x97, 0x25, 0x71, 0x1a, 0xcd, 0x95, 0xa0, 0x8a, 0x45, 0xb7, 0xe8,
+	0x09, 0x38, 0x7a, 0xc9, 0x94, 0xca, 0x7c, 0x86, 0x3f, 0x24, 0xe0, 0xc5, 0x6d, 0xc6, 0x88, 0xe1,
+	0xe8, 0x41, 0xca, 0xa8, 0xa0, 0x3b, 0xa3, 0x8e, 0x45, 0x8a, 0x83, 0xff, 0x15, 0x38, 0x9a, 0x83,
+	0xee, 0x41, 0xff, 0x37, 0x32, 0xbb, 0x7a, 0x7e, 0x15, 0x4c, 0x83, 0xf9, 0x22, 0x78, 0x31, 0x68,
+	0x68, 0x88, 0x3c, 0x5f, 0xfc, 0x1c, 0x4e, 0x83, 0x9b, 0x60, 0x11, 0xbc, 0x7a, 0x39, 0xb0, 0xfc,
+	0xb7, 0x70, 0x3e, 0xdb, 0x6f, 0x85, 0xda, 0x63, 0xea, 0x8b, 0xc4, 0xfa, 0xaf, 0x45, 0xf2, 0x35,
+	0x74, 0x64, 0x99, 0x62, 0x9e, 0xe2, 0x8e, 0xef, 0xd5, 0xde, 0x4d, 0x2a, 0x8a, 0xff, 0x0c, 0xee,
+	0xd7, 0xa2, 0xa5, 0xc4, 0x1f, 0x2e, 0x37, 0xab, 0xb6, 0xdc, 0xfc, 0xbf, 0x2c, 0xf0, 0xea, 0xe9,
+	0x85, 0xa4, 0xe8, 0x19, 0x0c, 0xd6, 0x6c, 0x43, 0xf3, 0x44, 0x85, 0xd5, 0x8b, 0xac, 0xbb, 0x5e,
+	0x74, 0x56, 0x52, 0xab, 0x76, 0x7f, 0x04, 0xa8, 0x1a, 0xdb, 0xff, 0xc5, 0x43, 0x7c, 0xa7, 0x3c,
+	0xe4, 0x80, 0x3d, 0xfe, 0x06, 0x5a, 0xc6, 0x5d, 0x81, 0x1e, 0x43, 0xd3, 0x7c, 0xa1, 0x3e, 0x3e,
+	0xfc, 0x7d, 0x86, 0xa7, 0xf8, 0xc8, 0xfe, 0xf1, 0x6b, 0xe8, 0xec, 0x6f, 0x44, 0xbf, 0xc0, 0x27,
+	0x2f, 0x99, 0xaa, 0xe9, 0xef, 0xe1, 0x3b, 0x84, 0x1a, 0x9e, 0xe3, 0xbb, 0x34, 0x58, 0xb6, 0xcc,
+	0x84, 0x7e, 0xfb, 0xef, 0x00, 0x29, 0xde, 0xe3, 0xce, 0xd7, 0x06, 0x00, 0x00,
 }
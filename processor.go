@@ -0,0 +1,146 @@
+package tracer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A SpanProcessor observes the sampled spans of a tracer. Processors
+// can enrich spans with additional tags, redact values or derive
+// metrics before spans reach the storer.
+type SpanProcessor interface {
+	// OnStart is called when a span starts, before StartSpan returns.
+	// It runs in the goroutine that starts the span and must return
+	// quickly. Changes to sp become part of the span.
+	OnStart(sp *RawSpan)
+	// OnFinish is called when a span finishes, before it is stored.
+	// It runs in the tracer's processing goroutine, not in the
+	// goroutine that finishes the span. Changes to sp are stored.
+	OnFinish(sp *RawSpan)
+}
+
+// DefaultProcessorQueueSize is the number of finished spans that may
+// wait for processors if Tracer.ProcessorQueueSize is zero.
+const DefaultProcessorQueueSize = 1024
+
+// NewTagProcessor returns a SpanProcessor that adds tags to all
+// spans when they start, such as the hostname or version of a
+// service. Tags that are set by start options aren't overwritten.
+func NewTagProcessor(tags map[string]interface{}) SpanProcessor {
+	return tagProcessor(tags)
+}
+
+type tagProcessor map[string]interface{}
+
+func (p tagProcessor) OnStart(sp *RawSpan) {
+	for k, v := range p {
+		if _, ok := sp.Tags[k]; !ok {
+			sp.Tags[k] = v
+		}
+	}
+}
+
+func (tagProcessor) OnFinish(sp *RawSpan) {}
+
+var droppedProcessorSpans = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "tracer_processor_dropped_spans_total",
+	Help: "Number of finished spans dropped because the processor queue was full",
+})
+
+func init() {
+	prometheus.MustRegister(droppedProcessorSpans)
+}
+
+// processorItem is either a finished span, or a request to signal
+// once all spans queued before it have been stored.
+type processorItem struct {
+	span  RawSpan
+	flush chan struct{}
+}
+
+// startSpanProcessors calls the OnStart methods of the tracer's
+// processors.
+func (tr *Tracer) startSpanProcessors(sp *RawSpan) {
+	if len(tr.Processors) == 0 {
+		return
+	}
+	// Don't modify the caller's map of tags.
+	tags := make(map[string]interface{}, len(sp.Tags))
+	for k, v := range sp.Tags {
+		tags[k] = v
+	}
+	sp.Tags = tags
+	for _, p := range tr.Processors {
+		p.OnStart(sp)
+	}
+}
+
+// finish stores a finished span. If the tracer has processors, the
+// span is queued for them and stored by the processing goroutine.
+// Spans are dropped if the queue is full or the tracer was closed.
+func (tr *Tracer) finish(sp RawSpan) {
+	if len(tr.Processors) == 0 {
+		tr.store(sp)
+		return
+	}
+	tr.processorOnce.Do(tr.startProcessing)
+	tr.processorMu.RLock()
+	defer tr.processorMu.RUnlock()
+	if tr.processorClosed {
+		droppedProcessorSpans.Inc()
+		return
+	}
+	select {
+	case tr.processorCh <- processorItem{span: sp}:
+	default:
+		droppedProcessorSpans.Inc()
+	}
+}
+
+func (tr *Tracer) startProcessing() {
+	size := tr.ProcessorQueueSize
+	if size == 0 {
+		size = DefaultProcessorQueueSize
+	}
+	tr.processorCh = make(chan processorItem, size)
+	tr.processorDone = make(chan struct{})
+	go tr.process()
+}
+
+// process runs until Close closes processorCh.
+func (tr *Tracer) process() {
+	defer close(tr.processorDone)
+	for item := range tr.processorCh {
+		if item.flush != nil {
+			close(item.flush)
+			continue
+		}
+		for _, p := range tr.Processors {
+			p.OnFinish(&item.span)
+		}
+		tr.store(item.span)
+	}
+}
+
+// flushProcessors waits until all queued spans have been processed
+// and stored.
+func (tr *Tracer) flushProcessors() {
+	if len(tr.Processors) == 0 {
+		return
+	}
+	tr.processorOnce.Do(tr.startProcessing)
+	ch := make(chan struct{})
+	tr.processorMu.RLock()
+	if tr.processorClosed {
+		tr.processorMu.RUnlock()
+		return
+	}
+	tr.processorCh <- processorItem{flush: ch}
+	tr.processorMu.RUnlock()
+	<-ch
+}
+
+func (tr *Tracer) store(sp RawSpan) {
+	if err := tr.storer.Store(sp); err != nil {
+		tr.Logger.Printf("error while storing tracing span: %s", err)
+	}
+}
@@ -0,0 +1,132 @@
+package tracer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+type funcProcessor struct {
+	onStart  func(sp *RawSpan)
+	onFinish func(sp *RawSpan)
+}
+
+func (p funcProcessor) OnStart(sp *RawSpan) {
+	if p.onStart != nil {
+		p.onStart(sp)
+	}
+}
+
+func (p funcProcessor) OnFinish(sp *RawSpan) {
+	if p.onFinish != nil {
+		p.onFinish(sp)
+	}
+}
+
+func TestProcessors(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("", storer, RandomID{})
+	var order []string
+	tr.Processors = []SpanProcessor{
+		NewTagProcessor(map[string]interface{}{"hostname": "web1", "version": "1.0"}),
+		funcProcessor{
+			onStart: func(sp *RawSpan) { order = append(order, "start 1") },
+			onFinish: func(sp *RawSpan) {
+				order = append(order, "finish 1")
+				if _, ok := sp.Tags["password"]; ok {
+					sp.Tags["password"] = "redacted"
+				}
+			},
+		},
+		funcProcessor{
+			onStart:  func(sp *RawSpan) { order = append(order, "start 2") },
+			onFinish: func(sp *RawSpan) { order = append(order, "finish 2") },
+		},
+	}
+
+	startTags := map[string]interface{}{"version": "2.0"}
+	sp := tr.StartSpan("login", opentracing.Tags(startTags))
+	sp.SetTag("password", "hunter2")
+	sp.Finish()
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(startTags) != 1 {
+		t.Errorf("processors modified start tags: %v", startTags)
+	}
+	wantOrder := []string{"start 1", "start 2", "finish 1", "finish 2"}
+	if !reflect.DeepEqual(order, wantOrder) {
+		t.Errorf("processors were called in order %v, expected %v", order, wantOrder)
+	}
+	if len(storer.spans) != 1 {
+		t.Fatalf("got %d stored spans, expected 1", len(storer.spans))
+	}
+	want := map[string]interface{}{"hostname": "web1", "version": "2.0", "password": "redacted"}
+	for k, v := range want {
+		if got := storer.spans[0].Tags[k]; got != v {
+			t.Errorf("got tag %s=%v, expected %v", k, got, v)
+		}
+	}
+}
+
+func TestProcessorsDontBlock(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("", storer, RandomID{})
+	tr.ProcessorQueueSize = 2
+	block := make(chan struct{})
+	started := make(chan struct{}, 1)
+	tr.Processors = []SpanProcessor{funcProcessor{onFinish: func(sp *RawSpan) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-block
+	}}}
+
+	// The first span blocks the processing goroutine, the next two
+	// fill the queue and the rest are dropped.
+	tr.StartSpan("first").Finish()
+	<-started
+	for i := 0; i < 10; i++ {
+		tr.StartSpan("more").Finish()
+	}
+	close(block)
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(storer.spans) != 3 {
+		t.Errorf("got %d stored spans, expected 3", len(storer.spans))
+	}
+}
+
+func TestTracerClose(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("", storer, RandomID{})
+	tr.Processors = []SpanProcessor{NewTagProcessor(map[string]interface{}{"version": "1.0"})}
+	tr.StartSpan("before").Finish()
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-tr.processorDone:
+	default:
+		t.Fatal("processing goroutine didn't stop")
+	}
+
+	// Spans that finish after Close are dropped, and flushing or
+	// closing again doesn't block.
+	tr.StartSpan("after").Finish()
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	storer.mu.Lock()
+	defer storer.mu.Unlock()
+	if len(storer.spans) != 1 || storer.spans[0].OperationName != "before" {
+		t.Errorf("got %d stored spans, expected only the one finished before closing", len(storer.spans))
+	}
+}
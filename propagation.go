@@ -5,6 +5,7 @@ import (
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/opentracing/opentracing-go"
 )
@@ -15,36 +16,206 @@ type Extracter func(carrier interface{}) (SpanContext, error)
 // An Injecter injects a SpanContext into carrier.
 type Injecter func(sm SpanContext, carrier interface{}) error
 
-var extracters = map[interface{}]Extracter{
-	opentracing.HTTPHeaders: textExtracter,
-	opentracing.TextMap:     textExtracter,
-	opentracing.Binary:      binaryExtracter,
+// A Format is a propagation format provided by this package, in
+// addition to the formats defined by OpenTracing.
+type Format string
+
+const (
+	// FormatTraceContext is the W3C Trace Context format, using the
+	// traceparent and tracestate headers. Its carriers are the same
+	// as those of opentracing.HTTPHeaders.
+	FormatTraceContext Format = "tracecontext"
+	// FormatB3 is Zipkin's B3 format, using multiple X-B3-* headers.
+	// Its carriers are the same as those of opentracing.HTTPHeaders.
+	// Extraction also accepts the single b3 header.
+	FormatB3 Format = "b3"
+	// FormatB3Single is Zipkin's B3 format, using the single b3
+	// header. Extraction also accepts multiple X-B3-* headers.
+	FormatB3Single Format = "b3-single"
+	// FormatAny accepts the formats of opentracing.HTTPHeaders,
+	// FormatTraceContext and FormatB3, whichever is present in the
+	// carrier. It only supports extraction.
+	FormatAny Format = "any"
+)
+
+// AnyExtracter is the Extracter of FormatAny. It accepts tracer,
+// W3C Trace Context and B3 headers, in that order of preference.
+var AnyExtracter = NewMultiExtracter(textExtracter, TraceContextExtracter, B3Extracter)
+
+// NewMultiExtracter returns an Extracter that tries each of the
+// extracters in order and returns the first span context that was
+// found. If none was found, it returns the first error other than
+// opentracing.ErrSpanContextNotFound, if any.
+func NewMultiExtracter(extracters ...Extracter) Extracter {
+	return func(carrier interface{}) (SpanContext, error) {
+		var firstErr error
+		for _, extracter := range extracters {
+			context, err := extracter(carrier)
+			if err == nil {
+				return context, nil
+			}
+			if err != opentracing.ErrSpanContextNotFound && firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr == nil {
+			firstErr = opentracing.ErrSpanContextNotFound
+		}
+		return SpanContext{}, firstErr
+	}
 }
 
-var injecters = map[interface{}]Injecter{
-	opentracing.HTTPHeaders: textInjecter,
-	opentracing.TextMap:     textInjecter,
-	opentracing.Binary:      binaryInjecter,
+// defaultPropagation contains the formats that new Tracers start out
+// with.
+var defaultPropagation = newDefaultPropagation()
+
+func newDefaultPropagation() *Propagation {
+	p := &Propagation{}
+	p.RegisterExtracter(opentracing.HTTPHeaders, textExtracter)
+	p.RegisterExtracter(opentracing.TextMap, textExtracter)
+	p.RegisterExtracter(opentracing.Binary, binaryExtracter)
+	p.RegisterExtracter(FormatTraceContext, TraceContextExtracter)
+	p.RegisterExtracter(FormatB3, B3Extracter)
+	p.RegisterExtracter(FormatB3Single, B3Extracter)
+	p.RegisterExtracter(FormatAny, AnyExtracter)
+
+	p.RegisterInjecter(opentracing.HTTPHeaders, textInjecter)
+	p.RegisterInjecter(opentracing.TextMap, textInjecter)
+	p.RegisterInjecter(opentracing.Binary, binaryInjecter)
+	p.RegisterInjecter(FormatTraceContext, TraceContextInjecter)
+	p.RegisterInjecter(FormatB3, B3Injecter)
+	p.RegisterInjecter(FormatB3Single, B3SingleInjecter)
+	return p
 }
 
-// RegisterExtracter registers an Extracter.
+// RegisterExtracter registers an Extracter with the default formats.
+// It only affects Tracers created afterwards. Use Tracer.Propagation
+// to register formats with an existing Tracer.
 func RegisterExtracter(format interface{}, extracter Extracter) {
-	extracters[format] = extracter
+	defaultPropagation.RegisterExtracter(format, extracter)
 }
 
-// RegisterInjecter registers an Injecter.
+// RegisterInjecter registers an Injecter with the default formats.
+// It only affects Tracers created afterwards. Use Tracer.Propagation
+// to register formats with an existing Tracer.
 func RegisterInjecter(format interface{}, injecter Injecter) {
-	injecters[format] = injecter
+	defaultPropagation.RegisterInjecter(format, injecter)
+}
+
+// Propagation is a registry of propagation formats and their
+// Injecters and Extracters. It is safe for concurrent use. The zero
+// value is an empty registry.
+type Propagation struct {
+	mu         sync.RWMutex
+	formats    []interface{}
+	extracters map[interface{}]Extracter
+	injecters  map[interface{}]Injecter
+}
+
+// NewPropagation returns a registry that contains the default
+// formats, including those registered with RegisterExtracter and
+// RegisterInjecter.
+func NewPropagation() *Propagation {
+	return defaultPropagation.Clone()
+}
+
+// Clone returns a copy of the registry.
+func (p *Propagation) Clone() *Propagation {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	c := &Propagation{
+		formats:    append([]interface{}(nil), p.formats...),
+		extracters: make(map[interface{}]Extracter, len(p.extracters)),
+		injecters:  make(map[interface{}]Injecter, len(p.injecters)),
+	}
+	for format, extracter := range p.extracters {
+		c.extracters[format] = extracter
+	}
+	for format, injecter := range p.injecters {
+		c.injecters[format] = injecter
+	}
+	return c
+}
+
+// RegisterExtracter registers an Extracter, replacing any existing
+// Extracter for the same format.
+func (p *Propagation) RegisterExtracter(format interface{}, extracter Extracter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.extracters == nil {
+		p.extracters = map[interface{}]Extracter{}
+	}
+	p.addFormat(format)
+	p.extracters[format] = extracter
+}
+
+// RegisterInjecter registers an Injecter, replacing any existing
+// Injecter for the same format.
+func (p *Propagation) RegisterInjecter(format interface{}, injecter Injecter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.injecters == nil {
+		p.injecters = map[interface{}]Injecter{}
+	}
+	p.addFormat(format)
+	p.injecters[format] = injecter
+}
+
+func (p *Propagation) addFormat(format interface{}) {
+	_, ok1 := p.extracters[format]
+	_, ok2 := p.injecters[format]
+	if !ok1 && !ok2 {
+		p.formats = append(p.formats, format)
+	}
+}
+
+// Extracter returns the Extracter of a format.
+func (p *Propagation) Extracter(format interface{}) (Extracter, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	extracter, ok := p.extracters[format]
+	return extracter, ok
+}
+
+// Injecter returns the Injecter of a format.
+func (p *Propagation) Injecter(format interface{}) (Injecter, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	injecter, ok := p.injecters[format]
+	return injecter, ok
+}
+
+// Formats returns all formats that have an Injecter, an Extracter or
+// both, in the order they were first registered.
+func (p *Propagation) Formats() []interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]interface{}(nil), p.formats...)
 }
 
 // SpanContext contains the parts of a span that will be sent to
 // downstream services.
 type SpanContext struct {
-	TraceID  uint64            `json:"trace_id"`
-	ParentID uint64            `json:"parent_id"`
-	SpanID   uint64            `json:"span_id"`
-	Flags    uint64            `json:"flags"`
-	Baggage  map[string]string `json:"baggage"`
+	TraceID uint64 `json:"trace_id"`
+	// The high 64 bits of 128-bit trace IDs. Zero for 64-bit trace
+	// IDs.
+	TraceIDHigh uint64            `json:"trace_id_high"`
+	ParentID    uint64            `json:"parent_id"`
+	SpanID      uint64            `json:"span_id"`
+	Flags       uint64            `json:"flags"`
+	Baggage     map[string]string `json:"baggage"`
+	// Vendor-specific trace state received via the W3C tracestate
+	// header, passed through unmodified.
+	TraceState string `json:"trace_state"`
+}
+
+// A SpanContextConverter is a span context of a different tracer,
+// or a wrapper around a SpanContext, that can be converted to a
+// SpanContext. Such span contexts may be used as references when
+// starting spans; the new span will become part of the converted
+// context's trace.
+type SpanContextConverter interface {
+	TracerSpanContext() SpanContext
 }
 
 // ForeachBaggageItem implements the opentracing.Tracer interface.
@@ -61,7 +232,7 @@ func textInjecter(sm SpanContext, carrier interface{}) error {
 	if !ok {
 		return opentracing.ErrInvalidCarrier
 	}
-	w.Set("tracer-traceid", idToHex(sm.TraceID))
+	w.Set("tracer-traceid", FormatTraceID(sm.TraceIDHigh, sm.TraceID))
 	w.Set("tracer-spanid", idToHex(sm.SpanID))
 	w.Set("tracer-parentspanid", idToHex(sm.ParentID))
 	w.Set("tracer-flags", strconv.FormatUint(sm.Flags, 10))
@@ -81,7 +252,7 @@ func textExtracter(carrier interface{}) (SpanContext, error) {
 		lower := strings.ToLower(key)
 		switch lower {
 		case "tracer-traceid":
-			ctx.TraceID = idFromHex(val)
+			ctx.TraceIDHigh, ctx.TraceID, _ = ParseTraceID(val)
 		case "tracer-spanid":
 			ctx.SpanID = idFromHex(val)
 		case "tracer-parentspanid":
@@ -96,22 +267,31 @@ func textExtracter(carrier interface{}) (SpanContext, error) {
 		}
 		return nil
 	})
-	if ctx.TraceID == 0 {
+	if ctx.TraceID == 0 && ctx.TraceIDHigh == 0 {
 		return SpanContext{}, opentracing.ErrSpanContextNotFound
 	}
 	return ctx, err
 }
 
+// binaryFlagTraceIDHigh is set in the flags of the binary format if
+// the high bits of the trace ID follow the baggage. This keeps the
+// format of 64-bit trace IDs compatible with older versions.
+const binaryFlagTraceIDHigh = 1 << 63
+
 func binaryInjecter(sm SpanContext, carrier interface{}) error {
 	w, ok := carrier.(io.Writer)
 	if !ok {
 		return opentracing.ErrInvalidCarrier
 	}
+	flags := sm.Flags &^ binaryFlagTraceIDHigh
+	if sm.TraceIDHigh != 0 {
+		flags |= binaryFlagTraceIDHigh
+	}
 	b := make([]byte, 8*5)
 	binary.BigEndian.PutUint64(b, sm.TraceID)
 	binary.BigEndian.PutUint64(b[8:], sm.SpanID)
 	binary.BigEndian.PutUint64(b[16:], sm.ParentID)
-	binary.BigEndian.PutUint64(b[24:], sm.Flags)
+	binary.BigEndian.PutUint64(b[24:], flags)
 	binary.BigEndian.PutUint64(b[32:], uint64(len(sm.Baggage)))
 	for k, v := range sm.Baggage {
 		b2 := make([]byte, 16+len(k)+len(v))
@@ -121,6 +301,11 @@ func binaryInjecter(sm SpanContext, carrier interface{}) error {
 		copy(b2[16+len(k):], v)
 		b = append(b, b2...)
 	}
+	if sm.TraceIDHigh != 0 {
+		b2 := make([]byte, 8)
+		binary.BigEndian.PutUint64(b2, sm.TraceIDHigh)
+		b = append(b, b2...)
+	}
 	_, err := w.Write(b)
 	return err
 }
@@ -169,5 +354,17 @@ func binaryExtracter(carrier interface{}) (SpanContext, error) {
 		ctx.Baggage[string(b2[:kl])] = string(b2[kl:])
 	}
 
+	if ctx.Flags&binaryFlagTraceIDHigh != 0 {
+		ctx.Flags &^= binaryFlagTraceIDHigh
+		b = make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				return SpanContext{}, opentracing.ErrSpanContextNotFound
+			}
+			return SpanContext{}, err
+		}
+		ctx.TraceIDHigh = binary.BigEndian.Uint64(b)
+	}
+
 	return ctx, nil
 }
@@ -2,6 +2,8 @@ package tracer
 
 import (
 	"bytes"
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/opentracing/opentracing-go"
@@ -81,3 +83,211 @@ func TestBinary(t *testing.T) {
 			sp.raw.TraceID, sp.raw.ParentID, sp.raw.SpanID, sp.raw.Flags, sp.raw.Baggage)
 	}
 }
+
+func TestTraceIDHigh(t *testing.T) {
+	sm := SpanContext{
+		TraceID:     3,
+		TraceIDHigh: 4,
+		SpanID:      1,
+		Flags:       FlagSampled,
+		Baggage:     map[string]string{"k1": "v1"},
+	}
+
+	carrier := opentracing.TextMapCarrier{}
+	if err := textInjecter(sm, carrier); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if got, want := carrier["tracer-traceid"], "00000000000000040000000000000003"; got != want {
+		t.Errorf("got trace ID %q, want %q", got, want)
+	}
+	context, err := textExtracter(carrier)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if context.TraceID != sm.TraceID || context.TraceIDHigh != sm.TraceIDHigh {
+		t.Errorf("got trace ID (%d, %d), want (%d, %d)",
+			context.TraceIDHigh, context.TraceID, sm.TraceIDHigh, sm.TraceID)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := binaryInjecter(sm, buf); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	context, err = binaryExtracter(buf)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if context.TraceID != sm.TraceID ||
+		context.TraceIDHigh != sm.TraceIDHigh ||
+		context.Flags != sm.Flags ||
+		context.Baggage["k1"] != "v1" {
+
+		t.Errorf("got (%d, %d, %d, %v), want (%d, %d, %d, %v)",
+			context.TraceIDHigh, context.TraceID, context.Flags, context.Baggage,
+			sm.TraceIDHigh, sm.TraceID, sm.Flags, sm.Baggage)
+	}
+}
+
+func TestTraceIDLowZero(t *testing.T) {
+	// 128-bit trace IDs whose low 64 bits are zero are valid.
+	sm := SpanContext{TraceIDHigh: 4, SpanID: 1, Flags: FlagSampled}
+	carrier := opentracing.TextMapCarrier{}
+	if err := textInjecter(sm, carrier); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	context, err := textExtracter(carrier)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if context.TraceIDHigh != 4 || context.TraceID != 0 {
+		t.Errorf("got trace ID (%d, %d), want (4, 0)", context.TraceIDHigh, context.TraceID)
+	}
+
+	tr := NewTracer("", &recordingStorer{}, RandomID{})
+	child := tr.StartSpan("child", opentracing.ChildOf(context)).(*Span)
+	if child.raw.TraceIDHigh != 4 || child.raw.TraceID != 0 || child.raw.ParentID != 1 {
+		t.Errorf("got (%d, %d, %d), want (4, 0, 1)",
+			child.raw.TraceIDHigh, child.raw.TraceID, child.raw.ParentID)
+	}
+}
+
+func TestBinary64BitCompatibility(t *testing.T) {
+	// A span context as encoded by versions without support for
+	// 128-bit trace IDs.
+	b := []byte{
+		0, 0, 0, 0, 0, 0, 0, 3,
+		0, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0, 0, 0, 0, 0, 2,
+		0, 0, 0, 0, 0, 0, 0, FlagSampled,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	context, err := binaryExtracter(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if context.TraceID != 3 || context.TraceIDHigh != 0 || context.Flags != FlagSampled {
+		t.Errorf("got (%d, %d, %d), want (0, 3, %d)",
+			context.TraceIDHigh, context.TraceID, context.Flags, FlagSampled)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := binaryInjecter(SpanContext{TraceID: 3, SpanID: 1, ParentID: 2, Flags: FlagSampled}, buf); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if !bytes.Equal(buf.Bytes(), b) {
+		t.Errorf("got %v, want %v", buf.Bytes(), b)
+	}
+}
+
+func TestParseTraceID(t *testing.T) {
+	tests := []struct {
+		in   string
+		high uint64
+		low  uint64
+		ok   bool
+	}{
+		{"0000000000000003", 0, 3, true},
+		{"3", 0, 3, true},
+		{"00000000000000040000000000000003", 4, 3, true},
+		{"40000000000000003", 4, 3, true},
+		{"", 0, 0, false},
+		{"000000000000000400000000000000030", 0, 0, false},
+		{"xyz", 0, 0, false},
+		{"xyz0000000000000003", 0, 0, false},
+		{"+3", 0, 0, false},
+	}
+	for _, tt := range tests {
+		high, low, err := ParseTraceID(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: got error %v, want success %t", tt.in, err, tt.ok)
+			continue
+		}
+		if high != tt.high || low != tt.low {
+			t.Errorf("%q: got (%d, %d), want (%d, %d)", tt.in, high, low, tt.high, tt.low)
+		}
+		if tt.ok {
+			if s := FormatTraceID(high, low); len(s) != 16 && len(s) != 32 {
+				t.Errorf("%q: formatted as %q", tt.in, s)
+			}
+		}
+	}
+}
+
+func TestPropagationPerTracer(t *testing.T) {
+	t1 := NewTracer("", &recordingStorer{}, RandomID{})
+	t2 := NewTracer("", &recordingStorer{}, RandomID{})
+	t1.Propagation.RegisterInjecter(opentracing.HTTPHeaders, TraceContextInjecter)
+
+	sp := t1.StartSpan("")
+	c1 := opentracing.TextMapCarrier{}
+	if err := t1.Inject(sp.Context(), opentracing.HTTPHeaders, c1); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if _, ok := c1["traceparent"]; !ok {
+		t.Errorf("expected t1 to inject traceparent, got %v", c1)
+	}
+	c2 := opentracing.TextMapCarrier{}
+	if err := t2.Inject(sp.Context(), opentracing.HTTPHeaders, c2); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if _, ok := c2["tracer-traceid"]; !ok {
+		t.Errorf("expected t2 to inject tracer-traceid, got %v", c2)
+	}
+
+	t3 := NewTracer("", &recordingStorer{}, RandomID{})
+	t3.Propagation = &Propagation{}
+	if err := t3.Inject(sp.Context(), opentracing.HTTPHeaders, c2); err != opentracing.ErrUnsupportedFormat {
+		t.Errorf("got error %v, want %v", err, opentracing.ErrUnsupportedFormat)
+	}
+	if formats := t3.Formats(); len(formats) != 0 {
+		t.Errorf("got formats %v, want none", formats)
+	}
+}
+
+func TestPropagationFormats(t *testing.T) {
+	p := &Propagation{}
+	p.RegisterExtracter(FormatAny, AnyExtracter)
+	p.RegisterInjecter(opentracing.TextMap, textInjecter)
+	p.RegisterExtracter(opentracing.TextMap, textExtracter)
+	formats := p.Formats()
+	if len(formats) != 2 || formats[0] != FormatAny || formats[1] != opentracing.TextMap {
+		t.Errorf("got formats %v, want [%v %v]", formats, FormatAny, opentracing.TextMap)
+	}
+
+	c := p.Clone()
+	c.RegisterInjecter(opentracing.Binary, binaryInjecter)
+	if _, ok := p.Injecter(opentracing.Binary); ok {
+		t.Error("registering with a clone modified the original")
+	}
+	if len(c.Formats()) != 3 {
+		t.Errorf("got formats %v, want 3 formats", c.Formats())
+	}
+}
+
+func TestPropagationConcurrent(t *testing.T) {
+	tr := NewTracer("", &recordingStorer{}, RandomID{})
+	sp := tr.StartSpan("")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tr.Propagation.RegisterInjecter(Format(fmt.Sprint(i, j)), textInjecter)
+				_ = tr.Formats()
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if err := tr.Inject(sp.Context(), opentracing.TextMap, opentracing.TextMapCarrier{}); err != nil {
+					t.Error("unexpected error: ", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(tr.Formats()); n != len(NewPropagation().Formats())+400 {
+		t.Errorf("got %d formats, want %d", n, len(NewPropagation().Formats())+400)
+	}
+}
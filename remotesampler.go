@@ -0,0 +1,232 @@
+package tracer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tracer/tracer/pb"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// RemoteSampler is a sampler that periodically fetches sampling
+// strategies from a Tracer server.
+type RemoteSampler struct {
+	client       pb.SamplingClient
+	conn         io.Closer
+	serviceName  string
+	pollInterval time.Duration
+	staleAfter   time.Duration
+	fallback     Sampler
+	logger       Logger
+	nowFn        func() time.Time
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+
+	mu sync.RWMutex
+	// The last valid response received from the server, nil if
+	// there is none or it has gone stale.
+	last *pb.SamplingStrategyResponse
+	// When last was received.
+	fetched time.Time
+	sampler Sampler
+}
+
+// RemoteSamplerOptions are options for the remote sampler.
+type RemoteSamplerOptions struct {
+	// The name of the service to fetch strategies for.
+	ServiceName string
+	// How often to fetch strategies. Zero means one minute.
+	PollInterval time.Duration
+	// How long to keep using the last fetched strategies while the
+	// server can't be reached, before switching to Fallback. Zero
+	// means five times PollInterval.
+	StaleAfter time.Duration
+	// The sampler to use before strategies have been fetched, when
+	// the server can't be reached, and for operations the server has
+	// no strategy for. If nil, one in a thousand traces will be
+	// sampled.
+	Fallback Sampler
+	// Where to log errors. If nil, the default logger will be used.
+	Logger Logger
+}
+
+// NewRemoteSampler returns a sampler that fetches sampling strategies
+// for a service from a server via gRPC and samples according to
+// them. Strategies are fetched in the background and replace the
+// previous ones when they change. Call Close to stop fetching
+// strategies.
+func NewRemoteSampler(address string, opts RemoteSamplerOptions, dialOpts ...grpc.DialOption) (*RemoteSampler, error) {
+	conn, err := grpc.Dial(address, dialOpts...)
+	if err != nil {
+		return nil, err
+	}
+	r := newRemoteSampler(pb.NewSamplingClient(conn), opts)
+	r.conn = conn
+	go r.loop()
+	return r, nil
+}
+
+func newRemoteSampler(client pb.SamplingClient, opts RemoteSamplerOptions) *RemoteSampler {
+	if opts.PollInterval == 0 {
+		opts.PollInterval = time.Minute
+	}
+	if opts.StaleAfter == 0 {
+		opts.StaleAfter = 5 * opts.PollInterval
+	}
+	if opts.Fallback == nil {
+		opts.Fallback = NewTraceIDSampler(0.001)
+	}
+	if opts.Logger == nil {
+		opts.Logger = defaultLogger{}
+	}
+	return &RemoteSampler{
+		client:       client,
+		serviceName:  opts.ServiceName,
+		pollInterval: opts.PollInterval,
+		staleAfter:   opts.StaleAfter,
+		fallback:     opts.Fallback,
+		logger:       opts.Logger,
+		nowFn:        time.Now,
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		sampler:      opts.Fallback,
+	}
+}
+
+func (r *RemoteSampler) loop() {
+	defer close(r.stopped)
+	t := time.NewTicker(r.pollInterval)
+	defer t.Stop()
+	for {
+		if err := r.poll(); err != nil {
+			r.logger.Printf("couldn't fetch sampling strategies: %s", err)
+		}
+		select {
+		case <-t.C:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// Close stops fetching strategies and closes the connection to the
+// server. The sampler keeps using the strategies it has.
+func (r *RemoteSampler) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.done)
+		if r.conn != nil {
+			// Closing the connection aborts a poll in progress.
+			err = r.conn.Close()
+			<-r.stopped
+		}
+	})
+	return err
+}
+
+// poll fetches the current strategies. If that fails, the last
+// strategies will be used until they are older than staleAfter, and
+// the fallback sampler afterwards.
+func (r *RemoteSampler) poll() error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.pollInterval)
+	defer cancel()
+	resp, err := r.client.GetSamplingStrategy(ctx, &pb.SamplingStrategyRequest{ServiceName: r.serviceName})
+	if err != nil {
+		r.expire()
+		return err
+	}
+	r.mu.RLock()
+	unchanged := r.last != nil && proto.Equal(r.last, resp)
+	r.mu.RUnlock()
+	if unchanged {
+		// Keep the existing samplers so that rate limiters retain
+		// their state.
+		r.mu.Lock()
+		r.fetched = r.nowFn()
+		r.mu.Unlock()
+		return nil
+	}
+	s, err := r.samplerFromResponse(resp)
+	if err != nil {
+		r.expire()
+		return err
+	}
+	r.mu.Lock()
+	r.last = resp
+	r.fetched = r.nowFn()
+	r.sampler = s
+	r.mu.Unlock()
+	return nil
+}
+
+// expire switches to the fallback sampler if the last strategies
+// have gone stale.
+func (r *RemoteSampler) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last != nil && r.nowFn().Sub(r.fetched) < r.staleAfter {
+		return
+	}
+	r.last = nil
+	r.sampler = r.fallback
+}
+
+func (r *RemoteSampler) samplerFromResponse(resp *pb.SamplingStrategyResponse) (Sampler, error) {
+	def := r.fallback
+	if resp.DefaultStrategy != nil {
+		var err error
+		def, err = samplerFromStrategy(resp.DefaultStrategy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(resp.Operations) == 0 {
+		return def, nil
+	}
+	ops := map[string]Sampler{}
+	for _, op := range resp.Operations {
+		if op.Strategy == nil {
+			continue
+		}
+		s, err := samplerFromStrategy(op.Strategy)
+		if err != nil {
+			return nil, err
+		}
+		ops[op.OperationName] = s
+	}
+	return NewOperationSampler(def, ops), nil
+}
+
+func samplerFromStrategy(st *pb.SamplingStrategy) (Sampler, error) {
+	switch st.Type {
+	case pb.SamplingStrategy_PROBABILISTIC:
+		return NewTraceIDSampler(st.Param), nil
+	case pb.SamplingStrategy_RATE_LIMITING:
+		return NewRateSampler(int(st.Param)), nil
+	default:
+		return nil, fmt.Errorf("unsupported sampling strategy %s", st.Type)
+	}
+}
+
+func (r *RemoteSampler) current() Sampler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sampler
+}
+
+// Sample implements the Sampler interface.
+func (r *RemoteSampler) Sample(id uint64) bool {
+	return r.current().Sample(id)
+}
+
+// SampleSpan implements the SpanSampler interface.
+func (r *RemoteSampler) SampleSpan(params SamplingParameters) SamplingDecision {
+	return sampleSpan(r.current(), params)
+}
@@ -0,0 +1,191 @@
+package tracer
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tracer/tracer/pb"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+type fakeSamplingClient struct {
+	mu      sync.Mutex
+	resp    *pb.SamplingStrategyResponse
+	err     error
+	service string
+	polls   int
+}
+
+func (c *fakeSamplingClient) GetSamplingStrategy(ctx context.Context, in *pb.SamplingStrategyRequest, opts ...grpc.CallOption) (*pb.SamplingStrategyResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.service = in.ServiceName
+	c.polls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.resp, nil
+}
+
+func TestRemoteSampler(t *testing.T) {
+	client := &fakeSamplingClient{err: errors.New("unreachable")}
+	fallback := &countingSampler{decision: true}
+	r := newRemoteSampler(client, RemoteSamplerOptions{
+		ServiceName: "frontend",
+		StaleAfter:  time.Hour,
+		Fallback:    fallback,
+		Logger:      &recordingLogger{},
+	})
+	now := time.Unix(0, 0)
+	r.nowFn = func() time.Time { return now }
+
+	if !r.Sample(1) || fallback.n != 1 {
+		t.Fatal("expected fallback sampler to be used before the first poll")
+	}
+	if err := r.poll(); err == nil {
+		t.Fatal("expected error from unreachable server")
+	}
+	if client.service != "frontend" {
+		t.Errorf("requested strategies for %q, expected frontend", client.service)
+	}
+
+	client.err = nil
+	client.resp = &pb.SamplingStrategyResponse{
+		DefaultStrategy: &pb.SamplingStrategy{Type: pb.SamplingStrategy_PROBABILISTIC, Param: 0},
+		Operations: []*pb.OperationSamplingStrategy{{
+			OperationName: "checkout",
+			Strategy:      &pb.SamplingStrategy{Type: pb.SamplingStrategy_PROBABILISTIC, Param: 1},
+		}},
+	}
+	if err := r.poll(); err != nil {
+		t.Fatal(err)
+	}
+	d := r.SampleSpan(SamplingParameters{TraceID: 1, OperationName: "checkout"})
+	if !d.Sampled {
+		t.Error("expected checkout to be sampled")
+	}
+	d = r.SampleSpan(SamplingParameters{TraceID: 1, OperationName: "index"})
+	if d.Sampled {
+		t.Error("expected index not to be sampled")
+	}
+	if fallback.n != 1 {
+		t.Errorf("fallback was called %d times, expected 1", fallback.n)
+	}
+
+	// unchanged strategies keep the existing sampler
+	last := r.last
+	if err := r.poll(); err != nil {
+		t.Fatal(err)
+	}
+	if r.last != last {
+		t.Error("sampler was replaced even though strategies didn't change")
+	}
+
+	// The last strategies are used while the server is unreachable,
+	// until they go stale.
+	client.err = errors.New("unreachable")
+	now = now.Add(59 * time.Minute)
+	if err := r.poll(); err == nil {
+		t.Fatal("expected error from unreachable server")
+	}
+	d = r.SampleSpan(SamplingParameters{TraceID: 1, OperationName: "checkout"})
+	if !d.Sampled || fallback.n != 1 {
+		t.Error("expected last strategies to be used when the server is unreachable")
+	}
+	now = now.Add(time.Minute)
+	if err := r.poll(); err == nil {
+		t.Fatal("expected error from unreachable server")
+	}
+	if !r.Sample(1) || fallback.n != 2 {
+		t.Error("expected fallback sampler to be used once the strategies are stale")
+	}
+}
+
+func TestRemoteSamplerInvalidStrategy(t *testing.T) {
+	client := &fakeSamplingClient{resp: &pb.SamplingStrategyResponse{
+		DefaultStrategy: &pb.SamplingStrategy{Type: pb.SamplingStrategy_PROBABILISTIC, Param: 1},
+	}}
+	fallback := &countingSampler{}
+	r := newRemoteSampler(client, RemoteSamplerOptions{Fallback: fallback})
+	if err := r.poll(); err != nil {
+		t.Fatal(err)
+	}
+	client.resp = &pb.SamplingStrategyResponse{
+		DefaultStrategy: &pb.SamplingStrategy{Type: pb.SamplingStrategy_Type(42), Param: 1},
+	}
+	if err := r.poll(); err == nil {
+		t.Fatal("expected error for unsupported strategy")
+	}
+	if !r.Sample(1) || fallback.n != 0 {
+		t.Error("expected last valid strategies to be kept")
+	}
+}
+
+type closeRecorder struct {
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestRemoteSamplerClose(t *testing.T) {
+	client := &fakeSamplingClient{resp: &pb.SamplingStrategyResponse{}}
+	conn := &closeRecorder{}
+	r := newRemoteSampler(client, RemoteSamplerOptions{
+		PollInterval: time.Millisecond,
+		Fallback:     &countingSampler{},
+	})
+	r.conn = conn
+	go r.loop()
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !conn.closed {
+		t.Error("connection wasn't closed")
+	}
+	select {
+	case <-r.stopped:
+	default:
+		t.Fatal("poll loop didn't stop")
+	}
+	client.mu.Lock()
+	polls := client.polls
+	client.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.polls != polls {
+		t.Errorf("got %d polls after closing, expected %d", client.polls, polls)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoteSamplerNoDefault(t *testing.T) {
+	client := &fakeSamplingClient{resp: &pb.SamplingStrategyResponse{
+		Operations: []*pb.OperationSamplingStrategy{{
+			OperationName: "checkout",
+			Strategy:      &pb.SamplingStrategy{Type: pb.SamplingStrategy_RATE_LIMITING, Param: 1},
+		}},
+	}}
+	fallback := &countingSampler{}
+	r := newRemoteSampler(client, RemoteSamplerOptions{Fallback: fallback})
+	if err := r.poll(); err != nil {
+		t.Fatal(err)
+	}
+	d := r.SampleSpan(SamplingParameters{OperationName: "checkout"})
+	if !d.Sampled || d.Tags[SamplerTypeTag] != "rate" {
+		t.Errorf("got decision %v, expected rate sampler to sample", d)
+	}
+	r.SampleSpan(SamplingParameters{OperationName: "index"})
+	if fallback.n != 1 {
+		t.Errorf("fallback was called %d times, expected 1", fallback.n)
+	}
+}
@@ -0,0 +1,63 @@
+package tracer
+
+import (
+	"net"
+	"os"
+)
+
+// Well-known resource attributes. Transports and storages may give
+// them special treatment, e.g. as the endpoint of Zipkin spans.
+const (
+	// The hostname of the process.
+	ResourceHostname = "hostname"
+	// The IPv4 or IPv6 address of the process.
+	ResourceIP = "ip"
+	// The port the process listens on, as a number.
+	ResourcePort = "port"
+	// The process ID, as a number.
+	ResourcePID = "pid"
+	// The version of the service.
+	ResourceVersion = "version"
+	// The environment, such as production or staging.
+	ResourceEnvironment = "environment"
+)
+
+// DefaultResource returns the hostname, IP address and PID of the
+// current process as resource attributes. The IP address is the
+// first non-loopback address of the host. Attributes that cannot be
+// determined are omitted.
+func DefaultResource() map[string]interface{} {
+	res := map[string]interface{}{
+		ResourcePID: os.Getpid(),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		res[ResourceHostname] = hostname
+	}
+	if ip := hostIP(); ip != "" {
+		res[ResourceIP] = ip
+	}
+	return res
+}
+
+// hostIP returns the first non-loopback IP address of the host,
+// preferring IPv4 addresses.
+func hostIP() string {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	var ipv6 string
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP.String()
+		}
+		if ipv6 == "" {
+			ipv6 = ipnet.IP.String()
+		}
+	}
+	return ipv6
+}
@@ -4,14 +4,80 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/opentracing/opentracing-go"
 )
 
 // A Sampler determines whether a span should be sampled or not by
 // returning true or false.
+//
+// Samplers that need more information about the span, or that want to
+// record why a span was sampled, should additionally implement
+// SpanSampler.
 type Sampler interface {
 	Sample(id uint64) bool
 }
 
+// A SpanSampler is a sampler that decides based on information about
+// the span that is being started. The tracer prefers SampleSpan over
+// Sample if a Sampler implements both.
+type SpanSampler interface {
+	SampleSpan(params SamplingParameters) SamplingDecision
+}
+
+// SamplingParameters describe a root span that is being started.
+type SamplingParameters struct {
+	TraceID       uint64
+	TraceIDHigh   uint64
+	SpanID        uint64
+	ServiceName   string
+	OperationName string
+	// The tags the span was started with. They must not be
+	// modified.
+	Tags opentracing.Tags
+}
+
+// A SamplingDecision is the result of a SpanSampler.
+type SamplingDecision struct {
+	Sampled bool
+	// Tags describing why the span was or wasn't sampled. They will
+	// be added to sampled root spans only; their children inherit
+	// the decision without the tags.
+	Tags map[string]interface{}
+}
+
+// Tags commonly used in sampling decisions.
+const (
+	// The kind of sampler that made a decision.
+	SamplerTypeTag = "sampler.type"
+	// The parameter of the sampler that made a decision, such as a
+	// probability or rate.
+	SamplerParamTag = "sampler.param"
+)
+
+// sampleSpan asks a Sampler for a decision, using SampleSpan if
+// possible. Samplers that only implement Sample are passed the trace
+// ID, so that their decisions are consistent across the trace.
+func sampleSpan(s Sampler, params SamplingParameters) SamplingDecision {
+	if ss, ok := s.(SpanSampler); ok {
+		return ss.SampleSpan(params)
+	}
+	return SamplingDecision{Sampled: s.Sample(params.TraceID)}
+}
+
+// SamplerFunc allows using a function as a Sampler and SpanSampler.
+type SamplerFunc func(params SamplingParameters) SamplingDecision
+
+// Sample implements the Sampler interface.
+func (fn SamplerFunc) Sample(id uint64) bool {
+	return fn(SamplingParameters{TraceID: id, SpanID: id}).Sampled
+}
+
+// SampleSpan implements the SpanSampler interface.
+func (fn SamplerFunc) SampleSpan(params SamplingParameters) SamplingDecision {
+	return fn(params)
+}
+
 type constSampler struct {
 	decision bool
 }
@@ -27,35 +93,123 @@ func (c constSampler) Sample(uint64) bool {
 	return c.decision
 }
 
+// SampleSpan implements the SpanSampler interface. Only decisions
+// that start a trace carry tags.
+func (c constSampler) SampleSpan(SamplingParameters) SamplingDecision {
+	if !c.decision {
+		return SamplingDecision{}
+	}
+	return SamplingDecision{
+		Sampled: true,
+		Tags:    map[string]interface{}{SamplerTypeTag: "const", SamplerParamTag: true},
+	}
+}
+
 type probabilisticSampler struct {
 	chance float64
-	rng    *rand.Rand
+
+	mu  sync.Mutex
+	rng *rand.Rand
 }
 
 // NewProbabilisticSampler returns a sampler that samples spans with a
 // certain chance, which should be in [0, 1].
+//
+// Each instance makes its own random decisions. Use NewTraceIDSampler
+// for decisions that are consistent across services.
 func NewProbabilisticSampler(chance float64) Sampler {
-	return probabilisticSampler{chance, rand.New(rand.NewSource(time.Now().UnixNano()))}
+	return &probabilisticSampler{chance: chance, rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
 }
 
 // Sample implements the Sampler interface.
-func (p probabilisticSampler) Sample(uint64) bool {
+func (p *probabilisticSampler) Sample(uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return p.rng.Float64() < p.chance
 }
 
+// SampleSpan implements the SpanSampler interface.
+func (p *probabilisticSampler) SampleSpan(params SamplingParameters) SamplingDecision {
+	return SamplingDecision{
+		Sampled: p.Sample(params.SpanID),
+		Tags:    map[string]interface{}{SamplerTypeTag: "probabilistic", SamplerParamTag: p.chance},
+	}
+}
+
+type traceIDSampler struct {
+	rate      float64
+	threshold uint64
+	all       bool
+}
+
+// NewTraceIDSampler returns a sampler that samples a fraction of all
+// traces, given by rate, which should be in [0, 1]. Unlike
+// NewProbabilisticSampler, it decides by hashing the trace ID, so all
+// trace ID samplers with the same rate make the same decision for
+// the same trace, regardless of the process they run in. Samplers
+// with a higher rate sample a superset of the traces sampled by
+// samplers with a lower rate.
+//
+// When used via the Sampler interface, the ID passed to Sample is
+// hashed instead. The tracer passes the low 64 bits of the trace ID.
+func NewTraceIDSampler(rate float64) Sampler {
+	s := traceIDSampler{rate: rate}
+	switch {
+	case rate >= 1:
+		s.all = true
+	case rate > 0:
+		s.threshold = uint64(rate * (1 << 64))
+	}
+	return s
+}
+
+// Sample implements the Sampler interface.
+func (s traceIDSampler) Sample(id uint64) bool {
+	return s.all || hashID(id) < s.threshold
+}
+
+// SampleSpan implements the SpanSampler interface.
+func (s traceIDSampler) SampleSpan(params SamplingParameters) SamplingDecision {
+	return SamplingDecision{
+		Sampled: s.Sample(params.TraceID),
+		Tags:    map[string]interface{}{SamplerTypeTag: "traceid", SamplerParamTag: s.rate},
+	}
+}
+
+// hashID mixes the bits of an ID, so that trace ID samplers work well
+// with non-random IDs, e.g. sequential ones. It is the finalizer of
+// SplitMix64 and must never change, or different versions of the
+// tracer would make different sampling decisions.
+func hashID(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
 type rateLimiter struct {
 	mu     sync.Mutex
 	rate   int
+	per    time.Duration
 	tokens int
 	t      time.Time
 	nowFn  func() time.Time
 }
 
 func newRateLimiter(rate int) *rateLimiter {
+	return newRateLimiterPer(rate, time.Second)
+}
+
+// newRateLimiterPer returns a rate limiter that allows rate events
+// per interval.
+func newRateLimiterPer(rate int, per time.Duration) *rateLimiter {
 	return &rateLimiter{
 		rate:   rate,
+		per:    per,
 		tokens: rate,
-		t:      time.Now().Add(time.Second),
+		t:      time.Now().Add(per),
 		nowFn:  time.Now,
 	}
 }
@@ -63,7 +217,8 @@ func newRateLimiter(rate int) *rateLimiter {
 func (r *rateLimiter) Allow() bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	add := int((float64(r.nowFn().Sub(r.t).Nanoseconds()/int64(time.Millisecond)) / 1000) * float64(r.rate))
+	elapsed := r.nowFn().Sub(r.t) / time.Millisecond
+	add := int((float64(elapsed) / float64(r.per/time.Millisecond)) * float64(r.rate))
 	if add > 0 {
 		r.tokens = r.tokens + add
 		if r.tokens > r.rate {
@@ -88,6 +243,329 @@ func NewRateSampler(n int) Sampler {
 	return rateSampler{newRateLimiter(n)}
 }
 
+// Sample implements the Sampler interface.
 func (r rateSampler) Sample(uint64) bool {
 	return r.l.Allow()
 }
+
+// SampleSpan implements the SpanSampler interface.
+func (r rateSampler) SampleSpan(SamplingParameters) SamplingDecision {
+	return SamplingDecision{
+		Sampled: r.l.Allow(),
+		Tags:    map[string]interface{}{SamplerTypeTag: "rate", SamplerParamTag: r.l.rate},
+	}
+}
+
+// A SamplingRule pairs a condition with the sampler that decides
+// about spans matching it.
+type SamplingRule struct {
+	// Match reports whether the rule applies to a span. A nil Match
+	// matches all spans.
+	Match   func(params SamplingParameters) bool
+	Sampler Sampler
+}
+
+type firstMatchSampler struct {
+	rules    []SamplingRule
+	fallback Sampler
+}
+
+// NewFirstMatchSampler returns a sampler that delegates to the
+// sampler of the first matching rule, or to fallback if no rule
+// matches.
+func NewFirstMatchSampler(fallback Sampler, rules ...SamplingRule) Sampler {
+	return firstMatchSampler{rules, fallback}
+}
+
+// Sample implements the Sampler interface.
+func (f firstMatchSampler) Sample(id uint64) bool {
+	return f.SampleSpan(SamplingParameters{TraceID: id, SpanID: id}).Sampled
+}
+
+// SampleSpan implements the SpanSampler interface.
+func (f firstMatchSampler) SampleSpan(params SamplingParameters) SamplingDecision {
+	for _, rule := range f.rules {
+		if rule.Match == nil || rule.Match(params) {
+			return sampleSpan(rule.Sampler, params)
+		}
+	}
+	return sampleSpan(f.fallback, params)
+}
+
+// NewOperationSampler returns a sampler that uses a different sampler
+// for each operation name, and fallback for all other operations.
+func NewOperationSampler(fallback Sampler, operations map[string]Sampler) Sampler {
+	var rules []SamplingRule
+	for name, sampler := range operations {
+		name := name
+		rules = append(rules, SamplingRule{
+			Match: func(params SamplingParameters) bool {
+				return params.OperationName == name
+			},
+			Sampler: sampler,
+		})
+	}
+	return NewFirstMatchSampler(fallback, rules...)
+}
+
+type andSampler struct {
+	samplers []Sampler
+}
+
+// NewAndSampler returns a sampler that samples a span if all of the
+// samplers sample it. It stops asking samplers after the first
+// negative decision, which matters for stateful samplers such as the
+// rate sampler.
+func NewAndSampler(samplers ...Sampler) Sampler {
+	return andSampler{samplers}
+}
+
+// Sample implements the Sampler interface.
+func (a andSampler) Sample(id uint64) bool {
+	return a.SampleSpan(SamplingParameters{TraceID: id, SpanID: id}).Sampled
+}
+
+// SampleSpan implements the SpanSampler interface.
+func (a andSampler) SampleSpan(params SamplingParameters) SamplingDecision {
+	out := SamplingDecision{Sampled: true}
+	for _, s := range a.samplers {
+		d := sampleSpan(s, params)
+		if !d.Sampled {
+			return d
+		}
+		out.Tags = mergeTags(out.Tags, d.Tags)
+	}
+	return out
+}
+
+type orSampler struct {
+	samplers []Sampler
+}
+
+// NewOrSampler returns a sampler that samples a span if any of the
+// samplers samples it. It stops asking samplers after the first
+// positive decision.
+func NewOrSampler(samplers ...Sampler) Sampler {
+	return orSampler{samplers}
+}
+
+// Sample implements the Sampler interface.
+func (o orSampler) Sample(id uint64) bool {
+	return o.SampleSpan(SamplingParameters{TraceID: id, SpanID: id}).Sampled
+}
+
+// SampleSpan implements the SpanSampler interface.
+func (o orSampler) SampleSpan(params SamplingParameters) SamplingDecision {
+	var out SamplingDecision
+	for _, s := range o.samplers {
+		d := sampleSpan(s, params)
+		if d.Sampled {
+			return d
+		}
+		out.Tags = mergeTags(out.Tags, d.Tags)
+	}
+	return out
+}
+
+// mergeTags adds the tags of src to dst, allocating dst if
+// necessary, and returns dst. Tags that are already present in dst
+// take precedence.
+func mergeTags(dst, src map[string]interface{}) map[string]interface{} {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]interface{}, len(src))
+	}
+	for k, v := range src {
+		if _, ok := dst[k]; !ok {
+			dst[k] = v
+		}
+	}
+	return dst
+}
+
+// AdaptiveSamplerOptions are options for the adaptive sampler.
+type AdaptiveSamplerOptions struct {
+	// The number of traces per second that should be sampled for
+	// each operation.
+	TargetRate float64
+	// The number of traces per second that will be sampled for each
+	// operation regardless of its probability. This ensures that
+	// rare operations are sampled at all. It may be less than one.
+	// Zero disables the lower bound.
+	LowerBound float64
+	// The probability that new operations start out with. Zero
+	// means 1.
+	InitialProbability float64
+	// How often probabilities are adjusted. Zero means one minute.
+	AdjustInterval time.Duration
+	// The maximum number of operations to keep track of. Spans of
+	// additional operations will be sampled by Fallback. Zero means
+	// 1000.
+	MaxOperations int
+	// The sampler to use once MaxOperations has been reached. If
+	// nil, spans of all additional operations share a single
+	// default operation.
+	Fallback Sampler
+	// After how many adjust intervals without any spans an
+	// operation is forgotten, making room for new ones. Zero means
+	// 10.
+	MaxIdleIntervals int
+}
+
+type adaptiveOperation struct {
+	probability float64
+	// The number of spans seen since the last adjustment.
+	count int
+	// The number of adjust intervals without any spans.
+	idle       int
+	lowerBound *rateLimiter
+}
+
+type adaptiveSampler struct {
+	opts AdaptiveSamplerOptions
+
+	mu         sync.Mutex
+	operations map[string]*adaptiveOperation
+	// other is the default operation of spans that don't fit in
+	// operations if there is no fallback sampler.
+	other    *adaptiveOperation
+	adjusted time.Time
+	nowFn    func() time.Time
+}
+
+// NewAdaptiveSampler returns a sampler that keeps a separate sampling
+// probability for each operation and periodically adjusts it, so that
+// each operation is sampled at about opts.TargetRate traces per
+// second. Decisions are made by hashing the trace ID, like
+// NewTraceIDSampler does.
+//
+// The sampler adjusts probabilities while making decisions and does
+// not use a background goroutine.
+func NewAdaptiveSampler(opts AdaptiveSamplerOptions) Sampler {
+	if opts.InitialProbability == 0 {
+		opts.InitialProbability = 1
+	}
+	if opts.AdjustInterval == 0 {
+		opts.AdjustInterval = time.Minute
+	}
+	if opts.MaxOperations == 0 {
+		opts.MaxOperations = 1000
+	}
+	if opts.MaxIdleIntervals == 0 {
+		opts.MaxIdleIntervals = 10
+	}
+	a := &adaptiveSampler{
+		opts:       opts,
+		operations: map[string]*adaptiveOperation{},
+		adjusted:   time.Now(),
+		nowFn:      time.Now,
+	}
+	if opts.Fallback == nil {
+		a.other = a.newOperation()
+	}
+	return a
+}
+
+// Sample implements the Sampler interface. Because it has no access
+// to the operation name, all spans are treated as belonging to the
+// same operation.
+func (a *adaptiveSampler) Sample(id uint64) bool {
+	return a.SampleSpan(SamplingParameters{TraceID: id, SpanID: id}).Sampled
+}
+
+// SampleSpan implements the SpanSampler interface.
+func (a *adaptiveSampler) SampleSpan(params SamplingParameters) SamplingDecision {
+	a.mu.Lock()
+	now := a.nowFn()
+	if elapsed := now.Sub(a.adjusted); elapsed >= a.opts.AdjustInterval {
+		a.adjust(elapsed)
+		a.adjusted = now
+	}
+	op, ok := a.operations[params.OperationName]
+	switch {
+	case ok:
+	case len(a.operations) < a.opts.MaxOperations:
+		op = a.newOperation()
+		a.operations[params.OperationName] = op
+	case a.opts.Fallback != nil:
+		a.mu.Unlock()
+		return sampleSpan(a.opts.Fallback, params)
+	default:
+		op = a.other
+	}
+	op.count++
+	probability := op.probability
+	lowerBound := op.lowerBound
+	a.mu.Unlock()
+
+	if probability >= 1 || hashID(params.TraceID) < uint64(probability*(1<<64)) {
+		return SamplingDecision{
+			Sampled: true,
+			Tags:    map[string]interface{}{SamplerTypeTag: "adaptive", SamplerParamTag: probability},
+		}
+	}
+	if lowerBound != nil && lowerBound.Allow() {
+		return SamplingDecision{
+			Sampled: true,
+			Tags:    map[string]interface{}{SamplerTypeTag: "lowerbound", SamplerParamTag: a.opts.LowerBound},
+		}
+	}
+	return SamplingDecision{
+		Tags: map[string]interface{}{SamplerTypeTag: "adaptive", SamplerParamTag: probability},
+	}
+}
+
+func (a *adaptiveSampler) newOperation() *adaptiveOperation {
+	op := &adaptiveOperation{probability: a.opts.InitialProbability}
+	if a.opts.LowerBound > 0 {
+		op.lowerBound = a.newLowerBound()
+	}
+	return op
+}
+
+// newLowerBound returns a rate limiter for the lower bound. Rates of
+// less than one trace per second are expressed as one trace per
+// longer interval.
+func (a *adaptiveSampler) newLowerBound() *rateLimiter {
+	var l *rateLimiter
+	if a.opts.LowerBound >= 1 {
+		l = newRateLimiter(int(a.opts.LowerBound))
+	} else {
+		l = newRateLimiterPer(1, time.Duration(float64(time.Second)/a.opts.LowerBound))
+	}
+	l.nowFn = a.nowFn
+	l.t = a.nowFn()
+	return l
+}
+
+// adjust computes new probabilities based on the number of spans seen
+// in the elapsed time and forgets idle operations. It must be called
+// with a.mu held.
+func (a *adaptiveSampler) adjust(elapsed time.Duration) {
+	intervals := int(elapsed / a.opts.AdjustInterval)
+	for name, op := range a.operations {
+		if op.count == 0 {
+			op.idle += intervals
+			if op.idle >= a.opts.MaxIdleIntervals {
+				delete(a.operations, name)
+			}
+			continue
+		}
+		a.adjustOperation(op, elapsed)
+	}
+	if a.other != nil && a.other.count > 0 {
+		a.adjustOperation(a.other, elapsed)
+	}
+}
+
+func (a *adaptiveSampler) adjustOperation(op *adaptiveOperation, elapsed time.Duration) {
+	rate := float64(op.count) / elapsed.Seconds()
+	op.probability = a.opts.TargetRate / rate
+	if op.probability > 1 {
+		op.probability = 1
+	}
+	op.count = 0
+	op.idle = 0
+}
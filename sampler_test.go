@@ -1,6 +1,8 @@
 package tracer
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -122,3 +124,360 @@ func TestSamplerUse(t *testing.T) {
 		t.Errorf("span was sampled but didn't expect it to be")
 	}
 }
+
+type countingSampler struct {
+	decision bool
+	n        int
+}
+
+func (c *countingSampler) Sample(uint64) bool {
+	c.n++
+	return c.decision
+}
+
+func TestCombinedSamplers(t *testing.T) {
+	yes, no := NewConstSampler(true), NewConstSampler(false)
+	tests := []struct {
+		name    string
+		s       Sampler
+		sampled bool
+	}{
+		{"and(true, true)", NewAndSampler(yes, yes), true},
+		{"and(true, false)", NewAndSampler(yes, no), false},
+		{"and()", NewAndSampler(), true},
+		{"or(false, true)", NewOrSampler(no, yes), true},
+		{"or(false, false)", NewOrSampler(no, no), false},
+		{"or()", NewOrSampler(), false},
+		{"first match", NewFirstMatchSampler(no, SamplingRule{Sampler: yes}), true},
+		{"fallback", NewFirstMatchSampler(yes), true},
+	}
+	for _, tt := range tests {
+		if got := tt.s.Sample(1); got != tt.sampled {
+			t.Errorf("%s: got %t, want %t", tt.name, got, tt.sampled)
+		}
+	}
+
+	c1, c2 := &countingSampler{decision: false}, &countingSampler{decision: true}
+	NewAndSampler(c1, c2).Sample(1)
+	if c1.n != 1 || c2.n != 0 {
+		t.Errorf("and sampler didn't short-circuit: called samplers %d and %d times", c1.n, c2.n)
+	}
+	c1, c2 = &countingSampler{decision: true}, &countingSampler{decision: true}
+	NewOrSampler(c1, c2).Sample(1)
+	if c1.n != 1 || c2.n != 0 {
+		t.Errorf("or sampler didn't short-circuit: called samplers %d and %d times", c1.n, c2.n)
+	}
+}
+
+func TestOperationSampler(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("svc", storer, RandomID{})
+	tr.Sampler = NewOperationSampler(NewConstSampler(false), map[string]Sampler{
+		"important": NewConstSampler(true),
+	})
+	tr.StartSpan("important").Finish()
+	tr.StartSpan("boring").Finish()
+	if len(storer.spans) != 1 || storer.spans[0].OperationName != "important" {
+		t.Fatalf("got %d spans, want only the important one", len(storer.spans))
+	}
+	tags := storer.spans[0].Tags
+	if tags[SamplerTypeTag] != "const" || tags[SamplerParamTag] != true {
+		t.Errorf("got sampler tags (%v, %v), want (const, true)", tags[SamplerTypeTag], tags[SamplerParamTag])
+	}
+}
+
+func TestConstSamplerTags(t *testing.T) {
+	if d := NewConstSampler(false).(SpanSampler).SampleSpan(SamplingParameters{}); d.Tags != nil {
+		t.Errorf("got tags %v for a negative decision, expected none", d.Tags)
+	}
+
+	storer := &recordingStorer{}
+	tr := NewTracer("svc", storer, RandomID{})
+	tr.Sampler = NewConstSampler(true)
+	root := tr.StartSpan("root")
+	child := tr.StartSpan("child", opentracing.ChildOf(root.Context()))
+	child.Finish()
+	root.Finish()
+	if len(storer.spans) != 2 {
+		t.Fatalf("got %d spans, expected 2", len(storer.spans))
+	}
+	if tags := storer.spans[0].Tags; tags[SamplerTypeTag] != nil {
+		t.Errorf("got sampler tags %v on the child span, expected none", tags)
+	}
+	if tags := storer.spans[1].Tags; tags[SamplerTypeTag] != "const" {
+		t.Errorf("got sampler tags %v on the root span, expected const", tags)
+	}
+}
+
+func TestSpanSamplerParameters(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("svc", storer, RandomID{})
+	var got SamplingParameters
+	tr.Sampler = SamplerFunc(func(params SamplingParameters) SamplingDecision {
+		got = params
+		return SamplingDecision{
+			Sampled: params.Tags["http.url"] == "/debug",
+			Tags:    map[string]interface{}{"sampler.rule": "debug-url"},
+		}
+	})
+	tags := opentracing.Tags{"http.url": "/debug"}
+	sp := tr.StartSpan("op", tags)
+	sp.Finish()
+	ctx := sp.Context().(SpanContext)
+	if got.ServiceName != "svc" || got.OperationName != "op" ||
+		got.TraceID != ctx.TraceID || got.SpanID != ctx.SpanID ||
+		got.Tags["http.url"] != "/debug" {
+		t.Errorf("got unexpected sampling parameters %+v", got)
+	}
+	if len(storer.spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(storer.spans))
+	}
+	if storer.spans[0].Tags["sampler.rule"] != "debug-url" || storer.spans[0].Tags["http.url"] != "/debug" {
+		t.Errorf("got tags %v", storer.spans[0].Tags)
+	}
+	if _, ok := tags["sampler.rule"]; ok {
+		t.Error("sampling decision modified the caller's tags")
+	}
+
+	// Children don't consult the sampler.
+	got = SamplingParameters{}
+	tr.StartSpan("child", opentracing.ChildOf(sp.Context())).Finish()
+	if got.OperationName != "" {
+		t.Error("sampler was consulted for a child span")
+	}
+	if len(storer.spans) != 2 {
+		t.Errorf("got %d spans, want 2", len(storer.spans))
+	}
+}
+
+func TestTraceIDSampler(t *testing.T) {
+	for _, rate := range []float64{0, 0.01, 0.25, 0.5, 1} {
+		s := NewTraceIDSampler(rate)
+		var n int
+		// Sequential IDs must be sampled at the same rate as random
+		// ones.
+		for i := 1; i <= N; i++ {
+			if s.Sample(uint64(i)) {
+				n++
+			}
+		}
+		want := int(rate * float64(N))
+		if n < want-N/200 || n > want+N/200 {
+			t.Errorf("rate %g: got %d out of %d samples, expected about %d", rate, n, N, want)
+		}
+	}
+}
+
+func TestTraceIDSamplerConsistent(t *testing.T) {
+	s1 := NewTraceIDSampler(0.3)
+	s2 := NewTraceIDSampler(0.3)
+	higher := NewTraceIDSampler(0.6)
+	ids := RandomID{}
+	for i := 0; i < 10000; i++ {
+		id := ids.GenerateID()
+		d1 := s1.(SpanSampler).SampleSpan(SamplingParameters{TraceID: id})
+		d2 := s2.(SpanSampler).SampleSpan(SamplingParameters{TraceID: id})
+		if d1.Sampled != d2.Sampled {
+			t.Fatalf("samplers disagree on trace %d", id)
+		}
+		if d1.Sampled != s1.Sample(id) {
+			t.Fatalf("Sample and SampleSpan disagree on trace %d", id)
+		}
+		if d1.Sampled && !higher.Sample(id) {
+			t.Fatalf("trace %d sampled at rate 0.3 but not at rate 0.6", id)
+		}
+	}
+}
+
+func TestSamplersConcurrent(t *testing.T) {
+	samplers := []Sampler{
+		NewProbabilisticSampler(0.5),
+		NewTraceIDSampler(0.5),
+		NewRateSampler(100),
+		NewAdaptiveSampler(AdaptiveSamplerOptions{TargetRate: 10, LowerBound: 1}),
+	}
+	var wg sync.WaitGroup
+	for _, s := range samplers {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(s Sampler) {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					s.Sample(uint64(j))
+				}
+			}(s)
+		}
+	}
+	wg.Wait()
+}
+
+func newTestAdaptiveSampler(opts AdaptiveSamplerOptions, now *time.Time) *adaptiveSampler {
+	s := NewAdaptiveSampler(opts).(*adaptiveSampler)
+	s.nowFn = func() time.Time { return *now }
+	s.adjusted = *now
+	return s
+}
+
+func TestAdaptiveSampler(t *testing.T) {
+	now := time.Now()
+	s := newTestAdaptiveSampler(AdaptiveSamplerOptions{
+		TargetRate:     10,
+		AdjustInterval: time.Second,
+	}, &now)
+
+	id := uint64(0)
+	n := 0
+	for sec := 0; sec < 10; sec++ {
+		for i := 0; i < 1000; i++ {
+			id++
+			d := s.SampleSpan(SamplingParameters{TraceID: id, OperationName: "hot"})
+			if sec > 0 && d.Sampled {
+				n++
+			}
+		}
+		now = now.Add(time.Second)
+	}
+	// 9 seconds at 10 traces per second
+	if n < 60 || n > 120 {
+		t.Errorf("got %d samples, expected about 90", n)
+	}
+	if p := s.operations["hot"].probability; p != 0.01 {
+		t.Errorf("got probability %f, expected 0.01", p)
+	}
+
+	// a rare operation keeps being sampled
+	for i := 0; i < 10; i++ {
+		id++
+		if !s.SampleSpan(SamplingParameters{TraceID: id, OperationName: "rare"}).Sampled {
+			t.Errorf("rare operation wasn't sampled")
+		}
+		now = now.Add(time.Second)
+	}
+}
+
+func TestAdaptiveSamplerLowerBound(t *testing.T) {
+	now := time.Now()
+	s := newTestAdaptiveSampler(AdaptiveSamplerOptions{
+		TargetRate:     0.001,
+		LowerBound:     1,
+		AdjustInterval: time.Second,
+	}, &now)
+
+	id := uint64(0)
+	for sec := 0; sec < 10; sec++ {
+		n := 0
+		for i := 0; i < 100; i++ {
+			id++
+			d := s.SampleSpan(SamplingParameters{TraceID: id, OperationName: "op"})
+			if d.Sampled {
+				n++
+				if sec > 0 && d.Tags[SamplerTypeTag] != "lowerbound" {
+					t.Errorf("got sampler type %v, expected lowerbound", d.Tags[SamplerTypeTag])
+				}
+			}
+		}
+		if sec > 0 && n != 1 {
+			t.Errorf("got %d samples in second %d, expected 1", n, sec)
+		}
+		now = now.Add(time.Second)
+	}
+}
+
+func TestAdaptiveSamplerMaxOperations(t *testing.T) {
+	fallback := &countingSampler{}
+	s := NewAdaptiveSampler(AdaptiveSamplerOptions{
+		TargetRate:    10,
+		MaxOperations: 2,
+		Fallback:      fallback,
+	}).(*adaptiveSampler)
+	for _, op := range []string{"a", "b", "c", "d", "a"} {
+		s.SampleSpan(SamplingParameters{TraceID: 1, OperationName: op})
+	}
+	if len(s.operations) != 2 {
+		t.Errorf("tracking %d operations, expected 2", len(s.operations))
+	}
+	if fallback.n != 2 {
+		t.Errorf("fallback was called %d times, expected 2", fallback.n)
+	}
+}
+
+func TestAdaptiveSamplerDefaultOperation(t *testing.T) {
+	now := time.Now()
+	s := newTestAdaptiveSampler(AdaptiveSamplerOptions{
+		TargetRate:     10,
+		AdjustInterval: time.Second,
+		MaxOperations:  1,
+	}, &now)
+
+	// Operations beyond MaxOperations share the default operation,
+	// whose probability adapts to their combined rate.
+	for i := 0; i < 1000; i++ {
+		s.SampleSpan(SamplingParameters{TraceID: uint64(i), OperationName: "a"})
+		s.SampleSpan(SamplingParameters{TraceID: uint64(i), OperationName: fmt.Sprintf("op%d", i%10)})
+	}
+	now = now.Add(time.Second)
+	s.SampleSpan(SamplingParameters{TraceID: 1, OperationName: "b"})
+	if len(s.operations) != 1 {
+		t.Errorf("tracking %d operations, expected 1", len(s.operations))
+	}
+	if p := s.other.probability; p != 0.01 {
+		t.Errorf("got probability %f for the default operation, expected 0.01", p)
+	}
+}
+
+func TestAdaptiveSamplerIdleOperations(t *testing.T) {
+	now := time.Now()
+	s := newTestAdaptiveSampler(AdaptiveSamplerOptions{
+		TargetRate:       10,
+		AdjustInterval:   time.Second,
+		MaxOperations:    2,
+		MaxIdleIntervals: 3,
+	}, &now)
+
+	s.SampleSpan(SamplingParameters{TraceID: 1, OperationName: "idle"})
+	for sec := 0; sec < 3; sec++ {
+		now = now.Add(time.Second)
+		s.SampleSpan(SamplingParameters{TraceID: 1, OperationName: "busy"})
+	}
+	if _, ok := s.operations["idle"]; !ok {
+		t.Fatal("operation was forgotten before being idle for 3 intervals")
+	}
+	now = now.Add(time.Second)
+	s.SampleSpan(SamplingParameters{TraceID: 1, OperationName: "new"})
+	if _, ok := s.operations["idle"]; ok {
+		t.Error("idle operation wasn't forgotten")
+	}
+	if _, ok := s.operations["new"]; !ok {
+		t.Error("new operation didn't take the idle operation's place")
+	}
+	if _, ok := s.operations["busy"]; !ok {
+		t.Error("busy operation was forgotten")
+	}
+
+	// Skipped intervals count as idle, too.
+	now = now.Add(time.Minute)
+	s.SampleSpan(SamplingParameters{TraceID: 1, OperationName: "new"})
+	if _, ok := s.operations["busy"]; ok {
+		t.Error("operation wasn't forgotten after a minute without spans")
+	}
+}
+
+type idSampler struct {
+	ids []uint64
+}
+
+func (s *idSampler) Sample(id uint64) bool {
+	s.ids = append(s.ids, id)
+	return true
+}
+
+func TestSamplerTraceID(t *testing.T) {
+	s := &idSampler{}
+	tr := NewTracer("svc", &recordingStorer{}, RandomID{})
+	tr.Sampler = s
+	sp := tr.StartSpan("root")
+	raw := sp.(*Span).RawSpan()
+	if len(s.ids) != 1 || s.ids[0] != raw.TraceID {
+		t.Errorf("sampler got IDs %v, expected trace ID %d", s.ids, raw.TraceID)
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+// The types of sampling strategies.
+const (
+	// Sample a fixed fraction of traces. The parameter is the
+	// probability, between 0 and 1.
+	SamplingProbabilistic = "probabilistic"
+	// Sample up to a fixed number of traces per second. The
+	// parameter is the number of traces.
+	SamplingRateLimiting = "rate"
+)
+
+// A SamplingStrategy describes how a service or operation should be
+// sampled.
+type SamplingStrategy struct {
+	// The type of strategy, SamplingProbabilistic or
+	// SamplingRateLimiting.
+	Type string
+	// The parameter of the strategy. Its meaning depends on the
+	// type.
+	Param float64
+}
+
+// ServiceSamplingStrategy describes how the operations of a service
+// should be sampled.
+type ServiceSamplingStrategy struct {
+	// The strategy for operations that aren't listed in Operations.
+	// If nil, the global default will be used.
+	Default *SamplingStrategy
+	// Strategies for individual operations.
+	Operations map[string]SamplingStrategy
+}
+
+// SamplingStrategies are the sampling strategies that the server
+// serves to clients.
+type SamplingStrategies struct {
+	// The strategy for services that have no strategy of their own.
+	// If nil, clients will use their local default.
+	Default *SamplingStrategy
+	// Strategies for individual services.
+	Services map[string]ServiceSamplingStrategy
+}
+
+// Strategy returns the sampling strategy of a service, falling back
+// to the global default.
+func (s *SamplingStrategies) Strategy(service string) ServiceSamplingStrategy {
+	if s == nil {
+		return ServiceSamplingStrategy{}
+	}
+	st, ok := s.Services[service]
+	if !ok {
+		return ServiceSamplingStrategy{Default: s.Default}
+	}
+	if st.Default == nil {
+		st.Default = s.Default
+	}
+	return st
+}
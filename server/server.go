@@ -2,6 +2,7 @@
 package server
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
@@ -78,11 +79,23 @@ type Purger interface {
 	Purge(before time.Time) error
 }
 
+// A PermanentError is a storage error that would occur again if the
+// same spans were stored again, for example because they violate a
+// constraint. Storage transports don't ask clients to retry them.
+type PermanentError struct {
+	Err error
+}
+
+func (err PermanentError) Error() string {
+	return err.Err.Error()
+}
+
 // A Queryer is a backend that allows fetching traces and spans by ID
 // or via a more advanced query.
 type Queryer interface {
-	// TraceByID returns a trace with a specific ID.
-	TraceByID(id uint64) (tracer.RawTrace, error)
+	// TraceByID returns a trace with a specific ID. high contains the
+	// high 64 bits of 128-bit trace IDs and is zero otherwise.
+	TraceByID(high, low uint64) (tracer.RawTrace, error)
 	// SpanByID returns a span with a specific ID.
 	SpanByID(id uint64) (tracer.RawSpan, error)
 	// QueryTraces returns all traces that match a query.
@@ -112,6 +125,11 @@ type QueryTag struct {
 	Value string
 	// Whether the value should be checked for.
 	CheckValue bool
+	// If set, the numeric value of the tag will be compared with
+	// Number, using one of the operators <, <=, =, >= and >. Value
+	// and CheckValue are ignored in that case.
+	Op     string
+	Number float64
 }
 
 // A Query describes the various conditionals of a query for a trace.
@@ -139,6 +157,28 @@ type Query struct {
 	Num int
 	// ServiceNames to filter by.
 	ServiceNames []string
+	// Only return traces that contain a span with tracer.FlagDebug.
+	Debug bool
+	// Only return traces that contain a span whose resource has all
+	// of these attributes. Values are compared as text.
+	Resource map[string]string
+}
+
+// ParseResource parses the resource conditions of a query, as sent
+// to query transports. Each condition has the form key=value.
+func ParseResource(conds []string) (map[string]string, error) {
+	if len(conds) == 0 {
+		return nil, nil
+	}
+	res := make(map[string]string, len(conds))
+	for _, cond := range conds {
+		idx := strings.IndexByte(cond, '=')
+		if idx < 1 {
+			return nil, fmt.Errorf("invalid resource condition %q, expected key=value", cond)
+		}
+		res[cond[:idx]] = cond[idx+1:]
+	}
+	return res, nil
 }
 
 // Server is an instance of the Tracer application.
@@ -146,6 +186,8 @@ type Server struct {
 	Storage          Storage
 	StorageTransport StorageTransport
 	QueryTransports  []QueryTransport
+	// The sampling strategies served to clients. May be nil.
+	Sampling *SamplingStrategies
 }
 
 type errors struct {
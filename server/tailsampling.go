@@ -0,0 +1,343 @@
+package server
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tracer/tracer"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A TailPolicy decides whether a trace should be kept, based on all
+// of its spans that arrived within the decision window.
+type TailPolicy interface {
+	Keep(spans []tracer.RawSpan) bool
+}
+
+// TailPolicyFunc is a function that implements the TailPolicy
+// interface.
+type TailPolicyFunc func(spans []tracer.RawSpan) bool
+
+// Keep implements the TailPolicy interface.
+func (fn TailPolicyFunc) Keep(spans []tracer.RawSpan) bool {
+	return fn(spans)
+}
+
+// ErrorPolicy keeps traces that contain a span with the error tag
+// set to true.
+func ErrorPolicy() TailPolicy {
+	return TailPolicyFunc(func(spans []tracer.RawSpan) bool {
+		for _, sp := range spans {
+			switch v := sp.Tags[string(ext.Error)].(type) {
+			case bool:
+				if v {
+					return true
+				}
+			case string:
+				if v == "true" {
+					return true
+				}
+			}
+		}
+		return false
+	})
+}
+
+// DurationPolicy keeps traces that lasted at least min, measured from
+// the earliest start to the latest finish of their spans.
+func DurationPolicy(min time.Duration) TailPolicy {
+	return TailPolicyFunc(func(spans []tracer.RawSpan) bool {
+		var start, finish time.Time
+		for _, sp := range spans {
+			if start.IsZero() || sp.StartTime.Before(start) {
+				start = sp.StartTime
+			}
+			if sp.FinishTime.After(finish) {
+				finish = sp.FinishTime
+			}
+		}
+		return finish.Sub(start) >= min
+	})
+}
+
+// OperationPolicy keeps traces that contain a span of a service and
+// operation. An empty operation matches all operations of the
+// service.
+func OperationPolicy(service, operation string) TailPolicy {
+	return TailPolicyFunc(func(spans []tracer.RawSpan) bool {
+		for _, sp := range spans {
+			if sp.ServiceName == service && (operation == "" || sp.OperationName == operation) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// ProbabilisticPolicy keeps a fraction of all traces. The decision
+// is based on the trace ID and agrees with tracer.NewTraceIDSampler.
+func ProbabilisticPolicy(rate float64) TailPolicy {
+	s := tracer.NewTraceIDSampler(rate)
+	return TailPolicyFunc(func(spans []tracer.RawSpan) bool {
+		return len(spans) > 0 && s.Sample(spans[0].TraceID)
+	})
+}
+
+// TailSamplingOptions are options for tail-based sampling.
+type TailSamplingOptions struct {
+	// How long to buffer the spans of a trace, starting with its
+	// first span, before deciding whether to keep it.
+	Window time.Duration
+	// The maximum number of spans to buffer. When the buffer is
+	// full, decisions for the oldest traces will be made early. Zero
+	// means 100000.
+	MaxSpans int
+	// Traces matching any of the policies will be kept, all other
+	// traces will be dropped.
+	Policies []TailPolicy
+	// Where to log errors. If nil, errors will be logged to stderr.
+	Logger tracer.Logger
+}
+
+type traceKey struct {
+	high, low uint64
+}
+
+type tailTrace struct {
+	key   traceKey
+	first time.Time
+	spans []tracer.RawSpan
+}
+
+type tailDecision struct {
+	key     traceKey
+	kept    bool
+	expires time.Time
+}
+
+// TailSampler is a Storage that buffers spans by trace and only
+// stores traces that match at least one policy once the decision
+// window has passed.
+//
+// Spans that arrive after the decision for their trace has been made
+// follow that decision, as long as they arrive within another
+// window. Traces containing spans with tracer.FlagDebug are always
+// kept.
+type TailSampler struct {
+	Storage
+	opts TailSamplingOptions
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	traces  map[traceKey]*tailTrace
+	queue   []*tailTrace
+	decided map[traceKey]*tailDecision
+	expiry  []*tailDecision
+	nspans  int
+	nowFn   func() time.Time
+
+	bufferedSpans  prometheus.Gauge
+	bufferedTraces prometheus.Gauge
+	decisions      *prometheus.CounterVec
+	evicted        prometheus.Counter
+}
+
+// NewTailSampler returns a TailSampler that stores kept traces in
+// storage. It starts a goroutine that makes decisions as windows
+// pass, until Close is called.
+func NewTailSampler(storage Storage, opts TailSamplingOptions) *TailSampler {
+	ts := newTailSampler(storage, opts)
+	go ts.loop()
+	return ts
+}
+
+func newTailSampler(storage Storage, opts TailSamplingOptions) *TailSampler {
+	if opts.MaxSpans == 0 {
+		opts.MaxSpans = 100000
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	ts := &TailSampler{
+		Storage: storage,
+		opts:    opts,
+		done:    make(chan struct{}),
+		traces:  map[traceKey]*tailTrace{},
+		decided: map[traceKey]*tailDecision{},
+		nowFn:   time.Now,
+
+		bufferedSpans: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "tracer_tail_buffered_spans",
+			Help: "Number of spans waiting for a sampling decision",
+		}),
+		bufferedTraces: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "tracer_tail_buffered_traces",
+			Help: "Number of traces waiting for a sampling decision",
+		}),
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tracer_tail_decisions_total",
+			Help: "Number of sampling decisions",
+		}, []string{"decision"}),
+		evicted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "tracer_tail_evicted_traces_total",
+			Help: "Number of traces decided early because the buffer was full",
+		}),
+	}
+	for _, c := range []prometheus.Collector{ts.bufferedSpans, ts.bufferedTraces, ts.decisions, ts.evicted} {
+		if err := prometheus.Register(c); err != nil {
+			ts.opts.Logger.Printf("couldn't register prometheus collector: %s", err)
+		}
+	}
+	return ts
+}
+
+func (ts *TailSampler) loop() {
+	interval := ts.opts.Window / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			ts.store(ts.expire())
+		case <-ts.done:
+			return
+		}
+	}
+}
+
+// Close stops making decisions as windows pass and decides about all
+// buffered traces immediately. Spans stored after Close follow
+// earlier decisions or stay buffered until Flush is called.
+func (ts *TailSampler) Close() {
+	ts.closeOnce.Do(func() { close(ts.done) })
+	ts.Flush()
+}
+
+// Store implements the tracer.Storer interface. Spans are buffered
+// until the decision for their trace has been made.
+func (ts *TailSampler) Store(sp tracer.RawSpan) error {
+	key := traceKey{sp.TraceIDHigh, sp.TraceID}
+	ts.mu.Lock()
+	if d, ok := ts.decided[key]; ok {
+		ts.mu.Unlock()
+		if d.kept || sp.Flags&tracer.FlagDebug != 0 {
+			return ts.Storage.Store(sp)
+		}
+		return nil
+	}
+	tr, ok := ts.traces[key]
+	if !ok {
+		tr = &tailTrace{key: key, first: ts.nowFn()}
+		ts.traces[key] = tr
+		ts.queue = append(ts.queue, tr)
+	}
+	tr.spans = append(tr.spans, sp)
+	ts.nspans++
+	var kept []*tailTrace
+	for ts.nspans > ts.opts.MaxSpans && len(ts.queue) > 0 {
+		ts.evicted.Inc()
+		if tr := ts.decide(); tr != nil {
+			kept = append(kept, tr)
+		}
+	}
+	ts.updateGauges()
+	ts.mu.Unlock()
+	ts.store(kept)
+	return nil
+}
+
+// Flush decides about all buffered traces immediately.
+func (ts *TailSampler) Flush() {
+	ts.mu.Lock()
+	var kept []*tailTrace
+	for len(ts.queue) > 0 {
+		if tr := ts.decide(); tr != nil {
+			kept = append(kept, tr)
+		}
+	}
+	ts.updateGauges()
+	ts.mu.Unlock()
+	ts.store(kept)
+}
+
+// expire decides about all traces whose window has passed and
+// forgets old decisions. It returns the traces that should be kept.
+func (ts *TailSampler) expire() []*tailTrace {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	now := ts.nowFn()
+	var kept []*tailTrace
+	for len(ts.queue) > 0 && now.Sub(ts.queue[0].first) >= ts.opts.Window {
+		if tr := ts.decide(); tr != nil {
+			kept = append(kept, tr)
+		}
+	}
+	for len(ts.expiry) > 0 && !now.Before(ts.expiry[0].expires) {
+		delete(ts.decided, ts.expiry[0].key)
+		ts.expiry[0] = nil
+		ts.expiry = ts.expiry[1:]
+	}
+	ts.updateGauges()
+	return kept
+}
+
+// decide makes the decision for the oldest buffered trace. It returns
+// the trace if it should be kept. It must be called with ts.mu held.
+func (ts *TailSampler) decide() *tailTrace {
+	tr := ts.queue[0]
+	ts.queue[0] = nil
+	ts.queue = ts.queue[1:]
+	delete(ts.traces, tr.key)
+	ts.nspans -= len(tr.spans)
+
+	// Debug traces are always kept.
+	kept := debugTrace(tr.spans)
+	for _, p := range ts.opts.Policies {
+		if kept {
+			break
+		}
+		kept = p.Keep(tr.spans)
+	}
+	d := &tailDecision{key: tr.key, kept: kept, expires: ts.nowFn().Add(ts.opts.Window)}
+	ts.decided[tr.key] = d
+	ts.expiry = append(ts.expiry, d)
+	if !kept {
+		ts.decisions.WithLabelValues("dropped").Inc()
+		return nil
+	}
+	ts.decisions.WithLabelValues("kept").Inc()
+	return tr
+}
+
+func debugTrace(spans []tracer.RawSpan) bool {
+	for _, sp := range spans {
+		if sp.Flags&tracer.FlagDebug != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (ts *TailSampler) updateGauges() {
+	ts.bufferedSpans.Set(float64(ts.nspans))
+	ts.bufferedTraces.Set(float64(len(ts.traces)))
+}
+
+func (ts *TailSampler) store(traces []*tailTrace) {
+	for _, tr := range traces {
+		for _, sp := range tr.spans {
+			if err := ts.Storage.Store(sp); err != nil {
+				ts.opts.Logger.Printf("couldn't store span: %s", err)
+			}
+		}
+	}
+}
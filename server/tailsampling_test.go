@@ -0,0 +1,234 @@
+package server
+
+import (
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tracer/tracer"
+	"github.com/tracer/tracer/internal/promtest"
+)
+
+// memStorage records stored spans. It doesn't support queries.
+type memStorage struct {
+	Storage
+
+	mu    sync.Mutex
+	spans []tracer.RawSpan
+}
+
+func (st *memStorage) Store(sp tracer.RawSpan) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.spans = append(st.spans, sp)
+	return nil
+}
+
+// stored returns the IDs of all stored spans.
+func (st *memStorage) stored() []uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var ids []uint64
+	for _, sp := range st.spans {
+		ids = append(ids, sp.SpanID)
+	}
+	return ids
+}
+
+func span(trace, id uint64, tags map[string]interface{}) tracer.RawSpan {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	return tracer.RawSpan{
+		SpanContext:   tracer.SpanContext{TraceID: trace, SpanID: id, Flags: tracer.FlagSampled},
+		ServiceName:   "service",
+		OperationName: "operation",
+		StartTime:     start,
+		FinishTime:    start.Add(time.Second),
+		Tags:          tags,
+	}
+}
+
+// testTailSampler returns a TailSampler without a background
+// goroutine, and a function that advances its clock.
+func testTailSampler(opts TailSamplingOptions) (*TailSampler, *memStorage, func(time.Duration)) {
+	st := &memStorage{}
+	opts.Logger = log.New(ioutil.Discard, "", 0)
+	ts := newTailSampler(st, opts)
+	now := time.Unix(0, 0)
+	ts.nowFn = func() time.Time { return now }
+	return ts, st, func(d time.Duration) { now = now.Add(d) }
+}
+
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTailPolicies(t *testing.T) {
+	long := span(1, 1, nil)
+	long.FinishTime = long.StartTime.Add(time.Minute)
+	var keptID, droppedID uint64
+	s := tracer.NewTraceIDSampler(0.5)
+	for id := uint64(1); keptID == 0 || droppedID == 0; id++ {
+		if s.Sample(id) {
+			keptID = id
+		} else {
+			droppedID = id
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy TailPolicy
+		spans  []tracer.RawSpan
+		want   bool
+	}{
+		{"error", ErrorPolicy(), []tracer.RawSpan{span(1, 1, nil), span(1, 2, map[string]interface{}{"error": true})}, true},
+		{"error string", ErrorPolicy(), []tracer.RawSpan{span(1, 1, map[string]interface{}{"error": "true"})}, true},
+		{"no error", ErrorPolicy(), []tracer.RawSpan{span(1, 1, map[string]interface{}{"error": false})}, false},
+		{"latency", DurationPolicy(time.Minute), []tracer.RawSpan{span(1, 2, nil), long}, true},
+		{"low latency", DurationPolicy(time.Minute), []tracer.RawSpan{span(1, 1, nil)}, false},
+		{"operation", OperationPolicy("service", ""), []tracer.RawSpan{span(1, 1, nil)}, true},
+		{"other operation", OperationPolicy("service", "other"), []tracer.RawSpan{span(1, 1, nil)}, false},
+		{"rate kept", ProbabilisticPolicy(0.5), []tracer.RawSpan{span(keptID, 1, nil)}, true},
+		{"rate dropped", ProbabilisticPolicy(0.5), []tracer.RawSpan{span(droppedID, 1, nil)}, false},
+		{"rate all", ProbabilisticPolicy(1), []tracer.RawSpan{span(droppedID, 1, nil)}, true},
+		{"rate none", ProbabilisticPolicy(0), []tracer.RawSpan{span(keptID, 1, nil)}, false},
+	}
+	for _, test := range tests {
+		if got := test.policy.Keep(test.spans); got != test.want {
+			t.Errorf("%s: got %t, expected %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTailSamplerWindow(t *testing.T) {
+	ts, st, advance := testTailSampler(TailSamplingOptions{
+		Window:   time.Minute,
+		Policies: []TailPolicy{ErrorPolicy()},
+	})
+	debug := span(3, 31, nil)
+	debug.Flags |= tracer.FlagDebug
+	ts.Store(span(1, 11, nil))
+	ts.Store(span(2, 21, nil))
+	ts.Store(debug)
+	advance(30 * time.Second)
+	// The error arrives within the window of trace 1.
+	ts.Store(span(1, 12, map[string]interface{}{"error": true}))
+
+	ts.store(ts.expire())
+	if ids := st.stored(); len(ids) != 0 {
+		t.Fatalf("got stored spans %v before the window passed", ids)
+	}
+	if got := promtest.CounterValue(t, ts.evicted); got != 0 {
+		t.Errorf("got %v evicted traces, expected 0", got)
+	}
+
+	advance(30 * time.Second)
+	ts.store(ts.expire())
+	if ids := st.stored(); !equalIDs(ids, []uint64{11, 12, 31}) {
+		t.Errorf("got stored spans %v, expected [11 12 31]", ids)
+	}
+	if got := promtest.CounterValue(t, ts.decisions.WithLabelValues("kept")); got != 2 {
+		t.Errorf("got %v kept traces, expected 2", got)
+	}
+	if got := promtest.CounterValue(t, ts.decisions.WithLabelValues("dropped")); got != 1 {
+		t.Errorf("got %v dropped traces, expected 1", got)
+	}
+	if ts.nspans != 0 || len(ts.traces) != 0 {
+		t.Errorf("got %d buffered spans of %d traces, expected none", ts.nspans, len(ts.traces))
+	}
+}
+
+func TestTailSamplerLateSpans(t *testing.T) {
+	ts, st, advance := testTailSampler(TailSamplingOptions{
+		Window:   time.Minute,
+		Policies: []TailPolicy{ErrorPolicy()},
+	})
+	ts.Store(span(1, 11, map[string]interface{}{"error": true}))
+	ts.Store(span(2, 21, nil))
+	advance(time.Minute)
+	ts.store(ts.expire())
+
+	// Late spans follow the decision for their trace, except for
+	// debug spans, which are always stored.
+	advance(30 * time.Second)
+	lateDebug := span(2, 23, nil)
+	lateDebug.Flags |= tracer.FlagDebug
+	ts.Store(span(1, 12, nil))
+	ts.Store(span(2, 22, nil))
+	ts.Store(lateDebug)
+	if ids := st.stored(); !equalIDs(ids, []uint64{11, 12, 23}) {
+		t.Errorf("got stored spans %v, expected [11 12 23]", ids)
+	}
+	if ts.nspans != 0 {
+		t.Errorf("got %d buffered spans, expected 0", ts.nspans)
+	}
+
+	// Once the decision expires, spans start a new window.
+	advance(30 * time.Second)
+	ts.store(ts.expire())
+	ts.Store(span(1, 13, nil))
+	if ts.nspans != 1 {
+		t.Errorf("got %d buffered spans, expected 1", ts.nspans)
+	}
+}
+
+func TestTailSamplerEviction(t *testing.T) {
+	ts, st, _ := testTailSampler(TailSamplingOptions{
+		Window:   time.Minute,
+		MaxSpans: 2,
+		Policies: []TailPolicy{ErrorPolicy()},
+	})
+	ts.Store(span(1, 11, map[string]interface{}{"error": true}))
+	ts.Store(span(2, 21, nil))
+	ts.Store(span(3, 31, nil))
+
+	// The oldest trace was decided early to make room.
+	if ids := st.stored(); !equalIDs(ids, []uint64{11}) {
+		t.Errorf("got stored spans %v, expected [11]", ids)
+	}
+	if got := promtest.CounterValue(t, ts.evicted); got != 1 {
+		t.Errorf("got %v evicted traces, expected 1", got)
+	}
+	if ts.nspans != 2 || len(ts.traces) != 2 {
+		t.Errorf("got %d buffered spans of %d traces, expected 2 of 2", ts.nspans, len(ts.traces))
+	}
+
+	ts.Store(span(4, 41, nil))
+	ts.Store(span(5, 51, nil))
+	if got := promtest.CounterValue(t, ts.evicted); got != 3 {
+		t.Errorf("got %v evicted traces, expected 3", got)
+	}
+	if got := promtest.CounterValue(t, ts.decisions.WithLabelValues("dropped")); got != 2 {
+		t.Errorf("got %v dropped traces, expected 2", got)
+	}
+}
+
+func TestTailSamplerClose(t *testing.T) {
+	st := &memStorage{}
+	ts := NewTailSampler(st, TailSamplingOptions{
+		Window:   time.Hour,
+		Policies: []TailPolicy{ErrorPolicy()},
+		Logger:   log.New(ioutil.Discard, "", 0),
+	})
+	ts.Store(span(1, 11, map[string]interface{}{"error": true}))
+	ts.Close()
+	ts.Close()
+	if ids := st.stored(); !equalIDs(ids, []uint64{11}) {
+		t.Errorf("got stored spans %v, expected [11]", ids)
+	}
+	select {
+	case <-ts.done:
+	default:
+		t.Error("tail sampler wasn't closed")
+	}
+}
@@ -24,7 +24,7 @@ type Null struct{}
 func (Null) Store(sp tracer.RawSpan) error { return nil }
 
 // TraceByID implements the server.Storage interface.
-func (Null) TraceByID(id uint64) (tracer.RawTrace, error) { return tracer.RawTrace{}, nil }
+func (Null) TraceByID(high, low uint64) (tracer.RawTrace, error) { return tracer.RawTrace{}, nil }
 
 // SpanByID implements the server.Storage interface.
 func (Null) SpanByID(id uint64) (tracer.RawSpan, error) { return tracer.RawSpan{}, nil }
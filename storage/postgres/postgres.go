@@ -5,8 +5,12 @@ import (
 	"bytes"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,8 +18,7 @@ import (
 	"github.com/tracer/tracer/server"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // load the postgres driver
-	"github.com/opentracing/opentracing-go"
+	"github.com/lib/pq"
 )
 
 func init() {
@@ -39,6 +42,7 @@ func setup(conf map[string]interface{}) (server.Storage, error) {
 
 var _ server.Storage = (*Storage)(nil)
 var _ server.Purger = (*Storage)(nil)
+var _ tracer.BatchStorer = (*Storage)(nil)
 
 // timeRange represents a PostgreSQL tstzrange. Caveat: it only
 // supports inclusive ranges.
@@ -87,20 +91,15 @@ func New(db *sql.DB) *Storage {
 }
 
 // Store implements the server.Storage interface.
-func (st *Storage) Store(sp tracer.RawSpan) (err error) {
-	const upsertSpan = `
-INSERT INTO spans (id, trace_id, time, service_name, operation_name)
-VALUES ($1, $2, $3, $4, $5)
-ON CONFLICT (id) DO
-  UPDATE SET
-    time = $3,
-    service_name = $4,
-    operation_name = $5`
-	const insertTag = `INSERT INTO tags (span_id, trace_id, key, value) VALUES ($1, $2, $3, $4)`
-	const insertLog = `INSERT INTO tags (span_id, trace_id, key, value, time) VALUES ($1, $2, $3, $4, $5)`
-	const insertParentRelation = `INSERT INTO relations (span1_id, span2_id, kind) VALUES ($1, $2, 'parent')`
-	const insertParentSpan = `INSERT INTO spans (id, trace_id, time, service_name, operation_name) VALUES ($1, $2, $3, '', '') ON CONFLICT (id) DO NOTHING`
+func (st *Storage) Store(sp tracer.RawSpan) error {
+	return st.StoreBatch([]tracer.RawSpan{sp})
+}
 
+// StoreBatch implements the tracer.BatchStorer interface. All spans
+// are stored in a single transaction. Errors caused by the spans
+// themselves, such as invalid encodings, are returned as
+// server.PermanentError.
+func (st *Storage) StoreBatch(spans []tracer.RawSpan) (err error) {
 	tx, err := st.db.Begin()
 	if err != nil {
 		return err
@@ -108,89 +107,245 @@ ON CONFLICT (id) DO
 	defer func() {
 		if err != nil {
 			_ = tx.Rollback()
+			if isPermanent(err) {
+				err = server.PermanentError{Err: err}
+			}
 			return
 		}
 		err = tx.Commit()
 	}()
+	resources := &resourceIDs{
+		maps:   map[uintptr]sql.NullInt64{},
+		stored: map[int64]bool{},
+	}
+	for _, sp := range spans {
+		if err = storeSpan(tx, sp, resources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resourceIDs caches the IDs of the resources stored by a batch.
+// Spans of a batch usually share a single resource map, so they are
+// looked up by the map's identity first, which avoids encoding the
+// map again for every span.
+type resourceIDs struct {
+	maps   map[uintptr]sql.NullInt64
+	stored map[int64]bool
+}
 
+// id returns the ID of a resource, storing the resource if it hasn't
+// been stored by the batch yet. Resources are identified by a
+// hash of their attributes.
+func (r *resourceIDs) id(tx *sql.Tx, res map[string]interface{}) (sql.NullInt64, error) {
+	const insertResource = `INSERT INTO resources (id, attributes) VALUES ($1, $2) ON CONFLICT (id) DO NOTHING`
+	if len(res) == 0 {
+		return sql.NullInt64{}, nil
+	}
+	key := reflect.ValueOf(res).Pointer()
+	if id, ok := r.maps[key]; ok {
+		return id, nil
+	}
+	attrs, err := json.Marshal(res)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(attrs)
+	id := sql.NullInt64{Int64: int64(h.Sum64()), Valid: true}
+	if !r.stored[id.Int64] {
+		if _, err := tx.Exec(insertResource, id, string(attrs)); err != nil {
+			return sql.NullInt64{}, err
+		}
+		r.stored[id.Int64] = true
+	}
+	r.maps[key] = id
+	return id, nil
+}
+
+// isPermanent reports whether err is a data exception, an integrity
+// constraint violation or an encoding error, which retrying won't
+// fix.
+func isPermanent(err error) bool {
+	switch err := err.(type) {
+	case *pq.Error:
+		switch err.Code.Class() {
+		case "22", "23":
+			return true
+		}
+	case *json.UnsupportedTypeError, *json.UnsupportedValueError:
+		// The resource can't be encoded.
+		return true
+	}
+	return false
+}
+
+// storeSpan stores a single span as part of tx.
+func storeSpan(tx *sql.Tx, sp tracer.RawSpan, resources *resourceIDs) error {
+	const upsertSpan = `
+INSERT INTO spans (id, trace_id, trace_id_high, time, service_name, operation_name, flags, resource_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (id) DO
+  UPDATE SET
+    time = $4,
+    service_name = $5,
+    operation_name = $6,
+    flags = $7,
+    resource_id = $8`
+	const insertTag = `INSERT INTO tags (span_id, trace_id, trace_id_high, key, value, number_value, bool_value, int_value, uint_value) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	const insertLog = `INSERT INTO tags (span_id, trace_id, trace_id_high, key, value, number_value, bool_value, int_value, uint_value, time, log_index, position) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	const insertRelation = `INSERT INTO relations (span1_id, span2_id, kind) VALUES ($1, $2, $3)`
+	const insertParentSpan = `INSERT INTO spans (id, trace_id, trace_id_high, time, service_name, operation_name) VALUES ($1, $2, $3, $4, '', '') ON CONFLICT (id) DO NOTHING`
+	const deleteTags = `DELETE FROM tags WHERE span_id = $1`
+	const deleteRelations = `DELETE FROM relations WHERE span2_id = $1`
+
+	resourceID, err := resources.id(tx, sp.Resource)
+	if err != nil {
+		return err
+	}
 	_, err = tx.Exec(upsertSpan,
-		int64(sp.SpanID), int64(sp.TraceID), timeRange{sp.StartTime, sp.FinishTime}, sp.ServiceName, sp.OperationName)
+		int64(sp.SpanID), int64(sp.TraceID), int64(sp.TraceIDHigh), timeRange{sp.StartTime, sp.FinishTime}, sp.ServiceName, sp.OperationName, int64(sp.Flags), resourceID)
 	if err != nil {
 		return err
 	}
+	// A span can be stored more than once, e.g. when it was flushed
+	// before it finished. The latest version replaces the tags, logs
+	// and references of earlier ones.
+	if _, err = tx.Exec(deleteTags, int64(sp.SpanID)); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(deleteRelations, int64(sp.SpanID)); err != nil {
+		return err
+	}
 
-	if sp.ParentID != 0 {
+	// Referenced spans that haven't been stored yet are inserted as
+	// placeholders. They have the time of their child, so that traces
+	// whose root span is never stored can still be found.
+	for _, ref := range sp.References {
 		_, err = tx.Exec(insertParentSpan,
-			int64(sp.ParentID), int64(sp.TraceID), timeRange{time.Time{}, time.Time{}})
+			int64(ref.SpanID), int64(ref.TraceID), int64(ref.TraceIDHigh), timeRange{sp.StartTime, sp.FinishTime})
 		if err != nil {
 			return err
 		}
-		_, err = tx.Exec(insertParentSpan,
-			int64(sp.TraceID), int64(sp.TraceID), timeRange{sp.StartTime, sp.FinishTime})
-		if err != nil {
-			return err
-		}
-
-		_, err = tx.Exec(insertParentRelation,
-			int64(sp.ParentID), int64(sp.SpanID))
+		_, err = tx.Exec(insertRelation,
+			int64(ref.SpanID), int64(sp.SpanID), ref.Kind)
 		if err != nil {
 			return err
 		}
 	}
 
 	for k, v := range sp.Tags {
-		vs := ""
-		if v != nil {
-			vs = fmt.Sprintf("%v", v)
-		}
+		c := tagColumns(v)
 		_, err = tx.Exec(insertTag,
-			int64(sp.SpanID), int64(sp.TraceID), k, vs)
+			int64(sp.SpanID), int64(sp.TraceID), int64(sp.TraceIDHigh), k, c.String, c.Number, c.Bool, c.Int, c.Uint)
 		if err != nil {
 			return err
 		}
 	}
-	for _, l := range sp.Logs {
-		v := ""
-		if l.Payload != nil {
-			v = fmt.Sprintf("%v", l.Payload)
-		}
-		_, err = tx.Exec(insertLog,
-			int64(sp.SpanID), int64(sp.TraceID), l.Event, v, l.Timestamp)
-		if err != nil {
-			return err
+	// Each log field is stored as a row of its own. log_index groups
+	// the fields of an entry and position preserves their order.
+	for i, l := range sp.Logs {
+		for j, f := range l.Fields {
+			c := tagColumns(f.Value)
+			_, err = tx.Exec(insertLog,
+				int64(sp.SpanID), int64(sp.TraceID), int64(sp.TraceIDHigh), f.Key, c.String, c.Number, c.Bool, c.Int, c.Uint, l.Timestamp, i, j)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
+// tagValueColumns are the columns of the tags table that hold the
+// value of a tag.
+type tagValueColumns struct {
+	String sql.NullString
+	Number sql.NullFloat64
+	Bool   sql.NullBool
+	Int    sql.NullInt64
+	// Unsigned integers are stored with the bits of their uint64
+	// value, so values above math.MaxInt64 are negative.
+	Uint sql.NullInt64
+}
+
+// tagColumns returns the values of the value columns for a tag value.
+// The value column always contains the value formatted as text, which
+// allows querying all values as strings. All numbers are stored in
+// number_value, which allows comparing them. Integers are
+// additionally stored in int_value or uint_value, so that they can be
+// read back without losing precision.
+func tagColumns(v interface{}) tagValueColumns {
+	if v == nil {
+		return tagValueColumns{String: sql.NullString{Valid: true}}
+	}
+	c := tagValueColumns{String: sql.NullString{String: fmt.Sprintf("%v", v), Valid: true}}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		c.Bool = sql.NullBool{Bool: rv.Bool(), Valid: true}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		c.Number = sql.NullFloat64{Float64: float64(rv.Int()), Valid: true}
+		c.Int = sql.NullInt64{Int64: rv.Int(), Valid: true}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		c.Number = sql.NullFloat64{Float64: float64(rv.Uint()), Valid: true}
+		c.Uint = sql.NullInt64{Int64: int64(rv.Uint()), Valid: true}
+	case reflect.Float32, reflect.Float64:
+		c.Number = sql.NullFloat64{Float64: rv.Float(), Valid: true}
+	}
+	return c
+}
+
+// value is the inverse of tagColumns. Integers are returned as int64
+// or uint64, other numbers as float64.
+func (c tagValueColumns) value() interface{} {
+	switch {
+	case c.Int.Valid:
+		return c.Int.Int64
+	case c.Uint.Valid:
+		return uint64(c.Uint.Int64)
+	case c.Number.Valid:
+		return c.Number.Float64
+	case c.Bool.Valid:
+		return c.Bool.Bool
+	default:
+		return c.String.String
+	}
+}
+
 // TraceByID implements the server.Storage interface.
-func (st *Storage) TraceByID(id uint64) (tracer.RawTrace, error) {
+func (st *Storage) TraceByID(high, low uint64) (tracer.RawTrace, error) {
 	tx, err := st.db.Begin()
 	if err != nil {
 		return tracer.RawTrace{}, err
 	}
 	defer tx.Rollback()
-	return st.traceByID(tx, id)
+	return st.traceByID(tx, high, low)
 }
 
-func (st *Storage) traceByID(tx *sql.Tx, id uint64) (tracer.RawTrace, error) {
+func (st *Storage) traceByID(tx *sql.Tx, high, low uint64) (tracer.RawTrace, error) {
 	const selectTrace = `
-SELECT spans.id, spans.trace_id, spans.time, spans.service_name, spans.operation_name, tags.key, tags.value, tags.time
+SELECT spans.id, spans.trace_id, spans.trace_id_high, spans.time, spans.service_name, spans.operation_name, spans.flags, tags.key, tags.value, tags.number_value, tags.bool_value, tags.int_value, tags.uint_value, tags.time, tags.log_index, resources.attributes
 FROM spans
   LEFT JOIN tags
     ON spans.id = tags.span_id
-WHERE spans.trace_id = $1
+  LEFT JOIN resources
+    ON spans.resource_id = resources.id
+WHERE spans.trace_id = $1 AND spans.trace_id_high = $2
 ORDER BY
   spans.time ASC,
   spans.id,
-  tags.time ASC`
+  tags.time ASC,
+  tags.log_index,
+  tags.position`
 	const selectRelations = `
 SELECT r.span1_id, r.span2_id, r.kind
 FROM relations AS r
-JOIN spans ON spans.id = r.span1_id
-WHERE spans.trace_id = $1;
+JOIN spans ON spans.id = r.span2_id
+WHERE spans.trace_id = $1 AND spans.trace_id_high = $2;
 `
-	rows, err := tx.Query(selectTrace, int64(id))
+	rows, err := tx.Query(selectTrace, int64(low), int64(high))
 	if err != nil {
 		return tracer.RawTrace{}, err
 	}
@@ -200,7 +355,7 @@ WHERE spans.trace_id = $1;
 	}
 	rows.Close()
 
-	rows, err = tx.Query(selectRelations, int64(id))
+	rows, err = tx.Query(selectRelations, int64(low), int64(high))
 	if err != nil {
 		return tracer.RawTrace{}, err
 	}
@@ -220,31 +375,66 @@ WHERE spans.trace_id = $1;
 	if err := rows.Err(); err != nil {
 		return tracer.RawTrace{}, err
 	}
+	setParents(spans, rels, high, low)
 	return tracer.RawTrace{
-		TraceID:   id,
-		Spans:     spans,
-		Relations: rels,
+		TraceID:     low,
+		TraceIDHigh: high,
+		Spans:       spans,
+		Relations:   rels,
 	}, nil
 }
 
+// setParents sets the references and parent IDs of spans from their
+// relations. The parent is the first ChildOf relation, or the first
+// relation if there are none, like in tracer.Tracer.StartSpan.
+func setParents(spans []tracer.RawSpan, rels []tracer.RawRelation, high, low uint64) {
+	idx := map[uint64]int{}
+	for i, sp := range spans {
+		idx[sp.SpanID] = i
+	}
+	childOf := map[uint64]bool{}
+	for _, rel := range rels {
+		i, ok := idx[rel.ChildID]
+		if !ok {
+			continue
+		}
+		sp := &spans[i]
+		sp.References = append(sp.References, tracer.RawReference{
+			TraceID:     low,
+			TraceIDHigh: high,
+			SpanID:      rel.ParentID,
+			Kind:        rel.Kind,
+		})
+		if sp.ParentID == 0 || (rel.Kind == tracer.RelationChildOf && !childOf[sp.SpanID]) {
+			sp.ParentID = rel.ParentID
+			childOf[sp.SpanID] = rel.Kind == tracer.RelationChildOf
+		}
+	}
+}
+
 func scanSpans(rows *sql.Rows) ([]tracer.RawSpan, error) {
 	var spans []tracer.RawSpan
 	var (
-		prevSpanID int64
+		prevSpanID   int64
+		prevLogIndex sql.NullInt64
 
 		spanID        int64
 		traceID       int64
+		traceIDHigh   int64
 		spanTime      timeRange
 		serviceName   string
 		operationName string
+		flags         int64
 		tagKey        sql.NullString
-		tagValue      sql.NullString
+		tagValues     tagValueColumns
 		tagTime       *time.Time
+		logIndex      sql.NullInt64
+		resource      []byte
 	)
 	tagTime = new(time.Time)
 	var span tracer.RawSpan
 	for rows.Next() {
-		if err := rows.Scan(&spanID, &traceID, &spanTime, &serviceName, &operationName, &tagKey, &tagValue, &tagTime); err != nil {
+		if err := rows.Scan(&spanID, &traceID, &traceIDHigh, &spanTime, &serviceName, &operationName, &flags, &tagKey, &tagValues.String, &tagValues.Number, &tagValues.Bool, &tagValues.Int, &tagValues.Uint, &tagTime, &logIndex, &resource); err != nil {
 			return nil, err
 		}
 		if spanID != prevSpanID {
@@ -252,26 +442,49 @@ func scanSpans(rows *sql.Rows) ([]tracer.RawSpan, error) {
 				spans = append(spans, span)
 			}
 			prevSpanID = spanID
+			prevLogIndex = sql.NullInt64{}
 			span = tracer.RawSpan{
 				Tags: map[string]interface{}{},
 			}
+			if resource != nil {
+				if err := json.Unmarshal(resource, &span.Resource); err != nil {
+					return nil, err
+				}
+			}
 		}
 		span.SpanID = uint64(spanID)
 		span.TraceID = uint64(traceID)
+		span.TraceIDHigh = uint64(traceIDHigh)
 		span.StartTime = spanTime.Start
 		span.FinishTime = spanTime.End
 		span.ServiceName = serviceName
 		span.OperationName = operationName
+		span.Flags = uint64(flags)
 		if tagKey.String != "" {
-			if tagTime == nil {
-				span.Tags[tagKey.String] = tagValue.String
-			} else {
-				span.Logs = append(span.Logs, opentracing.LogData{
+			value := tagValues.value()
+			switch {
+			case tagTime == nil:
+				span.Tags[tagKey.String] = value
+			case !logIndex.Valid:
+				// Log entries stored before structured logs
+				// consist of an event and a payload.
+				span.Logs = append(span.Logs, tracer.RawLog{
+					Timestamp: *tagTime,
+					Fields: []tracer.RawLogField{
+						{Key: "event", Value: tagKey.String},
+						{Key: "payload", Value: value},
+					},
+				})
+			case logIndex == prevLogIndex:
+				l := &span.Logs[len(span.Logs)-1]
+				l.Fields = append(l.Fields, tracer.RawLogField{Key: tagKey.String, Value: value})
+			default:
+				span.Logs = append(span.Logs, tracer.RawLog{
 					Timestamp: *tagTime,
-					Event:     tagKey.String,
-					Payload:   tagValue.String,
+					Fields:    []tracer.RawLogField{{Key: tagKey.String, Value: value}},
 				})
 			}
+			prevLogIndex = logIndex
 		}
 	}
 	if err := rows.Err(); err != nil {
@@ -295,10 +508,12 @@ func (st *Storage) SpanByID(id uint64) (tracer.RawSpan, error) {
 
 func (st *Storage) spanByID(tx *sql.Tx, id uint64) (tracer.RawSpan, error) {
 	const selectSpan = `
-SELECT spans.id, spans.trace_id, spans.time, spans.service_name, spans.operation_name, tags.key, tags.value, tags.time
+SELECT spans.id, spans.trace_id, spans.trace_id_high, spans.time, spans.service_name, spans.operation_name, spans.flags, tags.key, tags.value, tags.number_value, tags.bool_value, tags.int_value, tags.uint_value, tags.time, tags.log_index, resources.attributes
 FROM spans
   LEFT JOIN tags
     ON spans.id = tags.span_id
+  LEFT JOIN resources
+    ON spans.resource_id = resources.id
 WHERE spans.id = $1
 LIMIT 1`
 	rows, err := tx.Query(selectSpan, int64(id))
@@ -324,10 +539,6 @@ func (st *Storage) QueryTraces(q server.Query) ([]tracer.RawTrace, error) {
 	}
 	defer tx.Rollback()
 
-	var andConds []string
-	var andArgs []interface{}
-	var orConds []string
-	var orArgs []interface{}
 	if q.FinishTime.IsZero() {
 		q.FinishTime = time.Now()
 	}
@@ -337,35 +548,33 @@ func (st *Storage) QueryTraces(q server.Query) ([]tracer.RawTrace, error) {
 	if q.Num == 0 {
 		q.Num = 1<<31 - 1
 	}
+
+	// Each AND condition has to be met by a tag of its own, while
+	// one tag meeting any OR condition suffices.
+	const tagExists = `EXISTS ( SELECT 1 FROM tags WHERE tags.trace_id = spans.trace_id AND tags.trace_id_high = spans.trace_id_high AND %s) AND`
+	var tagQueries []string
+	var tagArgs []interface{}
 	for _, tag := range q.AndTags {
-		if tag.CheckValue {
-			andConds = append(andConds, `(tags.key = ? AND tags.value = ?)`)
-			andArgs = append(andArgs, tag.Key, tag.Value)
-		} else {
-			andConds = append(andConds, `(tags.key = ?)`)
-			andArgs = append(andArgs, tag.Key)
+		cond, args, err := tagCondition(tag)
+		if err != nil {
+			return nil, err
 		}
+		tagQueries = append(tagQueries, fmt.Sprintf(tagExists, cond))
+		tagArgs = append(tagArgs, args...)
 	}
-
+	var orConds []string
 	for _, tag := range q.OrTags {
-		if tag.CheckValue {
-			orConds = append(orConds, `(tags.key = ? AND tags.value = ?)`)
-			orArgs = append(orArgs, tag.Key, tag.Value)
-		} else {
-			orConds = append(orConds, `(tags.key = ?)`)
-			orArgs = append(orArgs, tag.Key)
+		cond, args, err := tagCondition(tag)
+		if err != nil {
+			return nil, err
 		}
+		orConds = append(orConds, cond)
+		tagArgs = append(tagArgs, args...)
 	}
-
-	and := strings.Join(andConds, " AND ")
-	or := strings.Join(orConds, " OR ")
-	conds := []string{"true"}
-	if and != "" {
-		conds = append(conds, and)
-	}
-	if or != "" {
-		conds = append(conds, or)
+	if len(orConds) > 0 {
+		tagQueries = append(tagQueries, fmt.Sprintf(tagExists, "("+strings.Join(orConds, " OR ")+")"))
 	}
+	tagQuery := strings.Join(tagQueries, "\n  ")
 
 	var serviceConds []string
 	var serviceNames []interface{}
@@ -377,75 +586,71 @@ func (st *Storage) QueryTraces(q server.Query) ([]tracer.RawTrace, error) {
 			serviceConds = append(serviceConds, "?")
 		}
 
-		serviceQuery = `EXISTS ( SELECT 1 FROM spans AS sub_spans WHERE sub_spans.trace_id = spans.trace_id AND sub_spans.service_name IN (` + strings.Join(serviceConds, ", ") + `)) AND`
+		serviceQuery = `EXISTS ( SELECT 1 FROM spans AS sub_spans WHERE sub_spans.trace_id = spans.trace_id AND sub_spans.trace_id_high = spans.trace_id_high AND sub_spans.service_name IN (` + strings.Join(serviceConds, ", ") + `)) AND`
 	}
 
-	var query string
-	if len(conds) == 1 {
-		query = st.db.Rebind(`
-SELECT sub.trace_id FROM (
-SELECT *
-FROM spans
-WHERE
-  ? @> spans.time AND
-  (? = '' OR operation_name = ?) AND
-  DURATION(time) >= ? AND
-  DURATION(time) <= ? AND
-  ` + serviceQuery + `
-  spans.id = spans.trace_id
-ORDER BY
-  spans.time DESC,
-  spans.trace_id
-LIMIT ?) AS sub
-ORDER BY sub.time ASC, sub.trace_id
-`)
-	} else {
-		query = st.db.Rebind(`
-SELECT sub.trace_id FROM (
+	var resourceConds []string
+	var resourceArgs []interface{}
+	var resourceQuery string
+	if len(q.Resource) > 0 {
+		var keys []string
+		for k := range q.Resource {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			resourceConds = append(resourceConds, "resources.attributes ->> ? = ?")
+			resourceArgs = append(resourceArgs, k, q.Resource[k])
+		}
+		resourceQuery = `EXISTS ( SELECT 1 FROM spans AS res_spans JOIN resources ON resources.id = res_spans.resource_id WHERE res_spans.trace_id = spans.trace_id AND res_spans.trace_id_high = spans.trace_id_high AND ` + strings.Join(resourceConds, " AND ") + `) AND`
+	}
+
+	var debugQuery string
+	if q.Debug {
+		debugQuery = fmt.Sprintf(`EXISTS ( SELECT 1 FROM spans AS debug_spans WHERE debug_spans.trace_id = spans.trace_id AND debug_spans.trace_id_high = spans.trace_id_high AND debug_spans.flags & %d <> 0) AND`, tracer.FlagDebug)
+	}
+
+	query := st.db.Rebind(`
+SELECT sub.trace_id_high, sub.trace_id FROM (
 SELECT *
 FROM spans
 WHERE
-  EXISTS (
-    SELECT 1
-    FROM tags
-    WHERE
-      tags.trace_id = spans.trace_id AND
-      ` + strings.Join(conds, " AND ") + `
-  ) AND
+  ` + tagQuery + `
   ? @> spans.time AND
   (? = '' OR operation_name = ?) AND
   DURATION(time) >= ? AND
   DURATION(time) <= ? AND
   ` + serviceQuery + `
-  spans.id = spans.trace_id
+  ` + resourceQuery + `
+  ` + debugQuery + `
+  ` + rootQuery + `
 ORDER BY
   spans.time DESC,
   spans.trace_id
 LIMIT ?) AS sub
 ORDER BY sub.time ASC, sub.trace_id
 `)
-	}
-	args := make([]interface{}, 0, len(andArgs)+len(orArgs))
-	args = append(args, andArgs...)
-	args = append(args, orArgs...)
+	args := make([]interface{}, 0, len(tagArgs)+len(serviceNames)+len(resourceArgs)+6)
+	args = append(args, tagArgs...)
 	args = append(args, timeRange{q.StartTime, q.FinishTime})
 	args = append(args, q.OperationName, q.OperationName)
 	args = append(args, int64(q.MinDuration), int64(q.MaxDuration))
 	args = append(args, serviceNames...)
+	args = append(args, resourceArgs...)
 	args = append(args, q.Num)
 
-	var ids []int64
+	var ids [][2]int64
 	rows, err := st.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var id int64
+	var high, low int64
 	for rows.Next() {
-		if err := rows.Scan(&id); err != nil {
+		if err := rows.Scan(&high, &low); err != nil {
 			return nil, err
 		}
-		ids = append(ids, id)
+		ids = append(ids, [2]int64{high, low})
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -453,7 +658,7 @@ ORDER BY sub.time ASC, sub.trace_id
 
 	var traces []tracer.RawTrace
 	for _, id := range ids {
-		trace, err := st.traceByID(tx, uint64(id))
+		trace, err := st.traceByID(tx, uint64(id[0]), uint64(id[1]))
 		if err != nil {
 			return nil, err
 		}
@@ -462,6 +667,27 @@ ORDER BY sub.time ASC, sub.trace_id
 	return traces, nil
 }
 
+// rootQuery matches root spans, which are spans without parents.
+const rootQuery = `NOT EXISTS ( SELECT 1 FROM relations WHERE relations.span2_id = spans.id)`
+
+func tagCondition(tag server.QueryTag) (string, []interface{}, error) {
+	switch {
+	case tag.Op != "":
+		switch tag.Op {
+		case "<", "<=", "=", ">=", ">":
+		default:
+			return "", nil, fmt.Errorf("unsupported comparison operator %q", tag.Op)
+		}
+		// Only tags are compared. Log fields have a log_index, and
+		// log entries of older clients only a time.
+		return `(tags.key = ? AND tags.number_value ` + tag.Op + ` ? AND tags.log_index IS NULL AND tags.time IS NULL)`, []interface{}{tag.Key, tag.Number}, nil
+	case tag.CheckValue:
+		return `(tags.key = ? AND tags.value = ?)`, []interface{}{tag.Key, tag.Value}, nil
+	default:
+		return `(tags.key = ?)`, []interface{}{tag.Key}, nil
+	}
+}
+
 // Services implements the server.Storage interface.
 func (st *Storage) Services() ([]string, error) {
 	const query = `SELECT DISTINCT service_name FROM spans ORDER BY service_name ASC`
@@ -524,14 +750,24 @@ func (st *Storage) Dependencies() ([]server.Dependency, error) {
 }
 
 func (st *Storage) Purge(before time.Time) error {
+	// Tags, logs and relations are deleted with their spans by
+	// ON DELETE CASCADE. tags.trace_id has no foreign key, because
+	// trace IDs needn't be the IDs of spans.
 	const query = `
-DELETE FROM spans WHERE trace_id IN (SELECT trace_id
+DELETE FROM spans WHERE (trace_id, trace_id_high) IN (SELECT trace_id, trace_id_high
 FROM spans
 WHERE
-  id = trace_id AND
+  ` + rootQuery + ` AND
   LOWER(time) < $1)
 `
 
-	_, err := st.db.Exec(query, before)
+	const deleteResources = `
+DELETE FROM resources WHERE NOT EXISTS (SELECT 1 FROM spans WHERE spans.resource_id = resources.id)
+`
+
+	if _, err := st.db.Exec(query, before); err != nil {
+		return err
+	}
+	_, err := st.db.Exec(deleteResources)
 	return err
 }
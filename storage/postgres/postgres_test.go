@@ -0,0 +1,427 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tracer/tracer"
+	"github.com/tracer/tracer/server"
+)
+
+// createTable extracts the column definitions of a table from
+// schema.sql.
+func createTable(t *testing.T, schema, table string) []string {
+	re := regexp.MustCompile(`(?s)CREATE TABLE ` + table + ` \((.*?)\n\);`)
+	m := re.FindStringSubmatch(schema)
+	if m == nil {
+		t.Fatalf("table %s not found in schema.sql", table)
+	}
+	var cols []string
+	for _, line := range strings.Split(m[1], "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			cols = append(cols, strings.TrimSuffix(line, ","))
+		}
+	}
+	return cols
+}
+
+func TestSchemaTagsTraceID(t *testing.T) {
+	b, err := ioutil.ReadFile("schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Trace IDs of root spans aren't span IDs, so tags.trace_id
+	// mustn't reference spans.
+	for _, col := range createTable(t, string(b), "tags") {
+		if strings.HasPrefix(col, "trace_id ") && strings.Contains(col, "REFERENCES") {
+			t.Errorf("tags.trace_id has a foreign key: %q", col)
+		}
+		if strings.HasPrefix(col, "span_id ") && !strings.Contains(col, "ON DELETE CASCADE") {
+			t.Errorf("tags.span_id isn't deleted with its span: %q", col)
+		}
+	}
+}
+
+// typedTags are tag values of all supported types, including
+// integers that can't be represented exactly as float64.
+var typedTags = map[string]interface{}{
+	"string":    "foo",
+	"bool":      true,
+	"float":     1.5,
+	"int":       int64(-42),
+	"big int":   int64(1<<53 + 1),
+	"max int":   int64(math.MaxInt64),
+	"min int":   int64(math.MinInt64),
+	"uint":      uint64(42),
+	"big uint":  uint64(1<<63 + 1),
+	"max uint":  uint64(math.MaxUint64),
+	"empty":     "",
+	"small int": int8(3),
+}
+
+func TestTagColumns(t *testing.T) {
+	for k, v := range typedTags {
+		want := v
+		if k == "small int" {
+			want = int64(3)
+		}
+		c := tagColumns(v)
+		if got := c.value(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %#v (%T), expected %#v (%T)", k, got, got, want, want)
+		}
+		if c.String.String != fmt.Sprintf("%v", v) {
+			t.Errorf("%s: got value column %q, expected %q", k, c.String.String, fmt.Sprintf("%v", v))
+		}
+	}
+	// Rows stored before integers had columns of their own.
+	old := tagValueColumns{
+		String: sql.NullString{String: "42", Valid: true},
+		Number: sql.NullFloat64{Float64: 42, Valid: true},
+	}
+	if got := old.value(); got != float64(42) {
+		t.Errorf("got %#v, expected float64(42)", got)
+	}
+}
+
+// testStorage returns a Storage backed by a fresh schema in the
+// database named by the TRACER_TEST_POSTGRES environment variable,
+// and a function that drops the schema. Tests that need it are
+// skipped if the variable isn't set.
+func testStorage(t *testing.T) (*Storage, func()) {
+	dsn := os.Getenv("TRACER_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("TRACER_TEST_POSTGRES not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// search_path is per connection.
+	db.SetMaxOpenConns(1)
+	name := fmt.Sprintf("tracer_test_%d", time.Now().UnixNano())
+	b, err := ioutil.ReadFile("schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		"CREATE SCHEMA " + name,
+		"SET search_path TO " + name + ", public",
+		string(b),
+	} {
+		if _, err := db.Exec(q); err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+	return New(db), func() {
+		_, _ = db.Exec("DROP SCHEMA " + name + " CASCADE")
+		db.Close()
+	}
+}
+
+func TestStoreRootSpanWithTags(t *testing.T) {
+	st, done := testStorage(t)
+	defer done()
+
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	root := tracer.RawSpan{
+		SpanContext:   tracer.SpanContext{TraceID: 100, SpanID: 1, Flags: tracer.FlagSampled},
+		ServiceName:   "frontend",
+		OperationName: "GET /",
+		StartTime:     start,
+		FinishTime:    start.Add(time.Second),
+		Tags:          typedTags,
+		Logs: []tracer.RawLog{{
+			Timestamp: start.Add(time.Millisecond),
+			Fields:    []tracer.RawLogField{{Key: "event", Value: "request"}},
+		}},
+	}
+	child := tracer.RawSpan{
+		SpanContext:   tracer.SpanContext{TraceID: 100, SpanID: 2, ParentID: 1, Flags: tracer.FlagSampled},
+		ServiceName:   "backend",
+		OperationName: "query",
+		StartTime:     start.Add(time.Millisecond),
+		FinishTime:    start.Add(2 * time.Millisecond),
+		Tags:          map[string]interface{}{"db.type": "sql"},
+		References: []tracer.RawReference{{
+			TraceID: 100, SpanID: 1, Kind: tracer.RelationChildOf,
+		}},
+	}
+	if err := st.StoreBatch([]tracer.RawSpan{child, root}); err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := st.TraceByID(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Spans) != 2 {
+		t.Fatalf("got %d spans, expected 2", len(trace.Spans))
+	}
+	got := trace.Spans[0]
+	if got.SpanID != 1 {
+		t.Errorf("got root span %d, expected 1", got.SpanID)
+	}
+	want := map[string]interface{}{}
+	for k, v := range typedTags {
+		want[k] = v
+	}
+	want["small int"] = int64(3)
+	if !reflect.DeepEqual(got.Tags, want) {
+		t.Errorf("got tags %#v, expected %#v", got.Tags, want)
+	}
+	if len(got.Logs) != 1 || got.Logs[0].Fields[0].Value != "request" {
+		t.Errorf("got logs %v", got.Logs)
+	}
+	if trace.Spans[1].ParentID != 1 {
+		t.Errorf("got parent %d, expected 1", trace.Spans[1].ParentID)
+	}
+
+	if err := st.Purge(start.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	trace, err = st.TraceByID(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Spans) != 0 {
+		t.Errorf("got %d spans after purging, expected 0", len(trace.Spans))
+	}
+}
+
+func TestStoreSpanTwice(t *testing.T) {
+	st, done := testStorage(t)
+	defer done()
+
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	sp := tracer.RawSpan{
+		SpanContext:   tracer.SpanContext{TraceID: 200, SpanID: 2, ParentID: 1, Flags: tracer.FlagSampled},
+		ServiceName:   "backend",
+		OperationName: "query",
+		StartTime:     start,
+		FinishTime:    start.Add(time.Second),
+		Tags:          map[string]interface{}{"db.type": "sql", tracer.UnfinishedTag: true},
+		Logs: []tracer.RawLog{{
+			Timestamp: start,
+			Fields:    []tracer.RawLogField{{Key: "event", Value: "query"}},
+		}},
+		References: []tracer.RawReference{{
+			TraceID: 200, SpanID: 1, Kind: tracer.RelationChildOf,
+		}},
+	}
+	// The span is stored unfinished by a flush first, then again
+	// once it finishes.
+	if err := st.Store(sp); err != nil {
+		t.Fatal(err)
+	}
+	sp.FinishTime = start.Add(2 * time.Second)
+	sp.Tags = map[string]interface{}{"db.type": "sql"}
+	if err := st.Store(sp); err != nil {
+		t.Fatal(err)
+	}
+
+	trace, err := st.TraceByID(0, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Relations) != 1 {
+		t.Errorf("got relations %v, expected 1", trace.Relations)
+	}
+	for _, s := range trace.Spans {
+		if s.SpanID != 2 {
+			continue
+		}
+		if !reflect.DeepEqual(s.Tags, sp.Tags) {
+			t.Errorf("got tags %v, expected %v", s.Tags, sp.Tags)
+		}
+		if len(s.Logs) != 1 || len(s.Logs[0].Fields) != 1 {
+			t.Errorf("got logs %v, expected a single entry with one field", s.Logs)
+		}
+		if !s.FinishTime.Equal(sp.FinishTime) {
+			t.Errorf("got finish time %s, expected %s", s.FinishTime, sp.FinishTime)
+		}
+	}
+}
+
+func TestQueryTraces(t *testing.T) {
+	st, done := testStorage(t)
+	defer done()
+
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	root := func(traceID uint64, tags map[string]interface{}, logs ...tracer.RawLogField) tracer.RawSpan {
+		sp := tracer.RawSpan{
+			SpanContext:   tracer.SpanContext{TraceID: traceID, SpanID: traceID * 10, Flags: tracer.FlagSampled},
+			ServiceName:   "frontend",
+			OperationName: "GET /",
+			StartTime:     start.Add(time.Duration(traceID) * time.Second),
+			FinishTime:    start.Add(time.Duration(traceID)*time.Second + time.Millisecond),
+			Tags:          tags,
+		}
+		if len(logs) > 0 {
+			sp.Logs = []tracer.RawLog{{Timestamp: sp.StartTime, Fields: logs}}
+		}
+		return sp
+	}
+	spans := []tracer.RawSpan{
+		root(1, map[string]interface{}{"http.status_code": 500, "error": true}),
+		root(2, map[string]interface{}{"http.status_code": 200}),
+		root(3, map[string]interface{}{"http.status_code": 503}),
+		root(4, map[string]interface{}{"component": "net/http"}, tracer.RawLogField{Key: "http.status_code", Value: 502}),
+	}
+	// The error of trace 3 is on a child span.
+	spans = append(spans, tracer.RawSpan{
+		SpanContext:   tracer.SpanContext{TraceID: 3, SpanID: 31, ParentID: 30, Flags: tracer.FlagSampled},
+		ServiceName:   "backend",
+		OperationName: "query",
+		StartTime:     spans[2].StartTime,
+		FinishTime:    spans[2].FinishTime,
+		Tags:          map[string]interface{}{"error": true},
+		References:    []tracer.RawReference{{TraceID: 3, SpanID: 30, Kind: tracer.RelationChildOf}},
+	})
+	if err := st.StoreBatch(spans); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		q    server.Query
+		want []uint64
+	}{
+		{"all", server.Query{}, []uint64{1, 2, 3, 4}},
+		{"and", server.Query{AndTags: []server.QueryTag{
+			{Key: "http.status_code", Op: ">=", Number: 500},
+			{Key: "error"},
+		}}, []uint64{1, 3}},
+		{"and value", server.Query{AndTags: []server.QueryTag{
+			{Key: "http.status_code", Value: "200", CheckValue: true},
+			{Key: "error"},
+		}}, nil},
+		{"or", server.Query{OrTags: []server.QueryTag{
+			{Key: "http.status_code", Op: "<", Number: 300},
+			{Key: "component"},
+		}}, []uint64{2, 4}},
+		{"and or", server.Query{
+			AndTags: []server.QueryTag{{Key: "error"}},
+			OrTags: []server.QueryTag{
+				{Key: "http.status_code", Op: "=", Number: 200},
+				{Key: "http.status_code", Op: "=", Number: 503},
+			},
+		}, []uint64{3}},
+		{"log fields", server.Query{AndTags: []server.QueryTag{
+			{Key: "http.status_code", Op: "=", Number: 502},
+		}}, nil},
+	}
+	for _, test := range tests {
+		test.q.StartTime = start
+		test.q.FinishTime = start.Add(time.Minute)
+		traces, err := st.QueryTraces(test.q)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		var got []uint64
+		for _, trace := range traces {
+			got = append(got, trace.TraceID)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got traces %v, expected %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestQueryTraces128Bit(t *testing.T) {
+	st, done := testStorage(t)
+	defer done()
+
+	// Two traces that share the low 64 bits of their IDs.
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	var spans []tracer.RawSpan
+	for i, high := range []uint64{1, 2} {
+		spans = append(spans, tracer.RawSpan{
+			SpanContext:   tracer.SpanContext{TraceID: 300, TraceIDHigh: high, SpanID: uint64(300 + i), Flags: tracer.FlagSampled},
+			ServiceName:   fmt.Sprintf("service%d", high),
+			OperationName: "op",
+			StartTime:     start,
+			FinishTime:    start.Add(time.Second),
+			Tags:          map[string]interface{}{"high": int64(high)},
+		})
+	}
+	spans[1].Flags |= tracer.FlagDebug
+	if err := st.StoreBatch(spans); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		q    server.Query
+		want uint64
+	}{
+		{"tag", server.Query{AndTags: []server.QueryTag{{Key: "high", Op: "=", Number: 2}}}, 2},
+		{"service", server.Query{ServiceNames: []string{"service1"}}, 1},
+		{"debug", server.Query{Debug: true}, 2},
+	}
+	for _, test := range tests {
+		test.q.StartTime = start
+		test.q.FinishTime = start.Add(time.Minute)
+		traces, err := st.QueryTraces(test.q)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(traces) != 1 || traces[0].TraceIDHigh != test.want {
+			t.Errorf("%s: got %d traces, expected only the one with high bits %d", test.name, len(traces), test.want)
+		}
+	}
+}
+
+func TestStoreBatchResources(t *testing.T) {
+	st, done := testStorage(t)
+	defer done()
+
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := map[string]interface{}{tracer.ResourceVersion: "1.2.3"}
+	var spans []tracer.RawSpan
+	for i := uint64(1); i <= 3; i++ {
+		spans = append(spans, tracer.RawSpan{
+			SpanContext:   tracer.SpanContext{TraceID: 400 + i, SpanID: 400 + i, Flags: tracer.FlagSampled},
+			ServiceName:   "frontend",
+			OperationName: "op",
+			StartTime:     start,
+			FinishTime:    start.Add(time.Second),
+			Resource:      res,
+		})
+	}
+	// An equal resource in a map of its own.
+	spans[2].Resource = map[string]interface{}{tracer.ResourceVersion: "1.2.3"}
+	if err := st.StoreBatch(spans); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := st.db.QueryRow(`SELECT COUNT(*) FROM resources`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d resources, expected 1", n)
+	}
+
+	traces, err := st.QueryTraces(server.Query{
+		StartTime:  start,
+		FinishTime: start.Add(time.Minute),
+		Resource:   map[string]string{tracer.ResourceVersion: "1.2.3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 3 {
+		t.Errorf("got %d traces, expected 3", len(traces))
+	}
+	if len(traces) > 0 && !reflect.DeepEqual(traces[0].Spans[0].Resource, res) {
+		t.Errorf("got resource %v, expected %v", traces[0].Spans[0].Resource, res)
+	}
+}
@@ -6,12 +6,22 @@ CREATE OR REPLACE FUNCTION duration(d tstzrange) RETURNS bigint
        IMMUTABLE
        RETURNS NULL ON NULL INPUT;
 
+CREATE TABLE resources (
+       id bigint PRIMARY KEY,
+       attributes jsonb NOT NULL
+);
+
+CREATE INDEX idx_resources_attributes ON resources USING gin (attributes);
+
 CREATE TABLE spans (
        id bigint PRIMARY KEY,
        trace_id bigint,
+       trace_id_high bigint NOT NULL DEFAULT 0,
        time tstzrange NOT NULL,
        service_name text NOT NULL,
-       operation_name text NOT NULL
+       operation_name text NOT NULL,
+       flags bigint NOT NULL DEFAULT 0,
+       resource_id bigint NULL REFERENCES resources
 );
 
 CREATE INDEX idx_spans_trace_id ON spans (trace_id);
@@ -20,18 +30,26 @@ CREATE INDEX idx_spans_operation_name ON spans (operation_name);
 
 CREATE TABLE tags (
        id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
-       trace_id bigint NOT NULL REFERENCES spans ON DELETE CASCADE,
+       trace_id bigint NOT NULL,
+       trace_id_high bigint NOT NULL DEFAULT 0,
        span_id bigint NOT NULL REFERENCES spans ON DELETE CASCADE,
        key text NOT NULL,
        value text NOT NULL,
-       time timestamp with time zone NULL
+       number_value double precision NULL,
+       bool_value boolean NULL,
+       int_value bigint NULL,
+       uint_value bigint NULL,
+       time timestamp with time zone NULL,
+       log_index integer NULL,
+       position integer NULL
 );
 
-CREATE INDEX idx_tags_trace_id ON tags (trace_id);
+CREATE INDEX idx_tags_trace_id ON tags (trace_id, trace_id_high);
 CREATE INDEX idx_tags_span_id ON tags (span_id);
 CREATE INDEX idx_tags_key_value ON tags (key, value);
+CREATE INDEX idx_tags_key_number_value ON tags (key, number_value);
 
-CREATE TYPE relation AS ENUM ('parent');
+CREATE TYPE relation AS ENUM ('parent', 'follows_from');
 
 CREATE TABLE relations (
        id uuid PRIMARY KEY DEFAULT uuid_generate_v4(),
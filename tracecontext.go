@@ -0,0 +1,205 @@
+package tracer
+
+import (
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// The W3C Trace Context headers, as described by
+// https://www.w3.org/TR/trace-context/.
+const (
+	traceParentHeader = "traceparent"
+	traceStateHeader  = "tracestate"
+
+	traceContextVersion = "00"
+	traceContextSampled = 0x01
+
+	// The maximum number of list members in tracestate.
+	maxTraceStateMembers = 32
+)
+
+// TraceContextInjecter is an Injecter for the W3C Trace Context
+// format. It sets the traceparent header, and the tracestate header
+// if the span context carries trace state. Baggage is not
+// propagated.
+//
+// It may be registered for opentracing.HTTPHeaders to make the
+// tracer speak W3C Trace Context by default.
+func TraceContextInjecter(sm SpanContext, carrier interface{}) error {
+	w, ok := carrier.(opentracing.TextMapWriter)
+	if !ok {
+		return opentracing.ErrInvalidCarrier
+	}
+	flags := "00"
+	if sm.Flags&FlagSampled != 0 {
+		flags = "01"
+	}
+	w.Set(traceParentHeader, traceContextVersion+"-"+
+		idToHex(sm.TraceIDHigh)+idToHex(sm.TraceID)+"-"+
+		idToHex(sm.SpanID)+"-"+flags)
+	if sm.TraceState != "" {
+		w.Set(traceStateHeader, sm.TraceState)
+	}
+	return nil
+}
+
+// TraceContextExtracter is an Extracter for the W3C Trace Context
+// format. It returns opentracing.ErrSpanContextNotFound if there is
+// no traceparent header and opentracing.ErrSpanContextCorrupted if
+// the header is malformed. Invalid tracestate headers are discarded.
+func TraceContextExtracter(carrier interface{}) (SpanContext, error) {
+	r, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return SpanContext{}, opentracing.ErrInvalidCarrier
+	}
+	var parents, states []string
+	err := r.ForeachKey(func(key string, val string) error {
+		switch strings.ToLower(key) {
+		case traceParentHeader:
+			parents = append(parents, val)
+		case traceStateHeader:
+			states = append(states, val)
+		}
+		return nil
+	})
+	if err != nil {
+		return SpanContext{}, err
+	}
+	switch len(parents) {
+	case 0:
+		return SpanContext{}, opentracing.ErrSpanContextNotFound
+	case 1:
+	default:
+		return SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	ctx, ok := parseTraceParent(parents[0])
+	if !ok {
+		return SpanContext{}, opentracing.ErrSpanContextCorrupted
+	}
+	ctx.TraceState, _ = parseTraceState(states)
+	return ctx, nil
+}
+
+// parseTraceParent parses a traceparent header.
+func parseTraceParent(s string) (SpanContext, bool) {
+	s = strings.Trim(s, " \t")
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	const length = 2 + 1 + 32 + 1 + 16 + 1 + 2
+	if len(s) < length ||
+		s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return SpanContext{}, false
+	}
+	version := s[:2]
+	if !isLowerHex(version) || version == "ff" {
+		return SpanContext{}, false
+	}
+	if version == traceContextVersion {
+		if len(s) != length {
+			return SpanContext{}, false
+		}
+	} else if len(s) > length && s[length] != '-' {
+		// Future versions may append fields, but have to keep the
+		// existing ones intact.
+		return SpanContext{}, false
+	}
+	traceID, spanID, flags := s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(traceID) || !isLowerHex(spanID) || !isLowerHex(flags) {
+		return SpanContext{}, false
+	}
+	ctx := SpanContext{
+		TraceIDHigh: idFromHex(traceID[:16]),
+		TraceID:     idFromHex(traceID[16:]),
+		SpanID:      idFromHex(spanID),
+		Baggage:     map[string]string{},
+	}
+	if (ctx.TraceIDHigh == 0 && ctx.TraceID == 0) || ctx.SpanID == 0 {
+		return SpanContext{}, false
+	}
+	if hexValue(flags[1])&traceContextSampled != 0 {
+		ctx.Flags |= FlagSampled
+	}
+	return ctx, true
+}
+
+// parseTraceState combines and validates tracestate headers. It
+// returns false if the headers aren't valid, in which case they
+// should be discarded.
+func parseTraceState(headers []string) (string, bool) {
+	var members []string
+	keys := map[string]bool{}
+	for _, header := range headers {
+		for _, member := range strings.Split(header, ",") {
+			member = strings.Trim(member, " \t")
+			if member == "" {
+				continue
+			}
+			idx := strings.IndexByte(member, '=')
+			if idx == -1 {
+				return "", false
+			}
+			key, value := member[:idx], member[idx+1:]
+			if !validTraceStateKey(key) || !validTraceStateValue(value) || keys[key] {
+				return "", false
+			}
+			keys[key] = true
+			members = append(members, member)
+		}
+	}
+	if len(members) > maxTraceStateMembers {
+		return "", false
+	}
+	return strings.Join(members, ","), true
+}
+
+func validTraceStateKey(key string) bool {
+	if len(key) == 0 || len(key) > 256 {
+		return false
+	}
+	if c := key[0]; !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') {
+		return false
+	}
+	at := false
+	for i := 1; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '_', c == '-', c == '*', c == '/':
+		case c == '@' && !at && i < len(key)-1:
+			at = true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validTraceStateValue(value string) bool {
+	if len(value) == 0 || len(value) > 256 {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return value[len(value)-1] != ' '
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func hexValue(c byte) byte {
+	if c >= 'a' {
+		return c - 'a' + 10
+	}
+	return c - '0'
+}
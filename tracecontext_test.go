@@ -0,0 +1,187 @@
+package tracer
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+func TestTraceContextRoundTrip(t *testing.T) {
+	sm := SpanContext{
+		TraceIDHigh: 0x4bf92f3577b34da6,
+		TraceID:     0xa3ce929d0e0e4736,
+		SpanID:      0x00f067aa0ba902b7,
+		Flags:       FlagSampled,
+		TraceState:  "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7",
+	}
+	carrier := opentracing.TextMapCarrier{}
+	if err := TraceContextInjecter(sm, carrier); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if got, want := carrier["traceparent"], "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"; got != want {
+		t.Errorf("got traceparent %q, want %q", got, want)
+	}
+	if got, want := carrier["tracestate"], sm.TraceState; got != want {
+		t.Errorf("got tracestate %q, want %q", got, want)
+	}
+	context, err := TraceContextExtracter(carrier)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if context.TraceIDHigh != sm.TraceIDHigh ||
+		context.TraceID != sm.TraceID ||
+		context.SpanID != sm.SpanID ||
+		context.Flags != sm.Flags ||
+		context.TraceState != sm.TraceState {
+
+		t.Errorf("got %+v, want %+v", context, sm)
+	}
+
+	sm.Flags = 0
+	sm.TraceIDHigh = 0
+	carrier = opentracing.TextMapCarrier{}
+	if err := TraceContextInjecter(sm, carrier); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if got, want := carrier["traceparent"], "00-0000000000000000a3ce929d0e0e4736-00f067aa0ba902b7-00"; got != want {
+		t.Errorf("got traceparent %q, want %q", got, want)
+	}
+}
+
+func TestTraceParent(t *testing.T) {
+	tests := []struct {
+		in      string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\t", true, true},
+		// Unknown flags are ignored.
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-ff", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-02", true, false},
+		// Future versions may append fields.
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-the-future-will-be-like", true, true},
+
+		{"", false, false},
+		{"00", false, false},
+		// Version 00 doesn't allow additional fields.
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.what", false, false},
+		// Version ff is invalid.
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		// Uppercase hex digits are invalid.
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00F067AA0BA902B7-01", false, false},
+		{"0A-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		// All-zero IDs are invalid.
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		// Wrong lengths and delimiters.
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47366-0f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1", false, false},
+		{"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-.1", false, false},
+	}
+	for _, tt := range tests {
+		carrier := opentracing.TextMapCarrier{"traceparent": tt.in}
+		context, err := TraceContextExtracter(carrier)
+		if !tt.ok {
+			if err != opentracing.ErrSpanContextCorrupted {
+				t.Errorf("%q: got error %v, want %v", tt.in, err, opentracing.ErrSpanContextCorrupted)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.in, err)
+			continue
+		}
+		if context.TraceIDHigh != 0x4bf92f3577b34da6 ||
+			context.TraceID != 0xa3ce929d0e0e4736 ||
+			context.SpanID != 0x00f067aa0ba902b7 {
+			t.Errorf("%q: got IDs (%x, %x, %x)", tt.in, context.TraceIDHigh, context.TraceID, context.SpanID)
+		}
+		if sampled := context.Flags&FlagSampled != 0; sampled != tt.sampled {
+			t.Errorf("%q: got sampled = %t, want %t", tt.in, sampled, tt.sampled)
+		}
+	}
+}
+
+func TestTraceContextHeaders(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	if _, err := TraceContextExtracter(opentracing.HTTPHeadersCarrier{}); err != opentracing.ErrSpanContextNotFound {
+		t.Errorf("got error %v, want %v", err, opentracing.ErrSpanContextNotFound)
+	}
+
+	h := http.Header{}
+	h.Add("Traceparent", parent)
+	h.Add("Traceparent", parent)
+	if _, err := TraceContextExtracter(opentracing.HTTPHeadersCarrier(h)); err != opentracing.ErrSpanContextCorrupted {
+		t.Errorf("multiple traceparent headers: got error %v, want %v", err, opentracing.ErrSpanContextCorrupted)
+	}
+
+	tests := []struct {
+		states []string
+		want   string
+	}{
+		{nil, ""},
+		{[]string{"rojo=00f067aa0ba902b7"}, "rojo=00f067aa0ba902b7"},
+		{[]string{"rojo=00f067aa0ba902b7", "congo=t61rcWkgMzE"}, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"},
+		{[]string{"rojo=00f067aa0ba902b7 ,, congo=t61rcWkgMzE"}, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"},
+		{[]string{"tenant@vendor=1,a*b/c_d-e=x"}, "tenant@vendor=1,a*b/c_d-e=x"},
+		// Invalid tracestate headers are discarded.
+		{[]string{"rojo"}, ""},
+		{[]string{"Rojo=1"}, ""},
+		{[]string{"rojo=1,rojo=2"}, ""},
+		{[]string{"rojo=a=b"}, ""},
+		{[]string{"@vendor=1"}, ""},
+		{[]string{"a=1,b=1,c=1,d=1,e=1,f=1,g=1,h=1,i=1,j=1,k=1,l=1,m=1,n=1,o=1,p=1," +
+			"q=1,r=1,s=1,t=1,u=1,v=1,w=1,x=1,y=1,z=1,0=1,1=1,2=1,3=1,4=1,5=1,6=1"}, ""},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		h.Set("Traceparent", parent)
+		for _, s := range tt.states {
+			h.Add("Tracestate", s)
+		}
+		context, err := TraceContextExtracter(opentracing.HTTPHeadersCarrier(h))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.states, err)
+			continue
+		}
+		if context.TraceState != tt.want {
+			t.Errorf("%q: got tracestate %q, want %q", tt.states, context.TraceState, tt.want)
+		}
+	}
+}
+
+func TestTraceContextTracer(t *testing.T) {
+	tr := NewTracer("", &recordingStorer{}, RandomID{})
+	carrier := opentracing.TextMapCarrier{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"tracestate":  "rojo=00f067aa0ba902b7",
+	}
+	parent, err := tr.Extract(FormatTraceContext, carrier)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	sp := tr.StartSpan("child", opentracing.ChildOf(parent))
+	out := opentracing.TextMapCarrier{}
+	if err := tr.Inject(sp.Context(), FormatTraceContext, out); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	child := sp.Context().(SpanContext)
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + idToHex(child.SpanID) + "-01"
+	if out["traceparent"] != want {
+		t.Errorf("got traceparent %q, want %q", out["traceparent"], want)
+	}
+	if out["tracestate"] != "rojo=00f067aa0ba902b7" {
+		t.Errorf("got tracestate %q, want %q", out["tracestate"], "rojo=00f067aa0ba902b7")
+	}
+}
@@ -13,6 +13,40 @@
 // Only root spans make sampling decisions. Child spans will inherit
 // the sampling decisions of the root spans.
 //
+// The ext.SamplingPriority tag overrides sampling decisions, both as
+// a start tag and when set later on with SetTag. This allows
+// escalating tracing when a request runs into an error.
+//
+// Debug traces, marked with FlagDebug, are always sampled. A request
+// can force a debug trace by sending the header configured in
+// Tracer.DebugHeader, or via B3's debug flag.
+//
+// Samplers that implement SpanSampler have access to the service
+// name, operation name and start tags of a span and can record the
+// reasons for their decisions as tags. Samplers can be combined with
+// NewFirstMatchSampler, NewOperationSampler, NewAndSampler and
+// NewOrSampler. NewAdaptiveSampler adjusts a separate probability
+// for each operation to sample a target number of traces per second.
+// NewRemoteSampler fetches sampling strategies from the Tracer server.
+//
+// Baggage
+//
+// Baggage items are propagated to all children of a span and to all
+// downstream services. Tracer.BaggageLimits restricts their size and
+// keys.
+//
+// Processors
+//
+// Tracer.Processors are called when spans start and finish, and can
+// enrich or redact spans before they are stored. Finished spans are
+// processed and stored in a separate goroutine.
+//
+// Storing spans
+//
+// Storers may be slow or unavailable. Wrapping a storer in a
+// BatchExporter stores spans in batches in the background, dropping
+// spans instead of blocking when it falls behind.
+//
 // Errors and logging
 //
 // The instrumentation is defensive and will never purposefully panic.
@@ -25,21 +59,37 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
 	"log"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
 )
 
 // The various flags of a Span.
 const (
 	// The Span has been sampled.
 	FlagSampled = 1 << iota
+	// The trace has been forced by a debug request. Debug spans are
+	// always sampled, regardless of samplers, sampling priorities
+	// and tail sampling policies.
+	FlagDebug
+	// The caller deferred the sampling decision, for example by
+	// omitting B3's X-B3-Sampled header. Children of such span
+	// contexts are sampled by the tracer's sampler as if they were
+	// root spans. Spans never have this flag.
+	FlagSamplingDeferred
 )
 
+// DefaultDebugHeader is the default value of Tracer.DebugHeader.
+const DefaultDebugHeader = "tracer-debug"
+
 // A Logger logs messages.
 type Logger interface {
 	// Printf logs a single message, given a format and values. The
@@ -78,11 +128,22 @@ func valueType(v interface{}) (string, bool) {
 
 // A RawTrace contains all the data associated with a trace.
 type RawTrace struct {
-	TraceID   uint64        `json:"trace_id"`
-	Spans     []RawSpan     `json:"spans"`
-	Relations []RawRelation `json:"relations"`
+	TraceID     uint64        `json:"trace_id"`
+	TraceIDHigh uint64        `json:"trace_id_high"`
+	Spans       []RawSpan     `json:"spans"`
+	Relations   []RawRelation `json:"relations"`
 }
 
+// The kinds of relations between two spans.
+const (
+	// The child span is a child of the parent span, i.e. the parent
+	// depends on the child's result.
+	RelationChildOf = "parent"
+	// The child span follows from the parent span, which doesn't
+	// depend on the child's result.
+	RelationFollowsFrom = "follows_from"
+)
+
 // A RawRelation represents the relation between two spans.
 type RawRelation struct {
 	ParentID uint64 `json:"parent_id"`
@@ -90,6 +151,31 @@ type RawRelation struct {
 	Kind     string `json:"kind"`
 }
 
+// A RawReference is a reference from a span to one of the spans it
+// was caused by.
+type RawReference struct {
+	TraceID     uint64 `json:"trace_id"`
+	TraceIDHigh uint64 `json:"trace_id_high"`
+	SpanID      uint64 `json:"span_id"`
+	// The kind of relation, one of RelationChildOf and
+	// RelationFollowsFrom.
+	Kind string `json:"kind"`
+}
+
+// A RawLog is a structured log entry of a span.
+type RawLog struct {
+	Timestamp time.Time `json:"timestamp"`
+	// The fields of the entry, in the order they were logged.
+	Fields []RawLogField `json:"fields"`
+}
+
+// A RawLogField is a single key/value pair of a log entry. Values
+// have the same types as tag values.
+type RawLogField struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
 // Span is an implementation of the OpenTracing Span interface.
 type Span struct {
 	mu     sync.RWMutex
@@ -106,20 +192,40 @@ type RawSpan struct {
 	FinishTime    time.Time `json:"finish_time"`
 
 	Tags map[string]interface{} `json:"tags"`
-	Logs []opentracing.LogData  `json:"logs"`
+	Logs []RawLog               `json:"logs"`
+
+	// Resource contains the attributes of the process that created
+	// the span. It is shared by all spans of a tracer and must not be
+	// modified.
+	Resource map[string]interface{} `json:"resource"`
+
+	// References contains all spans this span references, including
+	// the one identified by ParentID.
+	References []RawReference `json:"references"`
 }
 
 // RawSpan returns a deep copy of the span's underlying data.
 func (sp *Span) RawSpan() RawSpan {
 	sp.mu.Lock()
 	defer sp.mu.Unlock()
-	raw := sp.raw
+	return sp.raw.copy()
+}
+
+func (raw RawSpan) copy() RawSpan {
 	tags := raw.Tags
 	raw.Tags = map[string]interface{}{}
 	for k, v := range tags {
 		raw.Tags[k] = v
 	}
-	raw.Logs = append([]opentracing.LogData(nil), raw.Logs...)
+	logs := raw.Logs
+	raw.Logs = nil
+	for _, l := range logs {
+		raw.Logs = append(raw.Logs, RawLog{
+			Timestamp: l.Timestamp,
+			Fields:    append([]RawLogField(nil), l.Fields...),
+		})
+	}
+	raw.References = append([]RawReference(nil), raw.References...)
 	baggage := raw.Baggage
 	raw.Baggage = map[string]string{}
 	for k, v := range baggage {
@@ -148,9 +254,19 @@ func (sp *Span) SetOperationName(name string) opentracing.Span {
 }
 
 // SetTag implements the opentracing.Span interface.
+//
+// Setting ext.SamplingPriority to a value greater than zero samples
+// an unsampled span, setting it to zero stops a sampled span from
+// being recorded. The change is visible in the span's context and
+// thus affects child spans started and contexts injected afterwards.
 func (sp *Span) SetTag(key string, value interface{}) opentracing.Span {
 	sp.mu.Lock()
 	defer sp.mu.Unlock()
+	if key == string(ext.SamplingPriority) {
+		if n, ok := samplingPriority(value); ok {
+			sp.setSamplingPriority(n)
+		}
+	}
 	if !sp.sampled() {
 		return sp
 	}
@@ -165,42 +281,169 @@ func (sp *Span) SetTag(key string, value interface{}) opentracing.Span {
 	return sp
 }
 
+func (sp *Span) setSamplingPriority(n int64) {
+	if n > 0 {
+		sp.raw.Flags |= FlagSampled
+	} else if sp.raw.Flags&FlagDebug == 0 {
+		sp.raw.Flags &^= FlagSampled
+	}
+}
+
+// samplingPriority returns the value of a sampling priority tag. It
+// accepts all integer types.
+func samplingPriority(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
 // SetBaggageItem implements the opentracing.Tracer interface.
+//
+// Items that violate the tracer's BaggageLimits are rejected. Setting
+// an item doesn't affect the baggage of span contexts returned by
+// Context earlier, or of children started earlier.
 func (sp *Span) SetBaggageItem(key, value string) opentracing.Span {
-	sp.raw.SpanContext.Baggage[key] = value
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if reason := sp.tracer.BaggageLimits.check(sp.raw.Baggage, key, value); reason != "" {
+		rejectedBaggage.WithLabelValues(reason).Inc()
+		sp.logFields(time.Time{}, []otlog.Field{
+			otlog.String("event", "baggage item rejected"),
+			otlog.String("key", key),
+			otlog.String("reason", reason),
+		})
+		return sp
+	}
+	sp.raw.Baggage = withBaggageItem(sp.raw.Baggage, key, value)
 	return sp
 }
 
 // BaggageItem implements the opentracing.Tracer interface.
 func (sp *Span) BaggageItem(key string) string {
-	return sp.raw.SpanContext.Baggage[key]
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
+	return sp.raw.Baggage[key]
 }
 
 // Finish implements the opentracing.Span interface.
 func (sp *Span) Finish() {
-	if !sp.Sampled() {
-		return
-	}
 	sp.FinishWithOptions(opentracing.FinishOptions{})
 }
 
 // FinishWithOptions implements the opentracing.Span interface.
+//
+// The span is stored after the span's lock has been released, via
+// the tracer's processors if it has any.
 func (sp *Span) FinishWithOptions(opts opentracing.FinishOptions) {
+	if sp.tracer.SpanTracker != nil {
+		sp.tracer.SpanTracker.remove(sp)
+	}
 	sp.mu.Lock()
-	defer sp.mu.Unlock()
 	if !sp.sampled() {
+		sp.mu.Unlock()
 		return
 	}
 	if opts.FinishTime.IsZero() {
-		opts.FinishTime = time.Now()
+		opts.FinishTime = sp.tracer.now()
 	}
 	sp.raw.FinishTime = opts.FinishTime
+	for _, rec := range opts.LogRecords {
+		sp.logFields(rec.Timestamp, rec.Fields)
+	}
 	for _, log := range opts.BulkLogData {
 		sp.log(log)
 	}
-	if err := sp.tracer.storer.Store(sp.raw); err != nil {
-		sp.tracer.Logger.Printf("error while storing tracing span: %s", err)
+	raw := sp.raw.copy()
+	sp.mu.Unlock()
+	sp.tracer.finish(raw)
+}
+
+// LogFields implements the opentracing.Span interface.
+func (sp *Span) LogFields(fields ...otlog.Field) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.logFields(time.Time{}, fields)
+}
+
+// LogKV implements the opentracing.Span interface.
+func (sp *Span) LogKV(alternatingKeyValues ...interface{}) {
+	if !sp.Sampled() {
+		return
+	}
+	fields, err := otlog.InterleavedKVToFields(alternatingKeyValues...)
+	if err != nil {
+		sp.tracer.Logger.Printf("invalid log key/value pairs: %s", err)
+		return
+	}
+	sp.LogFields(fields...)
+}
+
+func (sp *Span) logFields(t time.Time, fields []otlog.Field) {
+	if !sp.sampled() {
+		return
+	}
+	enc := &fieldEncoder{logger: sp.tracer.Logger}
+	for _, field := range fields {
+		field.Marshal(enc)
+	}
+	if len(enc.fields) == 0 {
+		return
+	}
+	if t.IsZero() {
+		t = sp.tracer.now()
+	}
+	sp.raw.Logs = append(sp.raw.Logs, RawLog{Timestamp: t, Fields: enc.fields})
+}
+
+// fieldEncoder converts OpenTracing log fields to RawLogFields.
+// Objects of unsupported types are logged and dropped.
+type fieldEncoder struct {
+	logger Logger
+	fields []RawLogField
+}
+
+func (enc *fieldEncoder) emit(key string, value interface{}) {
+	enc.fields = append(enc.fields, RawLogField{Key: key, Value: value})
+}
+
+func (enc *fieldEncoder) EmitString(key, value string)          { enc.emit(key, value) }
+func (enc *fieldEncoder) EmitBool(key string, value bool)       { enc.emit(key, value) }
+func (enc *fieldEncoder) EmitInt(key string, value int)         { enc.emit(key, value) }
+func (enc *fieldEncoder) EmitInt32(key string, value int32)     { enc.emit(key, value) }
+func (enc *fieldEncoder) EmitInt64(key string, value int64)     { enc.emit(key, value) }
+func (enc *fieldEncoder) EmitUint32(key string, value uint32)   { enc.emit(key, value) }
+func (enc *fieldEncoder) EmitUint64(key string, value uint64)   { enc.emit(key, value) }
+func (enc *fieldEncoder) EmitFloat32(key string, value float32) { enc.emit(key, value) }
+func (enc *fieldEncoder) EmitFloat64(key string, value float64) { enc.emit(key, value) }
+func (enc *fieldEncoder) EmitLazyLogger(value otlog.LazyLogger) { value(enc) }
+
+func (enc *fieldEncoder) EmitObject(key string, value interface{}) {
+	if _, ok := valueType(value); !ok {
+		enc.logger.Printf("unsupported value type for log field %q: %T", key, value)
+		return
 	}
+	enc.emit(key, value)
 }
 
 // LogEvent implements the opentracing.Span interface.
@@ -231,6 +474,8 @@ func (sp *Span) Log(data opentracing.LogData) {
 	sp.log(data)
 }
 
+// log records a legacy log entry as an entry with an event field and
+// an optional payload field.
 func (sp *Span) log(data opentracing.LogData) {
 	if !sp.sampled() {
 		return
@@ -240,9 +485,10 @@ func (sp *Span) log(data opentracing.LogData) {
 		return
 	}
 	if data.Timestamp.IsZero() {
-		data.Timestamp = time.Now()
+		data.Timestamp = sp.tracer.now()
 	}
-	sp.raw.Logs = append(sp.raw.Logs, data)
+	rec := data.ToLogRecord()
+	sp.logFields(rec.Timestamp, rec.Fields)
 }
 
 // Context implements the opentracing.Span interface.
@@ -264,9 +510,51 @@ type Tracer struct {
 	ServiceName string
 	Logger      Logger
 	Sampler     Sampler
+	// The propagation formats supported by Inject and Extract. If
+	// nil, the default formats will be used.
+	Propagation *Propagation
+	// If a carrier passed to Extract contains this header, the
+	// extracted span context will have FlagDebug set. If the carrier
+	// contains no span context, a span context without IDs is
+	// returned; spans started with a reference to it will be root
+	// spans of a debug trace. Empty disables the header.
+	DebugHeader string
+	// Limits on the baggage items set via Span.SetBaggageItem. There
+	// are no limits by default. Baggage received from upstream
+	// services is propagated unchanged.
+	BaggageLimits BaggageLimits
+	// Returns the current time, used for the timestamps of spans and
+	// log entries that don't specify one. If nil, time.Now is used.
+	Now func() time.Time
+	// Attributes of the process, such as its hostname, version and
+	// environment, that are attached to all spans. Resource
+	// attributes are sent once per batch instead of once per span.
+	// Values have the same types as tag values. NewTracer
+	// initializes it with DefaultResource. It must not be modified
+	// after starting the first span.
+	Resource map[string]interface{}
+	// Processors are called in order when sampled spans start and
+	// finish. Set them before starting the first span.
+	Processors []SpanProcessor
+	// The number of finished spans that may wait for processors
+	// before new spans are dropped. If zero,
+	// DefaultProcessorQueueSize is used.
+	ProcessorQueueSize int
+	// If not nil, sampled spans are tracked until they finish, and
+	// Flush stores spans that haven't finished yet with
+	// UnfinishedTag.
+	SpanTracker *SpanTracker
 
 	storer      Storer
 	idGenerator IDGenerator
+
+	processorOnce sync.Once
+	processorCh   chan processorItem
+	processorDone chan struct{}
+	// processorMu guards sending on processorCh against Close
+	// closing it.
+	processorMu     sync.RWMutex
+	processorClosed bool
 }
 
 // NewTracer returns a new tracer.
@@ -275,6 +563,9 @@ func NewTracer(serviceName string, storer Storer, idGenerator IDGenerator) *Trac
 		ServiceName: serviceName,
 		Logger:      defaultLogger{},
 		Sampler:     NewConstSampler(true),
+		Propagation: NewPropagation(),
+		DebugHeader: DefaultDebugHeader,
+		Resource:    DefaultResource(),
 		storer:      storer,
 		idGenerator: idGenerator,
 	}
@@ -287,44 +578,164 @@ func (tr *Tracer) StartSpan(operationName string, opts ...opentracing.StartSpanO
 		opt.Apply(&sopts)
 	}
 	if sopts.StartTime.IsZero() {
-		sopts.StartTime = time.Now()
+		sopts.StartTime = tr.now()
 	}
 
-	id := tr.idGenerator.GenerateID()
 	sp := &Span{
 		tracer: tr,
 		raw: RawSpan{
 			SpanContext: SpanContext{
-				SpanID:  id,
-				TraceID: id,
+				SpanID: tr.idGenerator.GenerateID(),
 			},
 			ServiceName:   tr.ServiceName,
 			OperationName: operationName,
 			StartTime:     sopts.StartTime,
+			Resource:      tr.Resource,
 		},
 	}
-	if len(sopts.References) > 0 {
-		// TODO(dh): support multiple parents, support ChildOf and
-		// FollowsFrom as separate kinds of relations.
-		parent, ok := sopts.References[0].ReferencedContext.(SpanContext)
+	sp.raw.Tags = sopts.Tags
+	// The first ChildOf reference, or the first reference if there
+	// are none, determines the parent of the span.
+	var parent SpanContext
+	var haveChildOf bool
+	var debug bool
+	for _, ref := range sopts.References {
+		context, ok := tr.spanContext(ref.ReferencedContext)
 		if !ok {
-			panic("parent span must be of type *Span")
+			// Span contexts extracted from only a debug header have
+			// no IDs but still start a debug trace.
+			debug = debug || context.Flags&FlagDebug != 0
+			continue
+		}
+		kind := RelationFollowsFrom
+		if ref.Type == opentracing.ChildOfRef {
+			kind = RelationChildOf
+		}
+		// Children share their parents' baggage until they set
+		// items of their own.
+		if sp.raw.Baggage == nil {
+			sp.raw.Baggage = context.Baggage
+		} else {
+			for k, v := range context.Baggage {
+				sp.raw.Baggage = withBaggageItem(sp.raw.Baggage, k, v)
+			}
 		}
+		sp.raw.References = append(sp.raw.References, RawReference{
+			TraceID:     context.TraceID,
+			TraceIDHigh: context.TraceIDHigh,
+			SpanID:      context.SpanID,
+			Kind:        kind,
+		})
+		if len(sp.raw.References) == 1 || (kind == RelationChildOf && !haveChildOf) {
+			parent = context
+			haveChildOf = kind == RelationChildOf
+		}
+	}
+	if len(sp.raw.References) > 0 {
 		sp.raw.ParentID = parent.SpanID
 		sp.raw.TraceID = parent.TraceID
-		sp.raw.Flags = parent.Flags
+		sp.raw.TraceIDHigh = parent.TraceIDHigh
+		sp.raw.TraceState = parent.TraceState
+		sp.raw.Flags = parent.Flags &^ FlagSamplingDeferred
 	} else {
-		if n, _ := sopts.Tags[string(ext.SamplingPriority)].(uint16); n > 0 {
-			sp.raw.Flags |= FlagSampled
-		} else if tr.Sampler.Sample(id) {
+		sp.raw.TraceID = tr.generateTraceID()
+	}
+	if debug {
+		sp.raw.Flags |= FlagDebug
+	}
+	if sp.raw.Flags&FlagDebug != 0 {
+		sp.raw.Flags |= FlagSampled
+	} else if n, ok := samplingPriority(sopts.Tags[string(ext.SamplingPriority)]); ok {
+		// An explicit sampling priority overrides both the sampler
+		// and the decision of the parent.
+		sp.setSamplingPriority(n)
+	} else if len(sp.raw.References) == 0 || parent.Flags&FlagSamplingDeferred != 0 {
+		d := sampleSpan(tr.Sampler, SamplingParameters{
+			TraceID:       sp.raw.TraceID,
+			TraceIDHigh:   sp.raw.TraceIDHigh,
+			SpanID:        sp.raw.SpanID,
+			ServiceName:   tr.ServiceName,
+			OperationName: operationName,
+			Tags:          sopts.Tags,
+		})
+		if d.Sampled {
 			sp.raw.Flags |= FlagSampled
+			if len(d.Tags) > 0 {
+				// Don't modify the caller's map of tags.
+				tags := make(map[string]interface{}, len(sopts.Tags)+len(d.Tags))
+				for k, v := range sopts.Tags {
+					tags[k] = v
+				}
+				sp.raw.Tags = mergeTags(tags, d.Tags)
+			}
+		}
+	}
+	if sp.sampled() {
+		tr.startSpanProcessors(&sp.raw)
+		if tr.SpanTracker != nil {
+			tr.SpanTracker.add(sp)
 		}
 	}
-	sp.raw.Tags = sopts.Tags
 	return sp
 }
 
+// spanContext converts a referenced span context to a SpanContext.
+// Span contexts of other tracers are converted via the
+// SpanContextConverter interface. Span contexts that cannot be
+// converted are logged and ignored, which turns the span into a root
+// span if it has no other references.
+func (tr *Tracer) spanContext(sm opentracing.SpanContext) (SpanContext, bool) {
+	switch sm := sm.(type) {
+	case nil:
+		return SpanContext{}, false
+	case SpanContext:
+		return sm, sm.TraceID != 0 || sm.TraceIDHigh != 0
+	case SpanContextConverter:
+		context := sm.TracerSpanContext()
+		if context.TraceID == 0 && context.TraceIDHigh == 0 {
+			tr.Logger.Printf("ignoring reference to span context without trace ID: %T", sm)
+			return SpanContext{}, false
+		}
+		return context, true
+	default:
+		tr.Logger.Printf("ignoring reference to unsupported span context: %T", sm)
+		return SpanContext{}, false
+	}
+}
+
+// generateTraceID returns the trace ID of a new root span. It is
+// generated separately from the span ID, so that trace IDs don't
+// reveal span IDs.
+func (tr *Tracer) generateTraceID() uint64 {
+	if g, ok := tr.idGenerator.(TraceIDGenerator); ok {
+		return g.GenerateTraceID()
+	}
+	return tr.idGenerator.GenerateID()
+}
+
+func (tr *Tracer) now() time.Time {
+	if tr.Now == nil {
+		return time.Now()
+	}
+	return tr.Now()
+}
+
+// Flush waits for processors to handle all finished spans, then
+// flushes the storer if it implements Flusher.
+//
+// If the tracer has a SpanTracker, spans that haven't finished yet
+// are stored first, with UnfinishedTag set to true and the current
+// time as their finish time. Each unfinished span is only stored by
+// one call to Flush. Spans that finish afterwards are stored again;
+// whether that replaces the unfinished version depends on the
+// storage.
 func (tr *Tracer) Flush() error {
+	if tr.SpanTracker != nil {
+		for _, sp := range tr.SpanTracker.unfinished(tr.now()) {
+			tr.finish(sp)
+		}
+	}
+	tr.flushProcessors()
 	f, ok := tr.storer.(Flusher)
 	if !ok {
 		return nil
@@ -332,6 +743,25 @@ func (tr *Tracer) Flush() error {
 	return f.Flush()
 }
 
+// Close flushes the tracer like Flush and stops the goroutine that
+// runs processors. Spans that finish after Close are dropped if the
+// tracer has processors. Close doesn't close the storer.
+func (tr *Tracer) Close() error {
+	err := tr.Flush()
+	if len(tr.Processors) == 0 {
+		return err
+	}
+	tr.processorOnce.Do(tr.startProcessing)
+	tr.processorMu.Lock()
+	if !tr.processorClosed {
+		tr.processorClosed = true
+		close(tr.processorCh)
+	}
+	tr.processorMu.Unlock()
+	<-tr.processorDone
+	return err
+}
+
 func idToHex(id uint64) string {
 	b := make([]byte, 8)
 	binary.BigEndian.PutUint64(b, id)
@@ -343,13 +773,50 @@ func idFromHex(s string) uint64 {
 	return binary.BigEndian.Uint64(b)
 }
 
+// FormatTraceID formats a trace ID as hexadecimal digits. Trace IDs
+// without high bits are formatted as 16 digits, 128-bit trace IDs as
+// 32 digits.
+func FormatTraceID(high, low uint64) string {
+	if high == 0 {
+		return idToHex(low)
+	}
+	return idToHex(high) + idToHex(low)
+}
+
+// ParseTraceID parses a trace ID made up of up to 32 hexadecimal
+// digits. It is the inverse of FormatTraceID, but also accepts IDs
+// without leading zeros.
+func ParseTraceID(s string) (high, low uint64, err error) {
+	if len(s) == 0 || len(s) > 32 {
+		return 0, 0, fmt.Errorf("invalid trace ID %q", s)
+	}
+	lowDigits := s
+	if len(s) > 16 {
+		lowDigits = s[len(s)-16:]
+		high, err = strconv.ParseUint(s[:len(s)-16], 16, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid trace ID %q", s)
+		}
+	}
+	low, err = strconv.ParseUint(lowDigits, 16, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid trace ID %q", s)
+	}
+	return high, low, nil
+}
+
 // Inject implements the opentracing.Tracer interface.
 func (tr *Tracer) Inject(sm opentracing.SpanContext, format interface{}, carrier interface{}) error {
-	context, ok := sm.(SpanContext)
-	if !ok {
+	var context SpanContext
+	switch sm := sm.(type) {
+	case SpanContext:
+		context = sm
+	case SpanContextConverter:
+		context = sm.TracerSpanContext()
+	default:
 		return opentracing.ErrInvalidSpanContext
 	}
-	injecter, ok := injecters[format]
+	injecter, ok := tr.propagation().Injecter(format)
 	if !ok {
 		return opentracing.ErrUnsupportedFormat
 	}
@@ -358,23 +825,68 @@ func (tr *Tracer) Inject(sm opentracing.SpanContext, format interface{}, carrier
 
 // Extract implements the opentracing.Tracer interface.
 func (tr *Tracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
-	extracter, ok := extracters[format]
+	extracter, ok := tr.propagation().Extracter(format)
 	if !ok {
 		return nil, opentracing.ErrUnsupportedFormat
 	}
 	context, err := extracter(carrier)
+	if tr.hasDebugHeader(carrier) {
+		if err == opentracing.ErrSpanContextNotFound {
+			context, err = SpanContext{Baggage: map[string]string{}}, nil
+		}
+		context.Flags |= FlagDebug | FlagSampled
+	}
 	if err != nil {
 		return nil, err
 	}
 	return context, nil
 }
 
+func (tr *Tracer) hasDebugHeader(carrier interface{}) bool {
+	if tr.DebugHeader == "" {
+		return false
+	}
+	r, ok := carrier.(opentracing.TextMapReader)
+	if !ok {
+		return false
+	}
+	found := false
+	_ = r.ForeachKey(func(key, val string) error {
+		if strings.EqualFold(key, tr.DebugHeader) {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+func (tr *Tracer) propagation() *Propagation {
+	if tr.Propagation == nil {
+		return defaultPropagation
+	}
+	return tr.Propagation
+}
+
+// Formats returns the propagation formats supported by Inject and
+// Extract.
+func (tr *Tracer) Formats() []interface{} {
+	return tr.propagation().Formats()
+}
+
 // IDGenerator generates IDs for traces and spans. The ID with value 0
 // is reserved to mean "no parent span" and should not be generated.
 type IDGenerator interface {
 	GenerateID() uint64
 }
 
+// TraceIDGenerator is an optional interface that IDGenerators can
+// implement to generate trace IDs differently from span IDs. Other
+// IDGenerators are called a second time to generate the trace ID of
+// a root span. Trace IDs must not be 0 either.
+type TraceIDGenerator interface {
+	GenerateTraceID() uint64
+}
+
 // A Storer stores a finished span. "Storing" a span may either mean
 // saving it in a storage engine, or sending it to a remote
 // collector.
@@ -0,0 +1,433 @@
+package tracer
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+// recordingStorer records stored spans. Tests that store spans
+// concurrently must hold mu while reading spans.
+type recordingStorer struct {
+	mu    sync.Mutex
+	spans []RawSpan
+}
+
+func (r *recordingStorer) Store(sp RawSpan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, sp)
+	return nil
+}
+
+func TestReferences(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("", storer, RandomID{})
+	p1 := tr.StartSpan("p1")
+	p2 := tr.StartSpan("p2")
+	p3 := tr.StartSpan("p3")
+	sp := tr.StartSpan("child",
+		opentracing.FollowsFrom(p1.Context()),
+		opentracing.ChildOf(p2.Context()),
+		opentracing.ChildOf(p3.Context()))
+	sp.Finish()
+
+	c1 := p1.Context().(SpanContext)
+	c2 := p2.Context().(SpanContext)
+	c3 := p3.Context().(SpanContext)
+	raw := storer.spans[0]
+	want := []RawReference{
+		{TraceID: c1.TraceID, SpanID: c1.SpanID, Kind: RelationFollowsFrom},
+		{TraceID: c2.TraceID, SpanID: c2.SpanID, Kind: RelationChildOf},
+		{TraceID: c3.TraceID, SpanID: c3.SpanID, Kind: RelationChildOf},
+	}
+	if len(raw.References) != len(want) {
+		t.Fatalf("got %d references, want %d", len(raw.References), len(want))
+	}
+	for i := range want {
+		if raw.References[i] != want[i] {
+			t.Errorf("reference %d: got %v, want %v", i, raw.References[i], want[i])
+		}
+	}
+	if raw.ParentID != c2.SpanID || raw.TraceID != c2.TraceID {
+		t.Errorf("got parent (%d, %d), want (%d, %d)",
+			raw.TraceID, raw.ParentID, c2.TraceID, c2.SpanID)
+	}
+
+	sp = tr.StartSpan("follower", opentracing.FollowsFrom(p1.Context()))
+	sp.Finish()
+	raw = storer.spans[1]
+	if raw.ParentID != c1.SpanID || raw.TraceID != c1.TraceID {
+		t.Errorf("got parent (%d, %d), want (%d, %d)",
+			raw.TraceID, raw.ParentID, c1.TraceID, c1.SpanID)
+	}
+}
+
+type recordingLogger struct {
+	msgs []string
+}
+
+func (l *recordingLogger) Printf(format string, values ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, values...))
+}
+
+type foreignContext struct{}
+
+func (foreignContext) ForeachBaggageItem(func(k, v string) bool) {}
+
+type convertibleContext struct {
+	ctx SpanContext
+}
+
+func (convertibleContext) ForeachBaggageItem(func(k, v string) bool) {}
+
+func (c convertibleContext) TracerSpanContext() SpanContext {
+	return c.ctx
+}
+
+func TestForeignReferences(t *testing.T) {
+	storer := &recordingStorer{}
+	logger := &recordingLogger{}
+	tr := NewTracer("", storer, RandomID{})
+	tr.Logger = logger
+	noop := opentracing.NoopTracer{}.StartSpan("noop")
+	ours := tr.StartSpan("ours").Context().(SpanContext)
+
+	tests := []struct {
+		name   string
+		opts   []opentracing.StartSpanOption
+		parent SpanContext
+		refs   int
+		logs   int
+	}{
+		{"noop", []opentracing.StartSpanOption{opentracing.ChildOf(noop.Context())}, SpanContext{}, 0, 1},
+		{"foreign", []opentracing.StartSpanOption{opentracing.ChildOf(foreignContext{})}, SpanContext{}, 0, 1},
+		{"nil", []opentracing.StartSpanOption{opentracing.ChildOf(nil)}, SpanContext{}, 0, 0},
+		{"convertible", []opentracing.StartSpanOption{opentracing.ChildOf(convertibleContext{ours})}, ours, 1, 0},
+		{"convertible without trace", []opentracing.StartSpanOption{opentracing.ChildOf(convertibleContext{})}, SpanContext{}, 0, 1},
+		{"mixed", []opentracing.StartSpanOption{
+			opentracing.ChildOf(foreignContext{}),
+			opentracing.FollowsFrom(ours),
+		}, ours, 1, 1},
+	}
+	for _, tt := range tests {
+		storer.spans = nil
+		logger.msgs = nil
+		sp := tr.StartSpan(tt.name, tt.opts...)
+		sp.Finish()
+		if len(storer.spans) != 1 {
+			t.Errorf("%s: got %d stored spans, want 1", tt.name, len(storer.spans))
+			continue
+		}
+		raw := storer.spans[0]
+		if len(raw.References) != tt.refs {
+			t.Errorf("%s: got %d references, want %d", tt.name, len(raw.References), tt.refs)
+		}
+		if len(logger.msgs) != tt.logs {
+			t.Errorf("%s: got %d log messages, want %d: %q", tt.name, len(logger.msgs), tt.logs, logger.msgs)
+		}
+		if tt.parent.SpanID == 0 {
+			if raw.ParentID != 0 || raw.TraceID == 0 || raw.TraceID == raw.SpanID {
+				t.Errorf("%s: expected a root span, got parent %d in trace %d", tt.name, raw.ParentID, raw.TraceID)
+			}
+			continue
+		}
+		if raw.ParentID != tt.parent.SpanID || raw.TraceID != tt.parent.TraceID {
+			t.Errorf("%s: got parent (%d, %d), want (%d, %d)", tt.name,
+				raw.TraceID, raw.ParentID, tt.parent.TraceID, tt.parent.SpanID)
+		}
+	}
+}
+
+func TestInjectConvertible(t *testing.T) {
+	tr := NewTracer("", &recordingStorer{}, RandomID{})
+	ours := tr.StartSpan("ours").Context().(SpanContext)
+
+	carrier := opentracing.TextMapCarrier{}
+	if err := tr.Inject(convertibleContext{ours}, opentracing.TextMap, carrier); err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	context, err := tr.Extract(opentracing.TextMap, carrier)
+	if err != nil {
+		t.Fatal("unexpected error: ", err)
+	}
+	if context.(SpanContext).TraceID != ours.TraceID {
+		t.Errorf("got trace ID %d, want %d", context.(SpanContext).TraceID, ours.TraceID)
+	}
+
+	if err := tr.Inject(foreignContext{}, opentracing.TextMap, carrier); err != opentracing.ErrInvalidSpanContext {
+		t.Errorf("got error %v, want %v", err, opentracing.ErrInvalidSpanContext)
+	}
+}
+
+func TestLateSamplingPriority(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("", storer, RandomID{})
+	tr.Sampler = NewConstSampler(false)
+
+	sp := tr.StartSpan("escalated")
+	if sp.(*Span).Sampled() {
+		t.Fatal("span shouldn't be sampled")
+	}
+	ext.SamplingPriority.Set(sp, 1)
+	if !sp.(*Span).Sampled() {
+		t.Fatal("span should be sampled after setting sampling priority")
+	}
+	child := tr.StartSpan("child", opentracing.ChildOf(sp.Context()))
+	if !child.(*Span).Sampled() {
+		t.Error("child span should inherit the new sampling decision")
+	}
+	carrier := opentracing.TextMapCarrier{}
+	if err := tr.Inject(sp.Context(), opentracing.TextMap, carrier); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := tr.Extract(opentracing.TextMap, carrier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.(SpanContext).Flags&FlagSampled == 0 {
+		t.Error("injected context should be sampled")
+	}
+	child.Finish()
+	sp.Finish()
+	if len(storer.spans) != 2 {
+		t.Fatalf("got %d stored spans, expected 2", len(storer.spans))
+	}
+
+	tr.Sampler = NewConstSampler(true)
+	sp = tr.StartSpan("dropped")
+	sp.SetTag(string(ext.SamplingPriority), 0)
+	child = tr.StartSpan("child", opentracing.ChildOf(sp.Context()))
+	if child.(*Span).Sampled() {
+		t.Error("child span should inherit the new sampling decision")
+	}
+	child.Finish()
+	sp.Finish()
+	if len(storer.spans) != 2 {
+		t.Errorf("got %d stored spans, expected 2", len(storer.spans))
+	}
+
+	sp = tr.StartSpan("child", opentracing.ChildOf(sp.Context()), opentracing.Tag{Key: string(ext.SamplingPriority), Value: uint16(1)})
+	if !sp.(*Span).Sampled() {
+		t.Error("sampling priority start tag should override the parent's decision")
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("", storer, RandomID{})
+	tr.Logger = &recordingLogger{}
+
+	sp := tr.StartSpan("logs")
+	sp.LogFields(otlog.String("event", "retry"), otlog.Int("attempt", 2), otlog.Bool("final", false))
+	sp.LogKV("event", "timeout", "after", 1.5)
+	sp.LogEvent("done")
+	sp.LogEventWithPayload("error", "connection reset")
+	sp.LogFields(otlog.Object("unsupported", struct{}{}))
+	sp.Finish()
+
+	want := [][]RawLogField{
+		{{"event", "retry"}, {"attempt", 2}, {"final", false}},
+		{{"event", "timeout"}, {"after", 1.5}},
+		{{"event", "done"}},
+		{{"event", "error"}, {"payload", "connection reset"}},
+	}
+	logs := storer.spans[0].Logs
+	if len(logs) != len(want) {
+		t.Fatalf("got %d log entries, expected %d", len(logs), len(want))
+	}
+	for i, l := range logs {
+		if l.Timestamp.IsZero() {
+			t.Errorf("log entry %d has no timestamp", i)
+		}
+		if !reflect.DeepEqual(l.Fields, want[i]) {
+			t.Errorf("got fields %v for log entry %d, expected %v", l.Fields, i, want[i])
+		}
+	}
+}
+
+func TestDebugHeader(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("", storer, RandomID{})
+	tr.Sampler = NewConstSampler(false)
+
+	carrier := opentracing.HTTPHeadersCarrier{}
+	carrier.Set("Tracer-Debug", "1")
+	ctx, err := tr.Extract(opentracing.HTTPHeaders, carrier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := tr.StartSpan("debug", opentracing.ChildOf(ctx))
+	raw := sp.(*Span).raw
+	if raw.ParentID != 0 || len(raw.References) != 0 {
+		t.Errorf("span should be a root span, got parent %d and references %v", raw.ParentID, raw.References)
+	}
+	if raw.Flags != FlagSampled|FlagDebug {
+		t.Errorf("got flags %b, want %b", raw.Flags, FlagSampled|FlagDebug)
+	}
+	sp.SetTag(string(ext.SamplingPriority), 0)
+	if !sp.(*Span).Sampled() {
+		t.Error("sampling priority shouldn't drop debug spans")
+	}
+
+	carrier = opentracing.HTTPHeadersCarrier{}
+	if err := tr.Inject(sp.Context(), opentracing.HTTPHeaders, carrier); err != nil {
+		t.Fatal(err)
+	}
+	carrier.Set("Tracer-Debug", "1")
+	ctx, err = tr.Extract(opentracing.HTTPHeaders, carrier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := tr.StartSpan("child", opentracing.ChildOf(ctx))
+	if raw := child.(*Span).raw; raw.ParentID != sp.(*Span).raw.SpanID || raw.Flags != FlagSampled|FlagDebug {
+		t.Errorf("got parent %d and flags %b, want %d and %b",
+			raw.ParentID, raw.Flags, sp.(*Span).raw.SpanID, FlagSampled|FlagDebug)
+	}
+
+	tr.DebugHeader = ""
+	carrier = opentracing.HTTPHeadersCarrier{}
+	carrier.Set("Tracer-Debug", "1")
+	if _, err := tr.Extract(opentracing.HTTPHeaders, carrier); err != opentracing.ErrSpanContextNotFound {
+		t.Errorf("got error %v, want %v", err, opentracing.ErrSpanContextNotFound)
+	}
+}
+
+func TestBaggage(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("", storer, RandomID{})
+	tr.BaggageLimits = BaggageLimits{
+		MaxItemSize: 10,
+		MaxSize:     20,
+		AllowedKeys: []string{"user", "tenant", "region"},
+	}
+
+	root := tr.StartSpan("root")
+	root.SetBaggageItem("user", "alice")
+	ctx := root.Context()
+	child := tr.StartSpan("child", opentracing.ChildOf(ctx))
+	if v := child.BaggageItem("user"); v != "alice" {
+		t.Errorf("child has baggage item %q, expected alice", v)
+	}
+
+	child.SetBaggageItem("tenant", "acme")
+	if v := root.BaggageItem("tenant"); v != "" {
+		t.Errorf("setting baggage on child changed parent's baggage to %q", v)
+	}
+	root.SetBaggageItem("user", "bob")
+	if v := ctx.(SpanContext).Baggage["user"]; v != "alice" {
+		t.Errorf("setting baggage changed existing span context to %q", v)
+	}
+	if v := child.BaggageItem("user"); v != "alice" {
+		t.Errorf("setting baggage on parent changed child's baggage to %q", v)
+	}
+
+	child.SetBaggageItem("session", "1")
+	child.SetBaggageItem("tenant", "a very long tenant")
+	child.SetBaggageItem("region", "eu")
+	child.SetBaggageItem("tenant", "acme")
+	child.Finish()
+
+	want := map[string]string{"user": "alice", "tenant": "acme"}
+	if !reflect.DeepEqual(storer.spans[0].Baggage, want) {
+		t.Errorf("got baggage %v, expected %v", storer.spans[0].Baggage, want)
+	}
+	var reasons []interface{}
+	for _, l := range storer.spans[0].Logs {
+		reasons = append(reasons, l.Fields[2].Value)
+	}
+	wantReasons := []interface{}{"key_not_allowed", "item_too_large", "baggage_too_large"}
+	if !reflect.DeepEqual(reasons, wantReasons) {
+		t.Errorf("got rejections %v, expected %v", reasons, wantReasons)
+	}
+}
+
+func TestBaggageLimits(t *testing.T) {
+	baggage := map[string]string{"user": "alice", "tenant": "acme"}
+	tests := []struct {
+		limits     BaggageLimits
+		key, value string
+		want       string
+	}{
+		{BaggageLimits{}, "anything", strings.Repeat("x", 1<<16), ""},
+		{BaggageLimits{AllowedKeys: []string{"user"}}, "user", "bob", ""},
+		{BaggageLimits{AllowedKeys: []string{"user"}}, "session", "1", "key_not_allowed"},
+		{BaggageLimits{AllowedKeys: []string{}}, "user", "bob", "key_not_allowed"},
+		{BaggageLimits{MaxItemSize: 7}, "user", "bob", ""},
+		{BaggageLimits{MaxItemSize: 7}, "user", "carol", "item_too_large"},
+		// The existing items take up 19 bytes.
+		{BaggageLimits{MaxSize: 25}, "region", "", ""},
+		{BaggageLimits{MaxSize: 25}, "region", "e", "baggage_too_large"},
+		// Replacing an item only counts its new size.
+		{BaggageLimits{MaxSize: 19}, "user", "alice", ""},
+		{BaggageLimits{MaxSize: 19}, "user", "alicia", "baggage_too_large"},
+	}
+	for _, test := range tests {
+		if got := test.limits.check(baggage, test.key, test.value); got != test.want {
+			t.Errorf("%+v: setting %s=%q: got %q, expected %q", test.limits, test.key, test.value, got, test.want)
+		}
+	}
+}
+
+func TestBaggageNoDefaultLimits(t *testing.T) {
+	tr := NewTracer("", &recordingStorer{}, RandomID{})
+	sp := tr.StartSpan("root")
+	value := strings.Repeat("x", 1<<16)
+	sp.SetBaggageItem("large", value)
+	if sp.BaggageItem("large") != value {
+		t.Error("baggage item was rejected without limits")
+	}
+}
+
+func TestBaggageConcurrent(t *testing.T) {
+	tr := NewTracer("", &recordingStorer{}, RandomID{})
+	tr.BaggageLimits = BaggageLimits{MaxSize: 1 << 10}
+	sp := tr.StartSpan("root")
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				sp.SetBaggageItem(fmt.Sprintf("k%d", i%10), fmt.Sprintf("%d-%d", g, i))
+			}
+		}(g)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				child := tr.StartSpan("child", opentracing.ChildOf(sp.Context()))
+				child.SetBaggageItem("child", "1")
+				child.BaggageItem("k1")
+				child.Context().ForeachBaggageItem(func(k, v string) bool { return true })
+				child.Finish()
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(sp.(*Span).raw.Baggage); n != 10 {
+		t.Errorf("got %d baggage items, expected 10", n)
+	}
+}
+
+func TestResource(t *testing.T) {
+	storer := &recordingStorer{}
+	tr := NewTracer("", storer, RandomID{})
+	if pid, ok := tr.Resource[ResourcePID].(int); !ok || pid == 0 {
+		t.Errorf("got PID %v in default resource", tr.Resource[ResourcePID])
+	}
+	tr.Resource = map[string]interface{}{ResourceHostname: "web1", ResourceVersion: "1.2.3"}
+	root := tr.StartSpan("root")
+	tr.StartSpan("child", opentracing.ChildOf(root.Context())).Finish()
+	root.Finish()
+	for _, sp := range storer.spans {
+		if !reflect.DeepEqual(sp.Resource, tr.Resource) {
+			t.Errorf("span %s has resource %v, expected %v", sp.OperationName, sp.Resource, tr.Resource)
+		}
+	}
+}
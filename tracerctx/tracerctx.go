@@ -0,0 +1,95 @@
+// Package tracerctx propagates spans inside a process via
+// context.Context.
+//
+// Spans stored in a context are only read through their locked
+// accessors, so a context may be shared by multiple goroutines, each
+// starting spans of their own.
+package tracerctx
+
+import (
+	"time"
+
+	"github.com/tracer/tracer"
+
+	"github.com/opentracing/opentracing-go"
+	"golang.org/x/net/context"
+)
+
+// Tags and log fields recorded by the helpers.
+const (
+	// The deadline of the context a span was started with,
+	// formatted as RFC 3339 with nanoseconds.
+	DeadlineTag = "context.deadline"
+	// The event logged by Finish if the context was done before the
+	// span finished. The log entry's error field contains the
+	// context's error.
+	DoneEvent = "context done"
+)
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx that carries sp.
+func ContextWithSpan(ctx context.Context, sp *tracer.Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, sp)
+}
+
+// SpanFromContext returns the span stored in ctx, or nil if there is
+// none.
+func SpanFromContext(ctx context.Context) *tracer.Span {
+	sp, _ := ctx.Value(spanKey{}).(*tracer.Span)
+	return sp
+}
+
+// parentContext returns the span context of the span stored in ctx.
+// Spans stored by opentracing.ContextWithSpan are used if ctx
+// carries no span of its own.
+func parentContext(ctx context.Context) opentracing.SpanContext {
+	if sp := SpanFromContext(ctx); sp != nil {
+		return sp.Context()
+	}
+	if sp := opentracing.SpanFromContext(ctx); sp != nil {
+		return sp.Context()
+	}
+	return nil
+}
+
+// StartSpanFromContext starts a span that is a child of the span
+// stored in ctx, or a root span if there is none, in which case tr's
+// sampler decides whether it is sampled. It returns the span and a
+// copy of ctx that carries it.
+//
+// If ctx has a deadline, it is recorded in the DeadlineTag tag.
+func StartSpanFromContext(ctx context.Context, tr *tracer.Tracer, operationName string, opts ...opentracing.StartSpanOption) (*tracer.Span, context.Context) {
+	if parent := parentContext(ctx); parent != nil {
+		opts = append(opts, opentracing.ChildOf(parent))
+	}
+	return startSpan(ctx, tr, operationName, opts)
+}
+
+// StartFollowerFromContext is like StartSpanFromContext, but the new
+// span follows from the span stored in ctx instead of being its
+// child. This is useful for work that the span in ctx doesn't wait
+// for, such as asynchronous jobs.
+func StartFollowerFromContext(ctx context.Context, tr *tracer.Tracer, operationName string, opts ...opentracing.StartSpanOption) (*tracer.Span, context.Context) {
+	if parent := parentContext(ctx); parent != nil {
+		opts = append(opts, opentracing.FollowsFrom(parent))
+	}
+	return startSpan(ctx, tr, operationName, opts)
+}
+
+func startSpan(ctx context.Context, tr *tracer.Tracer, operationName string, opts []opentracing.StartSpanOption) (*tracer.Span, context.Context) {
+	if deadline, ok := ctx.Deadline(); ok {
+		opts = append(opts, opentracing.Tag{Key: DeadlineTag, Value: deadline.Format(time.RFC3339Nano)})
+	}
+	sp := tr.StartSpan(operationName, opts...).(*tracer.Span)
+	return sp, ContextWithSpan(ctx, sp)
+}
+
+// Finish finishes sp. If ctx was canceled or its deadline exceeded,
+// a DoneEvent is logged first.
+func Finish(ctx context.Context, sp *tracer.Span) {
+	if err := ctx.Err(); err != nil {
+		sp.LogKV("event", DoneEvent, "error", err.Error())
+	}
+	sp.Finish()
+}
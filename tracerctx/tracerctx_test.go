@@ -0,0 +1,129 @@
+package tracerctx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tracer/tracer"
+	"github.com/tracer/tracer/tracertest"
+
+	"github.com/opentracing/opentracing-go"
+	"golang.org/x/net/context"
+)
+
+func TestStartSpanFromContext(t *testing.T) {
+	storer := &tracertest.Recorder{}
+	tr := tracer.NewTracer("", storer, tracer.RandomID{})
+
+	root, ctx := StartSpanFromContext(context.Background(), tr, "root")
+	if SpanFromContext(ctx) != root {
+		t.Fatal("returned context doesn't carry the new span")
+	}
+	child, _ := StartSpanFromContext(ctx, tr, "child")
+	follower, _ := StartFollowerFromContext(ctx, tr, "follower")
+
+	rootCtx := root.Context().(tracer.SpanContext)
+	for _, c := range []struct {
+		sp   *tracer.Span
+		kind string
+	}{{child, tracer.RelationChildOf}, {follower, tracer.RelationFollowsFrom}} {
+		raw := c.sp.RawSpan()
+		if raw.TraceID != rootCtx.TraceID || raw.ParentID != rootCtx.SpanID {
+			t.Errorf("%s: got parent (%d, %d), expected (%d, %d)",
+				raw.OperationName, raw.TraceID, raw.ParentID, rootCtx.TraceID, rootCtx.SpanID)
+		}
+		if len(raw.References) != 1 || raw.References[0].Kind != c.kind {
+			t.Errorf("%s: got references %v, expected a single %s reference",
+				raw.OperationName, raw.References, c.kind)
+		}
+	}
+}
+
+func TestStartSpanFromOpenTracingContext(t *testing.T) {
+	tr := tracer.NewTracer("", &tracertest.Recorder{}, tracer.RandomID{})
+	parent := tr.StartSpan("parent")
+	ctx := opentracing.ContextWithSpan(context.Background(), parent)
+	child, _ := StartSpanFromContext(ctx, tr, "child")
+	if child.RawSpan().ParentID != parent.Context().(tracer.SpanContext).SpanID {
+		t.Error("span stored by opentracing.ContextWithSpan wasn't used as parent")
+	}
+}
+
+func TestRootSpanSampler(t *testing.T) {
+	storer := &tracertest.Recorder{}
+	tr := tracer.NewTracer("", storer, tracer.RandomID{})
+	tr.Sampler = tracer.NewConstSampler(false)
+	sp, ctx := StartSpanFromContext(context.Background(), tr, "root")
+	child, _ := StartSpanFromContext(ctx, tr, "child")
+	if sp.Sampled() || child.Sampled() {
+		t.Error("expected tracer's sampler to be used for root spans")
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	storer := &tracertest.Recorder{}
+	tr := tracer.NewTracer("", storer, tracer.RandomID{})
+	deadline := time.Now().Add(-time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	sp, ctx := StartSpanFromContext(ctx, tr, "late")
+	Finish(ctx, sp)
+
+	raw := storer.Spans()[0]
+	if v := raw.Tags[DeadlineTag]; v != deadline.Format(time.RFC3339Nano) {
+		t.Errorf("got deadline tag %v, expected %s", v, deadline.Format(time.RFC3339Nano))
+	}
+	checkDoneLog(t, raw, context.DeadlineExceeded)
+}
+
+func TestCancel(t *testing.T) {
+	storer := &tracertest.Recorder{}
+	tr := tracer.NewTracer("", storer, tracer.RandomID{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sp, ctx := StartSpanFromContext(ctx, tr, "canceled")
+	if _, ok := sp.RawSpan().Tags[DeadlineTag]; ok {
+		t.Error("span without deadline has deadline tag")
+	}
+	cancel()
+	Finish(ctx, sp)
+	checkDoneLog(t, storer.Spans()[0], context.Canceled)
+
+	sp, ctx = StartSpanFromContext(context.Background(), tr, "done")
+	Finish(ctx, sp)
+	if logs := storer.Spans()[1].Logs; len(logs) != 0 {
+		t.Errorf("got logs %v for span whose context wasn't done", logs)
+	}
+}
+
+func checkDoneLog(t *testing.T, raw tracer.RawSpan, err error) {
+	if len(raw.Logs) != 1 {
+		t.Fatalf("got %d log entries, expected 1", len(raw.Logs))
+	}
+	fields := raw.Logs[0].Fields
+	if len(fields) != 2 || fields[0].Value != DoneEvent || fields[1].Value != err.Error() {
+		t.Errorf("got log fields %v, expected %s with error %q", fields, DoneEvent, err)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	tr := tracer.NewTracer("", &tracertest.Recorder{}, tracer.RandomID{})
+	root, ctx := StartSpanFromContext(context.Background(), tr, "root")
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			for j := 0; j < 100; j++ {
+				sp, _ := StartSpanFromContext(ctx, tr, "child")
+				sp.SetBaggageItem("k", "v")
+			}
+			done <- struct{}{}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		root.SetBaggageItem("k", "v")
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+}
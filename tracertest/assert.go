@@ -0,0 +1,94 @@
+package tracertest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tracer/tracer"
+)
+
+// FindSpan returns the first span of a trace with an operation name.
+func FindSpan(trace tracer.RawTrace, operationName string) (tracer.RawSpan, bool) {
+	for _, sp := range trace.Spans {
+		if sp.OperationName == operationName {
+			return sp, true
+		}
+	}
+	return tracer.RawSpan{}, false
+}
+
+func findSpanByID(trace tracer.RawTrace, id uint64) (tracer.RawSpan, bool) {
+	for _, sp := range trace.Spans {
+		if sp.SpanID == id {
+			return sp, true
+		}
+	}
+	return tracer.RawSpan{}, false
+}
+
+// AssertSpan checks that a trace contains a span with an operation
+// name whose parent has the operation name parent. An empty parent
+// expects a root span. It returns the span.
+func AssertSpan(t testing.TB, trace tracer.RawTrace, operationName, parent string) tracer.RawSpan {
+	sp, ok := FindSpan(trace, operationName)
+	if !ok {
+		t.Errorf("trace %s has no span %q", tracer.FormatTraceID(trace.TraceIDHigh, trace.TraceID), operationName)
+		return sp
+	}
+	if parent == "" {
+		if sp.ParentID != 0 {
+			t.Errorf("span %q has parent %016x, expected a root span", operationName, sp.ParentID)
+		}
+		return sp
+	}
+	p, ok := findSpanByID(trace, sp.ParentID)
+	switch {
+	case !ok:
+		t.Errorf("span %q has parent %016x, which isn't part of the trace, expected %q", operationName, sp.ParentID, parent)
+	case p.OperationName != parent:
+		t.Errorf("span %q has parent %q, expected %q", operationName, p.OperationName, parent)
+	}
+	return sp
+}
+
+// AssertTag checks that a span has a tag with a value.
+func AssertTag(t testing.TB, sp tracer.RawSpan, key string, value interface{}) {
+	v, ok := sp.Tags[key]
+	switch {
+	case !ok:
+		t.Errorf("span %q has no tag %q", sp.OperationName, key)
+	case !reflect.DeepEqual(v, value):
+		t.Errorf("span %q has tag %s=%#v, expected %#v", sp.OperationName, key, v, value)
+	}
+}
+
+// AssertLog checks that one of a span's log entries contains a field
+// with a value.
+func AssertLog(t testing.TB, sp tracer.RawSpan, key string, value interface{}) {
+	for _, l := range sp.Logs {
+		for _, f := range l.Fields {
+			if f.Key == key && reflect.DeepEqual(f.Value, value) {
+				return
+			}
+		}
+	}
+	t.Errorf("span %q has no log field %s=%#v, got %v", sp.OperationName, key, value, sp.Logs)
+}
+
+// AssertRelation checks that a trace contains a relation of a kind,
+// one of tracer.RelationChildOf and tracer.RelationFollowsFrom,
+// between the spans with the operation names parent and child.
+func AssertRelation(t testing.TB, trace tracer.RawTrace, parent, child, kind string) {
+	for _, rel := range trace.Relations {
+		p, _ := findSpanByID(trace, rel.ParentID)
+		c, _ := findSpanByID(trace, rel.ChildID)
+		if p.OperationName != parent || c.OperationName != child {
+			continue
+		}
+		if rel.Kind != kind {
+			t.Errorf("span %q is related to %q by %q, expected %q", child, parent, rel.Kind, kind)
+		}
+		return
+	}
+	t.Errorf("trace %s has no relation between %q and %q", tracer.FormatTraceID(trace.TraceIDHigh, trace.TraceID), parent, child)
+}
@@ -0,0 +1,128 @@
+// Package tracertest provides helpers for testing code that is
+// instrumented with a tracer.Tracer.
+//
+// NewTracer returns a tracer that records spans in memory, uses
+// sequential IDs and a fake clock, which makes traces reproducible.
+// The Assert functions check the recorded traces.
+package tracertest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/tracer/tracer"
+)
+
+// NewTracer returns a tracer that samples all spans and records them
+// in the returned Recorder. IDs start at 1 and the clock starts at
+// 2016-01-01 00:00:00 UTC. Unlike tracer.NewTracer, it doesn't set a
+// resource, because the default one describes the machine the test
+// runs on.
+func NewTracer(serviceName string) (*tracer.Tracer, *Recorder, *Clock) {
+	rec := &Recorder{}
+	clock := NewClock(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	tr := tracer.NewTracer(serviceName, rec, &tracer.SequentialID{})
+	tr.Now = clock.Now
+	tr.Resource = nil
+	return tr, rec, clock
+}
+
+// A Recorder is a tracer.Storer that records spans in memory. It is
+// safe for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []tracer.RawSpan
+}
+
+// Store implements the tracer.Storer interface.
+func (r *Recorder) Store(sp tracer.RawSpan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, sp)
+	return nil
+}
+
+// Spans returns all recorded spans, in the order they were stored.
+func (r *Recorder) Spans() []tracer.RawSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]tracer.RawSpan(nil), r.spans...)
+}
+
+// Reset forgets all recorded spans.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = nil
+}
+
+// Trace assembles the recorded spans of a trace into a RawTrace.
+// Relations are derived from the spans' references.
+func (r *Recorder) Trace(high, low uint64) tracer.RawTrace {
+	trace := tracer.RawTrace{TraceID: low, TraceIDHigh: high}
+	for _, sp := range r.Spans() {
+		if sp.TraceID != low || sp.TraceIDHigh != high {
+			continue
+		}
+		trace.Spans = append(trace.Spans, sp)
+		for _, ref := range sp.References {
+			trace.Relations = append(trace.Relations, tracer.RawRelation{
+				ParentID: ref.SpanID,
+				ChildID:  sp.SpanID,
+				Kind:     ref.Kind,
+			})
+		}
+	}
+	return trace
+}
+
+// Traces returns all recorded traces, ordered by their first stored
+// span.
+func (r *Recorder) Traces() []tracer.RawTrace {
+	type traceID struct{ high, low uint64 }
+	seen := map[traceID]bool{}
+	var traces []tracer.RawTrace
+	for _, sp := range r.Spans() {
+		id := traceID{sp.TraceIDHigh, sp.TraceID}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		traces = append(traces, r.Trace(id.high, id.low))
+	}
+	return traces
+}
+
+// A Clock is a fake clock that only changes when told to. It is safe
+// for concurrent use.
+type Clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewClock returns a clock that is set to t.
+func NewClock(t time.Time) *Clock {
+	return &Clock{t: t}
+}
+
+// Now returns the clock's current time. It can be used as
+// tracer.Tracer.Now.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// Set sets the clock to t.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = t
+}
@@ -0,0 +1,92 @@
+package tracertest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tracer/tracer"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+func TestTracer(t *testing.T) {
+	tr, rec, clock := NewTracer("frontend")
+
+	root := tr.StartSpan("request")
+	clock.Advance(time.Second)
+	child := tr.StartSpan("query", opentracing.ChildOf(root.Context()))
+	child.SetTag("rows", 3)
+	child.LogKV("event", "cache miss")
+	clock.Advance(time.Second)
+	child.Finish()
+	job := tr.StartSpan("job", opentracing.FollowsFrom(root.Context()))
+	job.Finish()
+	root.Finish()
+
+	traces := rec.Traces()
+	if len(traces) != 1 {
+		t.Fatalf("got %d traces, expected 1", len(traces))
+	}
+	trace := traces[0]
+	// The root span has ID 1 and its trace ID 2.
+	if trace.TraceID != 2 || len(trace.Spans) != 3 {
+		t.Fatalf("got trace %d with %d spans, expected trace 2 with 3 spans", trace.TraceID, len(trace.Spans))
+	}
+	AssertSpan(t, trace, "request", "")
+	sp := AssertSpan(t, trace, "query", "request")
+	AssertSpan(t, trace, "job", "request")
+	AssertTag(t, sp, "rows", 3)
+	AssertLog(t, sp, "event", "cache miss")
+	AssertRelation(t, trace, "request", "query", tracer.RelationChildOf)
+	AssertRelation(t, trace, "request", "job", tracer.RelationFollowsFrom)
+
+	if sp.SpanID != 3 {
+		t.Errorf("got span ID %d, expected 3", sp.SpanID)
+	}
+	start := time.Date(2016, 1, 1, 0, 0, 1, 0, time.UTC)
+	if !sp.StartTime.Equal(start) || sp.FinishTime.Sub(sp.StartTime) != time.Second {
+		t.Errorf("got span from %s to %s, expected 1s starting at %s", sp.StartTime, sp.FinishTime, start)
+	}
+	if ts := sp.Logs[0].Timestamp; !ts.Equal(start) {
+		t.Errorf("got log timestamp %s, expected %s", ts, start)
+	}
+	if sp.Resource != nil {
+		t.Errorf("got resource %v, expected none", sp.Resource)
+	}
+
+	rec.Reset()
+	if len(rec.Spans()) != 0 {
+		t.Error("recorder still has spans after reset")
+	}
+}
+
+type recordingTB struct {
+	testing.TB
+	errors int
+}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors++
+}
+
+func TestAssertFailures(t *testing.T) {
+	tr, rec, _ := NewTracer("")
+	root := tr.StartSpan("request")
+	child := tr.StartSpan("query", opentracing.ChildOf(root.Context()))
+	child.Finish()
+	root.Finish()
+	trace := rec.Trace(0, 2)
+
+	rt := &recordingTB{TB: t}
+	AssertSpan(rt, trace, "missing", "")
+	AssertSpan(rt, trace, "query", "")
+	AssertSpan(rt, trace, "request", "query")
+	sp, _ := FindSpan(trace, "query")
+	AssertTag(rt, sp, "rows", 3)
+	AssertLog(rt, sp, "event", "cache miss")
+	AssertRelation(rt, trace, "request", "query", tracer.RelationFollowsFrom)
+	AssertRelation(rt, trace, "query", "request", tracer.RelationChildOf)
+	if rt.errors != 7 {
+		t.Errorf("got %d failed assertions, expected 7", rt.errors)
+	}
+}
@@ -0,0 +1,228 @@
+package tracer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// UnfinishedTag is set to true on spans that were stored by
+// Tracer.Flush before they finished.
+const UnfinishedTag = "tracer.unfinished"
+
+// SpanTrackerOptions are options for a SpanTracker.
+type SpanTrackerOptions struct {
+	// Spans that have been open longer than this are reported.
+	// Zero means one minute.
+	Threshold time.Duration
+	// How often to check for spans that have been open longer than
+	// Threshold. Zero means Threshold.
+	ReportInterval time.Duration
+	// Where to report spans. If nil, the default logger will be
+	// used.
+	Logger Logger
+	// Returns the current time, used to determine the age of spans.
+	// It should be the same clock as the tracer's Now. If nil,
+	// time.Now is used.
+	Now func() time.Time
+	// Where to register the tracker's metrics. If nil,
+	// prometheus.DefaultRegisterer will be used.
+	Registerer prometheus.Registerer
+}
+
+type trackedSpan struct {
+	start    time.Time
+	reported bool
+	emitted  bool
+}
+
+// A SpanTracker keeps track of the sampled spans of a tracer that
+// haven't finished yet. It periodically reports spans that have been
+// open for too long, which usually indicates that they leaked.
+//
+// The number of active spans and the age of the oldest one are
+// available via Count and Oldest and the tracer_active_spans and
+// tracer_oldest_active_span_seconds metrics.
+type SpanTracker struct {
+	opts SpanTrackerOptions
+
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// mu is acquired before the locks of spans.
+	mu    sync.Mutex
+	spans map[*Span]*trackedSpan
+	nowFn func() time.Time
+
+	active prometheus.Gauge
+	oldest prometheus.Gauge
+}
+
+// NewSpanTracker returns a new SpanTracker and starts a goroutine
+// that reports spans that have been open for too long, until Stop is
+// called. Use it by setting Tracer.SpanTracker.
+func NewSpanTracker(opts SpanTrackerOptions) *SpanTracker {
+	t := newSpanTracker(opts)
+	go t.loop()
+	return t
+}
+
+func newSpanTracker(opts SpanTrackerOptions) *SpanTracker {
+	if opts.Threshold == 0 {
+		opts.Threshold = time.Minute
+	}
+	if opts.ReportInterval == 0 {
+		opts.ReportInterval = opts.Threshold
+	}
+	if opts.Logger == nil {
+		opts.Logger = defaultLogger{}
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Registerer == nil {
+		opts.Registerer = prometheus.DefaultRegisterer
+	}
+	t := &SpanTracker{
+		opts:  opts,
+		done:  make(chan struct{}),
+		spans: map[*Span]*trackedSpan{},
+		nowFn: opts.Now,
+
+		active: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "tracer_active_spans",
+			Help: "Number of sampled spans that haven't finished yet",
+		}),
+		oldest: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "tracer_oldest_active_span_seconds",
+			Help: "Age of the oldest span that hasn't finished yet",
+		}),
+	}
+	for _, c := range []prometheus.Collector{t.active, t.oldest} {
+		if err := opts.Registerer.Register(c); err != nil {
+			t.opts.Logger.Printf("couldn't register prometheus collector: %s", err)
+		}
+	}
+	return t
+}
+
+func (t *SpanTracker) loop() {
+	tick := time.NewTicker(t.opts.ReportInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			t.report()
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// Stop stops reporting spans. The tracker keeps tracking spans.
+func (t *SpanTracker) Stop() {
+	t.stopOnce.Do(func() { close(t.done) })
+}
+
+func (t *SpanTracker) add(sp *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.spans[sp] = &trackedSpan{start: t.nowFn()}
+	t.active.Set(float64(len(t.spans)))
+}
+
+func (t *SpanTracker) remove(sp *Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.spans, sp)
+	t.active.Set(float64(len(t.spans)))
+}
+
+// Count returns the number of spans that haven't finished yet.
+func (t *SpanTracker) Count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.spans)
+}
+
+// Oldest returns how long the oldest span that hasn't finished yet
+// has been open, or zero if there are no such spans.
+func (t *SpanTracker) Oldest() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.oldestAge(t.nowFn())
+}
+
+func (t *SpanTracker) oldestAge(now time.Time) time.Duration {
+	var oldest time.Duration
+	for _, ts := range t.spans {
+		if age := now.Sub(ts.start); age > oldest {
+			oldest = age
+		}
+	}
+	return oldest
+}
+
+// report logs all sampled spans that have been open longer than the
+// threshold and haven't been reported before.
+func (t *SpanTracker) report() {
+	type leak struct {
+		operation string
+		traceID   string
+		age       time.Duration
+	}
+	t.mu.Lock()
+	now := t.nowFn()
+	t.oldest.Set(t.oldestAge(now).Seconds())
+	var leaks []leak
+	for sp, ts := range t.spans {
+		if ts.reported || now.Sub(ts.start) < t.opts.Threshold {
+			continue
+		}
+		sp.mu.RLock()
+		if sp.sampled() {
+			ts.reported = true
+			leaks = append(leaks, leak{
+				operation: sp.raw.OperationName,
+				traceID:   FormatTraceID(sp.raw.TraceIDHigh, sp.raw.TraceID),
+				age:       now.Sub(ts.start),
+			})
+		}
+		sp.mu.RUnlock()
+	}
+	t.mu.Unlock()
+
+	for _, l := range leaks {
+		t.opts.Logger.Printf("span %q of trace %s hasn't finished after %s",
+			l.operation, l.traceID, l.age)
+	}
+}
+
+// unfinished returns copies of all sampled spans that haven't
+// finished and haven't been returned by unfinished before, with
+// UnfinishedTag set and their finish time set to now. Spans whose
+// sampling priority was lowered to zero after they started are
+// skipped.
+func (t *SpanTracker) unfinished(now time.Time) []RawSpan {
+	t.mu.Lock()
+	var raws []RawSpan
+	for sp, ts := range t.spans {
+		if ts.emitted {
+			continue
+		}
+		sp.mu.RLock()
+		if sp.sampled() {
+			ts.emitted = true
+			raws = append(raws, sp.raw.copy())
+		}
+		sp.mu.RUnlock()
+	}
+	t.mu.Unlock()
+
+	for i := range raws {
+		raws[i].FinishTime = now
+		raws[i].Tags[UnfinishedTag] = true
+	}
+	return raws
+}
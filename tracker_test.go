@@ -0,0 +1,153 @@
+package tracer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSpanTracker(t *testing.T) {
+	storer := &recordingStorer{}
+	logger := &recordingLogger{}
+	tr := NewTracer("", storer, RandomID{})
+	now := time.Unix(0, 0)
+	tracker := newSpanTracker(SpanTrackerOptions{
+		Threshold:  time.Minute,
+		Logger:     logger,
+		Now:        func() time.Time { return now },
+		Registerer: prometheus.NewRegistry(),
+	})
+	tr.SpanTracker = tracker
+
+	leaked := tr.StartSpan("leaked")
+	now = now.Add(30 * time.Second)
+	finished := tr.StartSpan("finished")
+	tr.Sampler = NewConstSampler(false)
+	unsampled := tr.StartSpan("unsampled")
+	if n := tracker.Count(); n != 2 {
+		t.Errorf("got %d active spans, expected 2", n)
+	}
+	now = now.Add(40 * time.Second)
+	if d := tracker.Oldest(); d != 70*time.Second {
+		t.Errorf("got oldest span age %s, expected 70s", d)
+	}
+
+	finished.Finish()
+	unsampled.Finish()
+	if n := tracker.Count(); n != 1 {
+		t.Errorf("got %d active spans after finishing, expected 1", n)
+	}
+
+	tracker.report()
+	tracker.report()
+	if len(logger.msgs) != 1 || !strings.Contains(logger.msgs[0], `"leaked"`) {
+		t.Errorf("got reports %q, expected a single report of the leaked span", logger.msgs)
+	}
+
+	storer.spans = nil
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(storer.spans) != 1 {
+		t.Fatalf("got %d stored spans, expected 1", len(storer.spans))
+	}
+	raw := storer.spans[0]
+	if raw.OperationName != "leaked" || raw.Tags[UnfinishedTag] != true || raw.FinishTime.IsZero() {
+		t.Errorf("got span %s with tags %v, expected leaked span with %s", raw.OperationName, raw.Tags, UnfinishedTag)
+	}
+	if _, ok := leaked.(*Span).RawSpan().Tags[UnfinishedTag]; ok {
+		t.Error("flushing modified the unfinished span")
+	}
+	leaked.Finish()
+	if n := tracker.Count(); n != 0 {
+		t.Errorf("got %d active spans, expected 0", n)
+	}
+}
+
+func TestSpanTrackerUnsampled(t *testing.T) {
+	storer := &recordingStorer{}
+	logger := &recordingLogger{}
+	tr := NewTracer("", storer, RandomID{})
+	now := time.Unix(0, 0)
+	tracker := newSpanTracker(SpanTrackerOptions{
+		Threshold:  time.Minute,
+		Logger:     logger,
+		Now:        func() time.Time { return now },
+		Registerer: prometheus.NewRegistry(),
+	})
+	tr.SpanTracker = tracker
+
+	sp := tr.StartSpan("dropped")
+	sp.SetTag(string(ext.SamplingPriority), uint16(0))
+	now = now.Add(2 * time.Minute)
+
+	tracker.report()
+	if len(logger.msgs) != 0 {
+		t.Errorf("got reports %q, expected none", logger.msgs)
+	}
+	storer.spans = nil
+	if err := tr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(storer.spans) != 0 {
+		t.Errorf("got %d stored spans, expected 0", len(storer.spans))
+	}
+}
+
+func TestSpanTrackerStop(t *testing.T) {
+	tracker := NewSpanTracker(SpanTrackerOptions{
+		ReportInterval: time.Millisecond,
+		Logger:         &recordingLogger{},
+		Registerer:     prometheus.NewRegistry(),
+	})
+	time.Sleep(5 * time.Millisecond)
+	tracker.Stop()
+	tracker.Stop()
+	select {
+	case <-tracker.done:
+	default:
+		t.Error("tracker wasn't stopped")
+	}
+}
+
+func TestSpanTrackerRegisterer(t *testing.T) {
+	logger := &recordingLogger{}
+	var regs []*prometheus.Registry
+	for i := 0; i < 2; i++ {
+		reg := prometheus.NewRegistry()
+		regs = append(regs, reg)
+		tr := NewTracer("", &recordingStorer{}, RandomID{})
+		tr.SpanTracker = newSpanTracker(SpanTrackerOptions{Logger: logger, Registerer: reg})
+		for j := 0; j <= i; j++ {
+			tr.StartSpan("op")
+		}
+	}
+	if len(logger.msgs) != 0 {
+		t.Errorf("got errors registering metrics: %v", logger.msgs)
+	}
+	for i, reg := range regs {
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, mf := range mfs {
+			if mf.GetName() != "tracer_active_spans" {
+				continue
+			}
+			found = true
+			if got := mf.GetMetric()[0].GetGauge().GetValue(); got != float64(i+1) {
+				t.Errorf("tracker %d: got %v active spans, expected %d", i, got, i+1)
+			}
+		}
+		if !found {
+			t.Errorf("tracker %d: tracer_active_spans wasn't registered", i)
+		}
+	}
+}
@@ -4,15 +4,16 @@ package grpc
 import (
 	"errors"
 	"net"
+	"sort"
 
 	"github.com/tracer/tracer"
 	"github.com/tracer/tracer/internal/pbutil"
 	"github.com/tracer/tracer/pb"
 	"github.com/tracer/tracer/server"
 
-	"github.com/opentracing/opentracing-go"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 func init() {
@@ -43,51 +44,156 @@ func (g *GRPC) Start() error {
 	}
 	s := grpc.NewServer()
 	pb.RegisterStorerServer(s, g)
+	pb.RegisterSamplingServer(s, g)
 	return s.Serve(l)
 }
 
 func (g *GRPC) Store(ctx context.Context, req *pb.StoreRequest) (*pb.StoreResponse, error) {
+	var resource map[string]interface{}
+	if len(req.Resource) > 0 {
+		resource = map[string]interface{}{}
+		for _, tag := range req.Resource {
+			resource[tag.Key] = pbutil.TagValue(tag)
+		}
+	}
+	// All spans are decoded before storing any of them, so that
+	// invalid requests don't store parts of the batch.
+	spans := make([]tracer.RawSpan, 0, len(req.Spans))
 	for _, span := range req.Spans {
 		st, err := pbutil.Timestamp(span.StartTime)
 		if err != nil {
-			return nil, err
+			return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
 		}
 		ft, err := pbutil.Timestamp(span.FinishTime)
 		if err != nil {
-			return nil, err
+			return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
 		}
 		sp := tracer.RawSpan{
 			SpanContext: tracer.SpanContext{
-				TraceID:  span.TraceId,
-				ParentID: span.ParentId,
-				SpanID:   span.SpanId,
-				Flags:    span.Flags,
+				TraceID:     span.TraceId,
+				TraceIDHigh: span.TraceIdHigh,
+				ParentID:    span.ParentId,
+				SpanID:      span.SpanId,
+				Flags:       span.Flags,
 			},
 			ServiceName:   span.ServiceName,
 			OperationName: span.OperationName,
 			StartTime:     st,
 			FinishTime:    ft,
 			Tags:          map[string]interface{}{},
+			Resource:      resource,
+		}
+		for _, ref := range span.References {
+			kind := tracer.RelationChildOf
+			if ref.Kind == pb.Reference_FOLLOWS_FROM {
+				kind = tracer.RelationFollowsFrom
+			}
+			sp.References = append(sp.References, tracer.RawReference{
+				TraceID:     ref.TraceId,
+				TraceIDHigh: ref.TraceIdHigh,
+				SpanID:      ref.SpanId,
+				Kind:        kind,
+			})
+		}
+		if len(sp.References) == 0 && sp.ParentID != 0 {
+			// Older clients only send the parent ID.
+			sp.References = []tracer.RawReference{{
+				TraceID:     sp.TraceID,
+				TraceIDHigh: sp.TraceIDHigh,
+				SpanID:      sp.ParentID,
+				Kind:        tracer.RelationChildOf,
+			}}
 		}
 		for _, tag := range span.Tags {
 			if tag.Time != nil {
 				t, err := pbutil.Timestamp(tag.Time)
 				if err != nil {
-					return nil, err
+					return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
 				}
-				sp.Logs = append(sp.Logs, opentracing.LogData{
-					Event:     tag.Key,
-					Payload:   tag.Value,
+				// Older clients send log entries as tags.
+				sp.Logs = append(sp.Logs, tracer.RawLog{
 					Timestamp: t,
+					Fields: []tracer.RawLogField{
+						{Key: "event", Value: tag.Key},
+						{Key: "payload", Value: pbutil.TagValue(tag)},
+					},
 				})
 			} else {
-				sp.Tags[tag.Key] = tag.Value
+				sp.Tags[tag.Key] = pbutil.TagValue(tag)
+			}
+		}
+		for _, l := range span.Logs {
+			t, err := pbutil.Timestamp(l.Time)
+			if err != nil {
+				return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+			}
+			log := tracer.RawLog{Timestamp: t}
+			for _, f := range l.Fields {
+				log.Fields = append(log.Fields, tracer.RawLogField{
+					Key:   f.Key,
+					Value: pbutil.TagValue(f),
+				})
 			}
+			sp.Logs = append(sp.Logs, log)
 		}
+		spans = append(spans, sp)
+	}
 
+	if bs, ok := g.srv.Storage.(tracer.BatchStorer); ok {
+		if err := bs.StoreBatch(spans); err != nil {
+			return &pb.StoreResponse{}, storeError(err, false)
+		}
+		return &pb.StoreResponse{}, nil
+	}
+	for i, sp := range spans {
 		if err := g.srv.Storage.Store(sp); err != nil {
-			return &pb.StoreResponse{}, err
+			return &pb.StoreResponse{}, storeError(err, i > 0)
 		}
 	}
 	return &pb.StoreResponse{}, nil
 }
+
+// storeError converts an error of storing a batch to a gRPC error.
+// Clients retry batches that failed with codes.Unavailable, so it is
+// only used for errors that are likely temporary and if no part of
+// the batch was stored.
+func storeError(err error, partial bool) error {
+	if _, ok := err.(server.PermanentError); ok {
+		return grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if partial {
+		return grpc.Errorf(codes.Internal, "stored part of the batch: %s", err)
+	}
+	return grpc.Errorf(codes.Unavailable, "%s", err)
+}
+
+// GetSamplingStrategy implements the pb.SamplingServer interface.
+func (g *GRPC) GetSamplingStrategy(ctx context.Context, req *pb.SamplingStrategyRequest) (*pb.SamplingStrategyResponse, error) {
+	st := g.srv.Sampling.Strategy(req.ServiceName)
+	resp := &pb.SamplingStrategyResponse{}
+	if st.Default != nil {
+		resp.DefaultStrategy = samplingStrategy(*st.Default)
+	}
+	// Sort operations so that clients can detect unchanged
+	// strategies.
+	var ops []string
+	for op := range st.Operations {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		resp.Operations = append(resp.Operations, &pb.OperationSamplingStrategy{
+			OperationName: op,
+			Strategy:      samplingStrategy(st.Operations[op]),
+		})
+	}
+	return resp, nil
+}
+
+func samplingStrategy(s server.SamplingStrategy) *pb.SamplingStrategy {
+	typ := pb.SamplingStrategy_PROBABILISTIC
+	if s.Type == server.SamplingRateLimiting {
+		typ = pb.SamplingStrategy_RATE_LIMITING
+	}
+	return &pb.SamplingStrategy{Type: typ, Param: s.Param}
+}
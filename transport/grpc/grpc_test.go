@@ -0,0 +1,116 @@
+package grpc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tracer/tracer"
+	"github.com/tracer/tracer/pb"
+	"github.com/tracer/tracer/server"
+
+	"github.com/golang/protobuf/ptypes"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// failingStorage fails to store the span with ID failID.
+type failingStorage struct {
+	server.Storage
+
+	failID uint64
+	err    error
+	spans  []tracer.RawSpan
+}
+
+func (st *failingStorage) Store(sp tracer.RawSpan) error {
+	if sp.SpanID == st.failID {
+		return st.err
+	}
+	st.spans = append(st.spans, sp)
+	return nil
+}
+
+// batchStorage stores batches atomically.
+type batchStorage struct {
+	failingStorage
+	batches int
+}
+
+func (st *batchStorage) StoreBatch(spans []tracer.RawSpan) error {
+	st.batches++
+	for _, sp := range spans {
+		if sp.SpanID == st.failID {
+			return st.err
+		}
+	}
+	st.spans = append(st.spans, spans...)
+	return nil
+}
+
+func storeRequest(n int) *pb.StoreRequest {
+	ts, _ := ptypes.TimestampProto(time.Unix(0, 0))
+	req := &pb.StoreRequest{}
+	for i := 0; i < n; i++ {
+		req.Spans = append(req.Spans, &pb.Span{
+			SpanId:     uint64(i + 1),
+			TraceId:    1,
+			StartTime:  ts,
+			FinishTime: ts,
+		})
+	}
+	return req
+}
+
+func TestStore(t *testing.T) {
+	unavailable := errors.New("connection refused")
+	permanent := server.PermanentError{Err: errors.New("invalid byte sequence")}
+	tests := []struct {
+		name    string
+		storage server.Storage
+		code    codes.Code
+		stored  int
+	}{
+		{"batch", &batchStorage{}, codes.OK, 3},
+		{"batch unavailable", &batchStorage{failingStorage: failingStorage{failID: 2, err: unavailable}}, codes.Unavailable, 0},
+		{"batch permanent", &batchStorage{failingStorage: failingStorage{failID: 2, err: permanent}}, codes.InvalidArgument, 0},
+		{"single", &failingStorage{}, codes.OK, 3},
+		{"first span unavailable", &failingStorage{failID: 1, err: unavailable}, codes.Unavailable, 0},
+		{"partially stored", &failingStorage{failID: 2, err: unavailable}, codes.Internal, 1},
+		{"permanent", &failingStorage{failID: 2, err: permanent}, codes.InvalidArgument, 1},
+	}
+	for _, test := range tests {
+		g := &GRPC{srv: &server.Server{Storage: test.storage}}
+		_, err := g.Store(context.Background(), storeRequest(3))
+		if code := grpc.Code(err); code != test.code {
+			t.Errorf("%s: got code %s, expected %s", test.name, code, test.code)
+		}
+		var stored int
+		switch st := test.storage.(type) {
+		case *batchStorage:
+			stored = len(st.spans)
+			if st.batches != 1 {
+				t.Errorf("%s: got %d batches, expected 1", test.name, st.batches)
+			}
+		case *failingStorage:
+			stored = len(st.spans)
+		}
+		if stored != test.stored {
+			t.Errorf("%s: got %d stored spans, expected %d", test.name, stored, test.stored)
+		}
+	}
+}
+
+func TestStoreInvalidSpan(t *testing.T) {
+	st := &batchStorage{}
+	g := &GRPC{srv: &server.Server{Storage: st}}
+	req := storeRequest(3)
+	req.Spans[2].FinishTime.Nanos = -1
+	if _, err := g.Store(context.Background(), req); grpc.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, expected InvalidArgument", err)
+	}
+	if st.batches != 0 {
+		t.Errorf("stored %d batches of an invalid request, expected 0", st.batches)
+	}
+}
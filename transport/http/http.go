@@ -5,9 +5,15 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/tracer/tracer"
 	"github.com/tracer/tracer/server"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func init() {
@@ -28,6 +34,7 @@ func setup(srv *server.Server, conf map[string]interface{}) (server.QueryTranspo
 	h.mux.HandleFunc("/trace/", h.TraceByID)
 	h.mux.HandleFunc("/span/", h.SpanByID)
 	h.mux.HandleFunc("/trace/query/", h.QueryTraces)
+	h.mux.Handle("/metrics", promhttp.Handler())
 	return h, nil
 }
 
@@ -43,12 +50,12 @@ func (h *HTTP) Start() error {
 }
 
 func (h *HTTP) TraceByID(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 16, 64)
+	high, low, err := tracer.ParseTraceID(r.URL.Query().Get("id"))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	trace, err := h.srv.Storage.TraceByID(id)
+	trace, err := h.srv.Storage.TraceByID(high, low)
 	if err != nil {
 		// TODO(dh): handle 404 special
 		http.Error(w, err.Error(), 500)
@@ -75,5 +82,63 @@ func (h *HTTP) SpanByID(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HTTP) QueryTraces(w http.ResponseWriter, r *http.Request) {
+	q, err := parseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	traces, err := h.srv.Storage.QueryTraces(q)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(traces)
+}
 
+// parseQuery parses the parameters of a trace query. start and finish
+// are RFC 3339 timestamps, minDuration and maxDuration Go durations.
+// service, tag and resource may be repeated. Tags have the form key or
+// key=value, resources key=value.
+func parseQuery(v url.Values) (server.Query, error) {
+	var q server.Query
+	var err error
+	if s := v.Get("start"); s != "" {
+		if q.StartTime, err = time.Parse(time.RFC3339, s); err != nil {
+			return server.Query{}, err
+		}
+	}
+	if s := v.Get("finish"); s != "" {
+		if q.FinishTime, err = time.Parse(time.RFC3339, s); err != nil {
+			return server.Query{}, err
+		}
+	}
+	if s := v.Get("minDuration"); s != "" {
+		if q.MinDuration, err = time.ParseDuration(s); err != nil {
+			return server.Query{}, err
+		}
+	}
+	if s := v.Get("maxDuration"); s != "" {
+		if q.MaxDuration, err = time.ParseDuration(s); err != nil {
+			return server.Query{}, err
+		}
+	}
+	if s := v.Get("num"); s != "" {
+		if q.Num, err = strconv.Atoi(s); err != nil {
+			return server.Query{}, err
+		}
+	}
+	q.OperationName = v.Get("operation")
+	q.ServiceNames = v["service"]
+	q.Debug = v.Get("debug") == "true"
+	for _, tag := range v["tag"] {
+		if idx := strings.IndexByte(tag, '='); idx != -1 {
+			q.AndTags = append(q.AndTags, server.QueryTag{Key: tag[:idx], Value: tag[idx+1:], CheckValue: true})
+		} else {
+			q.AndTags = append(q.AndTags, server.QueryTag{Key: tag})
+		}
+	}
+	if q.Resource, err = server.ParseResource(v["resource"]); err != nil {
+		return server.Query{}, err
+	}
+	return q, nil
 }
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tracer/tracer/server"
+)
+
+func TestParseQuery(t *testing.T) {
+	v := url.Values{
+		"start":       {"2016-01-01T00:00:00Z"},
+		"minDuration": {"10ms"},
+		"num":         {"5"},
+		"operation":   {"GET /"},
+		"service":     {"frontend", "backend"},
+		"tag":         {"error", "http.method=GET"},
+		"resource":    {"service.version=1.2.3", "deployment.environment=production"},
+		"debug":       {"true"},
+	}
+	got, err := parseQuery(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := server.Query{
+		StartTime:     time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC),
+		MinDuration:   10 * time.Millisecond,
+		Num:           5,
+		OperationName: "GET /",
+		ServiceNames:  []string{"frontend", "backend"},
+		AndTags: []server.QueryTag{
+			{Key: "error"},
+			{Key: "http.method", Value: "GET", CheckValue: true},
+		},
+		Resource: map[string]string{
+			"service.version":        "1.2.3",
+			"deployment.environment": "production",
+		},
+		Debug: true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got query %+v, expected %+v", got, want)
+	}
+
+	for _, bad := range []url.Values{
+		{"start": {"yesterday"}},
+		{"maxDuration": {"10"}},
+		{"resource": {"version"}},
+		{"resource": {"=1.2.3"}},
+	} {
+		if _, err := parseQuery(bad); err == nil {
+			t.Errorf("%v: expected error", bad)
+		}
+	}
+}
@@ -6,9 +6,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"path"
@@ -116,9 +118,21 @@ func traceToZipkin(trace tracer.RawTrace) zipkinTrace {
 	ztrace := zipkinTrace{}
 	parents := map[uint64]uint64{}
 	for _, rel := range trace.Relations {
+		// Zipkin only supports a single parent. Prefer ChildOf
+		// relations over other kinds of relations.
+		if _, ok := parents[rel.ChildID]; ok && rel.Kind != tracer.RelationChildOf {
+			continue
+		}
 		parents[rel.ChildID] = rel.ParentID
 	}
+	// Parent IDs take precedence, but not all storages set them.
+	for _, span := range trace.Spans {
+		if span.ParentID != 0 {
+			parents[span.SpanID] = span.ParentID
+		}
+	}
 	for _, span := range trace.Spans {
+		endpoint := spanEndpoint(span)
 		var kind, opKind string
 		switch span.Tags["span.kind"] {
 		case "server":
@@ -131,21 +145,19 @@ func traceToZipkin(trace tracer.RawTrace) zipkinTrace {
 		zspan := zipkinSpan{
 			Annotations: []zipkinAnnotation{
 				{
-					Endpoint: zipkinEndpoint{
-						ServiceName: span.ServiceName,
-					},
+					Endpoint:  endpoint,
 					Timestamp: int(span.StartTime.UnixNano()) / 1000,
 					Value:     kind,
 				},
 			},
 			BinaryAnnotations: []zipkinBinaryAnnotation{},
-			Debug:             false,
+			Debug:             span.Flags&tracer.FlagDebug != 0,
 			Duration:          int(span.FinishTime.Sub(span.StartTime)) / 1000,
 			ID:                fmt.Sprintf("%016x", span.SpanID),
 			Name:              span.OperationName,
 			ParentID:          fmt.Sprintf("%016x", parents[span.SpanID]),
 			Timestamp:         int(span.StartTime.UnixNano() / 1000),
-			TraceID:           fmt.Sprintf("%016x", trace.TraceID),
+			TraceID:           tracer.FormatTraceID(trace.TraceIDHigh, trace.TraceID),
 		}
 		if parents[span.SpanID] == 0 {
 			zspan.ParentID = ""
@@ -153,25 +165,40 @@ func traceToZipkin(trace tracer.RawTrace) zipkinTrace {
 		for k, v := range span.Tags {
 			vs := fmt.Sprintf("%v", v)
 			zspan.BinaryAnnotations = append(zspan.BinaryAnnotations, zipkinBinaryAnnotation{
-				Key:   k,
-				Value: vs,
+				Endpoint: endpoint,
+				Key:      k,
+				Value:    vs,
+			})
+		}
+		for k, v := range span.Resource {
+			if _, ok := span.Tags[k]; ok || k == tracer.ResourceIP || k == tracer.ResourcePort {
+				continue
+			}
+			zspan.BinaryAnnotations = append(zspan.BinaryAnnotations, zipkinBinaryAnnotation{
+				Endpoint: endpoint,
+				Key:      k,
+				Value:    fmt.Sprintf("%v", v),
 			})
 		}
+		// Zipkin annotations only have a single string value, so
+		// each log field becomes an annotation of its own.
 		for _, log := range span.Logs {
-			zspan.Annotations = append(zspan.Annotations,
-				zipkinAnnotation{
-					Endpoint: zipkinEndpoint{
-						ServiceName: span.ServiceName,
-					},
-					Timestamp: int(log.Timestamp.UnixNano()) / 1000,
-					Value:     log.Event,
-				})
+			for _, f := range log.Fields {
+				value := fmt.Sprintf("%s=%v", f.Key, f.Value)
+				if f.Key == "event" {
+					value = fmt.Sprintf("%v", f.Value)
+				}
+				zspan.Annotations = append(zspan.Annotations,
+					zipkinAnnotation{
+						Endpoint:  endpoint,
+						Timestamp: int(log.Timestamp.UnixNano()) / 1000,
+						Value:     value,
+					})
+			}
 		}
 		zspan.Annotations = append(zspan.Annotations,
 			zipkinAnnotation{
-				Endpoint: zipkinEndpoint{
-					ServiceName: span.ServiceName,
-				},
+				Endpoint:  endpoint,
 				Timestamp: int(span.FinishTime.UnixNano()) / 1000,
 				Value:     opKind,
 			})
@@ -181,6 +208,66 @@ func traceToZipkin(trace tracer.RawTrace) zipkinTrace {
 	return ztrace
 }
 
+// spanEndpoint returns the endpoint of a span, based on its service
+// name and resource.
+func spanEndpoint(span tracer.RawSpan) zipkinEndpoint {
+	ep := zipkinEndpoint{ServiceName: span.ServiceName}
+	if ip, ok := span.Resource[tracer.ResourceIP].(string); ok && net.ParseIP(ip).To4() != nil {
+		ep.IPv4 = ip
+	}
+	switch port := span.Resource[tracer.ResourcePort].(type) {
+	case float64:
+		ep.Port = int(port)
+	case int64:
+		ep.Port = int(port)
+	case uint64:
+		ep.Port = int(port)
+	case int:
+		ep.Port = port
+	}
+	return ep
+}
+
+// parseAnnotationQuery parses Zipkin's annotationQuery parameter,
+// which consists of terms separated by " and ". A term is either a
+// key, which matches spans with that tag, or key=value, which matches
+// spans whose tag has that value. As an extension, key<number,
+// key<=number, key>=number and key>number compare numeric tags. The
+// first operator of a term separates its key from its value.
+func parseAnnotationQuery(q string) ([]server.QueryTag, error) {
+	var tags []server.QueryTag
+	for _, term := range strings.Split(q, " and ") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		idx := strings.IndexAny(term, "<>=")
+		if idx == -1 {
+			tags = append(tags, server.QueryTag{Key: term})
+			continue
+		}
+		op := term[idx : idx+1]
+		if op != "=" && strings.HasPrefix(term[idx+1:], "=") {
+			op += "="
+		}
+		tag := server.QueryTag{Key: term[:idx]}
+		value := term[idx+len(op):]
+		if op == "=" {
+			tag.Value = value
+			tag.CheckValue = true
+		} else {
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number in annotation query: %q", term)
+			}
+			tag.Op = op
+			tag.Number = n
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
 func atoi(s string) int {
 	n, _ := strconv.Atoi(s)
 	return n
@@ -205,6 +292,18 @@ func (h *HTTP) Traces(w http.ResponseWriter, r *http.Request) {
 	if serviceName != "" {
 		svcNames = []string{serviceName}
 	}
+	tags, err := parseAnnotationQuery(r.URL.Query().Get("annotationQuery"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	// As an extension, resource=key=value only returns traces with
+	// a span whose resource has that attribute. It may be repeated.
+	resource, err := server.ParseResource(r.URL.Query()["resource"])
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
 
 	traces, err := h.srv.Storage.QueryTraces(server.Query{
 		StartTime:     endTs.Add(-lookback),
@@ -212,10 +311,12 @@ func (h *HTTP) Traces(w http.ResponseWriter, r *http.Request) {
 		OperationName: "",
 		MinDuration:   minDuration,
 		MaxDuration:   maxDuration,
-		AndTags:       nil,
+		AndTags:       tags,
 		OrTags:        nil,
 		Num:           limit,
 		ServiceNames:  svcNames,
+		Debug:         r.URL.Query().Get("debug") == "true",
+		Resource:      resource,
 	})
 	if err != nil {
 		http.Error(w, err.Error(), 500)
@@ -230,12 +331,12 @@ func (h *HTTP) Traces(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *HTTP) Trace(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseUint(path.Base(r.URL.Path), 16, 64)
+	high, low, err := tracer.ParseTraceID(path.Base(r.URL.Path))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
-	trace, err := h.srv.Storage.TraceByID(id)
+	trace, err := h.srv.Storage.TraceByID(high, low)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -0,0 +1,49 @@
+package zipkinhttp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tracer/tracer/server"
+)
+
+func TestParseAnnotationQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []server.QueryTag
+	}{
+		{"", nil},
+		{"error", []server.QueryTag{{Key: "error"}}},
+		{"error and http.method=GET", []server.QueryTag{
+			{Key: "error"},
+			{Key: "http.method", Value: "GET", CheckValue: true},
+		}},
+		{"http.status_code>=500 and size<1024", []server.QueryTag{
+			{Key: "http.status_code", Op: ">=", Number: 500},
+			{Key: "size", Op: "<", Number: 1024},
+		}},
+		{"retries>0 and latency<=0.5", []server.QueryTag{
+			{Key: "retries", Op: ">", Number: 0},
+			{Key: "latency", Op: "<=", Number: 0.5},
+		}},
+		{"url=/search?q=a<b", []server.QueryTag{
+			{Key: "url", Value: "/search?q=a<b", CheckValue: true},
+		}},
+	}
+	for _, test := range tests {
+		got, err := parseAnnotationQuery(test.q)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %+v, expected %+v", test.q, got, test.want)
+		}
+	}
+
+	for _, q := range []string{"size<big", "size>="} {
+		if got, err := parseAnnotationQuery(q); err == nil {
+			t.Errorf("%q: expected error, got %+v", q, got)
+		}
+	}
+}